@@ -0,0 +1,170 @@
+package client
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// CommandArgs returns a command and its arguments as the strings that Conn.Do sends as bulk strings. An Args argument
+// is expanded into its values. It returns an error if an argument can't be converted.
+func CommandArgs(cmd string, args ...interface{}) ([]string, error) {
+	command := make([]string, 1, len(args)+1)
+	command[0] = cmd
+	return appendArgs(command, args)
+}
+
+// appendArgs appends args to command, expanding Args. Errors give the argument's position in the command.
+func appendArgs(command []string, args []interface{}) ([]string, error) {
+	for _, arg := range args {
+		if a, ok := arg.(Args); ok {
+			var err error
+			if command, err = appendArgs(command, a); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		s, err := formatArg(arg)
+		if err != nil {
+			return nil, fmt.Errorf("client: %s argument %d: %v", command[0], len(command), err)
+		}
+		command = append(command, s)
+	}
+	return command, nil
+}
+
+// Args builds a list of command arguments, such as the fields and values of an HSET, from Go values. Each value is
+// sent as a bulk string, converted as Conn.Do converts its arguments. An Args may be passed to Do expanded, as in
+// c.Do("HSET", args...), or as a single argument.
+type Args []interface{}
+
+// Add appends values to args.
+func (args Args) Add(values ...interface{}) Args {
+	return append(args, values...)
+}
+
+// AddFlat appends v to args, flattened:
+//
+//   - A map appends each key followed by its value.
+//   - A slice or array other than a []byte appends each element.
+//   - A struct, or a non-nil pointer to one, appends the name and value of each exported field. The name is taken from
+//     the field's "redis" tag if it has one, as in `redis:"name"`, or is the field's name otherwise. A field tagged
+//     `redis:"-"` is skipped, as is one tagged with the omitempty option, as in `redis:"name,omitempty"`, if it has its
+//     zero value. The fields of embedded structs, and of non-nil pointers to embedded structs, are added as if they
+//     were the outer struct's.
+//
+// Any other value is appended as-is. Elements are not flattened further.
+func (args Args) AddFlat(v interface{}) Args {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Map:
+		iter := rv.MapRange()
+		for iter.Next() {
+			args = append(args, iter.Key().Interface(), iter.Value().Interface())
+		}
+		return args
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		for i := 0; i < rv.Len(); i++ {
+			args = append(args, rv.Index(i).Interface())
+		}
+		return args
+	case reflect.Ptr:
+		if rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+			break
+		}
+		return args.addStruct(rv.Elem())
+	case reflect.Struct:
+		return args.addStruct(rv)
+	}
+	return append(args, v)
+}
+
+// addStruct appends the names and values of rv's exported fields, as described by AddFlat.
+func (args Args) addStruct(rv reflect.Value) Args {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f, fv := rt.Field(i), rv.Field(i)
+		if f.Anonymous && f.Tag.Get("redis") == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr && ft.Elem().Kind() == reflect.Struct {
+				if fv.IsNil() {
+					continue
+				}
+				ft, fv = ft.Elem(), fv.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				args = args.addStruct(fv)
+				continue
+			}
+		}
+		if f.PkgPath != "" || !fv.CanInterface() {
+			continue // unexported, or promoted from an unexported embedded struct
+		}
+
+		name, opts := f.Name, ""
+		if tag := f.Tag.Get("redis"); tag != "" {
+			name, opts = tag, ""
+			if i := strings.IndexByte(tag, ','); i != -1 {
+				name, opts = tag[:i], tag[i+1:]
+			}
+			if name == "-" {
+				continue
+			} else if name == "" {
+				name = f.Name
+			}
+		}
+		if opts == "omitempty" && fv.IsZero() {
+			continue
+		}
+		args = append(args, name, fv.Interface())
+	}
+	return args
+}
+
+// formatArg formats a single command argument as a bulk string.
+func formatArg(arg interface{}) (string, error) {
+	switch v := arg.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int16:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int8:
+		return strconv.FormatInt(int64(v), 10), nil
+	case uint:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case fmt.Stringer:
+		return v.String(), nil
+	}
+	return "", fmt.Errorf("cannot send %T as a bulk string", arg)
+}
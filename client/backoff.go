@@ -0,0 +1,41 @@
+package client
+
+import "time"
+
+// setBackoffDefaults sets *lo to defLo if it isn't positive, and *hi to defHi if it's less than *lo. hi is raised to
+// lo if it's still less.
+func setBackoffDefaults(lo, hi *time.Duration, defLo, defHi time.Duration) {
+	if *lo <= 0 {
+		*lo = defLo
+	}
+	if *hi < *lo {
+		*hi = defHi
+		if *hi < *lo {
+			*hi = *lo
+		}
+	}
+}
+
+// backoff is a delay between attempts that starts at min and doubles after each failure, up to max.
+type backoff struct {
+	min, max time.Duration
+	delay    time.Duration
+}
+
+func newBackoff(min, max time.Duration) backoff {
+	return backoff{min: min, max: max, delay: min}
+}
+
+// next returns the delay before the next attempt and doubles the delay after it.
+func (b *backoff) next() time.Duration {
+	d := b.delay
+	if b.delay *= 2; b.delay > b.max {
+		b.delay = b.max
+	}
+	return d
+}
+
+// reset returns the delay to min after an attempt succeeds.
+func (b *backoff) reset() {
+	b.delay = b.min
+}
@@ -0,0 +1,40 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := newBackoff(time.Second, 5*time.Second)
+	var delays []time.Duration
+	for i := 0; i < 4; i++ {
+		delays = append(delays, b.next())
+	}
+	b.reset()
+	delays = append(delays, b.next())
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, time.Second}
+	if !reflect.DeepEqual(delays, want) {
+		t.Errorf("delays = %v; want %v", delays, want)
+	}
+}
+
+func TestSetBackoffDefaults(t *testing.T) {
+	cases := []struct {
+		lo, hi, wantLo, wantHi time.Duration
+	}{
+		{0, 0, time.Second, 10 * time.Second},
+		{2 * time.Second, 0, 2 * time.Second, 10 * time.Second},
+		{0, 3 * time.Second, time.Second, 3 * time.Second},
+		{time.Minute, 0, time.Minute, time.Minute},
+	}
+	for _, c := range cases {
+		lo, hi := c.lo, c.hi
+		setBackoffDefaults(&lo, &hi, time.Second, 10*time.Second)
+		if lo != c.wantLo || hi != c.wantHi {
+			t.Errorf("setBackoffDefaults(%v, %v) = %v, %v; want %v, %v", c.lo, c.hi, lo, hi, c.wantLo, c.wantHi)
+		}
+	}
+}
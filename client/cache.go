@@ -0,0 +1,497 @@
+package client
+
+import (
+	"bufio"
+	"container/list"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/resv"
+)
+
+// CacheOptions configures a Cache.
+type CacheOptions struct {
+	// MaxEntries is the maximum number of keys cached. The least recently used key is evicted to make room for a new
+	// one. Defaults to 10000.
+	MaxEntries int
+
+	// Broadcast enables tracking in broadcast mode, where the server sends invalidations for every key matching
+	// Prefixes, or for every key if Prefixes is empty, instead of only for the keys the cache has read. Only keys
+	// matching Prefixes are cached.
+	Broadcast bool
+	Prefixes  []string
+
+	// MaxIdle is the maximum number of idle connections kept for commands. Defaults to 10.
+	MaxIdle int
+	// Timeout limits the time to set up the invalidation connection and to read or write a command. Defaults to 5
+	// seconds.
+	Timeout time.Duration
+	// PingInterval is the time between PINGs sent on the invalidation connection to check that it is alive. Defaults
+	// to 30 seconds.
+	PingInterval time.Duration
+
+	// MinBackoff and MaxBackoff bound the delay between attempts to reconnect the invalidation connection. The delay
+	// doubles after each failed attempt. They default to 100 milliseconds and 10 seconds.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+func (o *CacheOptions) setDefaults() {
+	if o.MaxEntries <= 0 {
+		o.MaxEntries = 10000
+	}
+	if o.MaxIdle <= 0 {
+		o.MaxIdle = 10
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 5 * time.Second
+	}
+	if o.PingInterval <= 0 {
+		o.PingInterval = 30 * time.Second
+	}
+	setBackoffDefaults(&o.MinBackoff, &o.MaxBackoff, 100*time.Millisecond, 10*time.Second)
+}
+
+// Cache caches the replies to GET and HGETALL in memory, using the server's client tracking to evict keys when they
+// change. It requires a server that supports RESP3, such as Redis 6 or later.
+//
+// Invalidations are received on a dedicated connection that uses RESP3. Commands are sent on connections from a Pool,
+// each of which redirects its invalidations to that connection. If the invalidation connection is lost, the cache is
+// flushed and bypassed until it reconnects.
+type Cache struct {
+	dial func() (net.Conn, error)
+	opts CacheOptions
+	pool *Pool
+
+	closed    chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu sync.Mutex
+	// conn is the invalidation connection, nil while disconnected, and id is its client ID. ready is set once the
+	// pool has been reset to use it, and keys are only cached while it is set.
+	conn    net.Conn
+	id      int64
+	ready   bool
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[string]*list.Element
+	// pending holds the commands in flight for each key. Their replies are only cached if the key isn't invalidated
+	// before they are read.
+	pending map[string][]*flight
+}
+
+type cacheEntry struct {
+	key   string
+	cmd   string
+	value interface{} // nil for a GET of a missing key
+}
+
+type flight struct {
+	stale bool
+}
+
+// NewCache returns a Cache that connects using dial. If the invalidation connection can't be set up, NewCache returns
+// its error. Later connections are retried.
+func NewCache(dial func() (net.Conn, error), opts CacheOptions) (*Cache, error) {
+	opts.setDefaults()
+	c := &Cache{
+		dial:    dial,
+		opts:    opts,
+		closed:  make(chan struct{}),
+		done:    make(chan struct{}),
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		pending: make(map[string][]*flight),
+	}
+	c.pool = NewPool(c.dialConn, opts.MaxIdle)
+
+	conn, r, id, err := c.connect()
+	if err != nil {
+		c.pool.Close()
+		return nil, err
+	}
+	c.attach(conn, id)
+	go c.run(conn, r)
+	return c, nil
+}
+
+// DialCache returns a Cache connected to the server at addr on the named network.
+func DialCache(network, addr string, opts CacheOptions) (*Cache, error) {
+	opts.setDefaults()
+	return NewCache(func() (net.Conn, error) {
+		return net.DialTimeout(network, addr, opts.Timeout)
+	}, opts)
+}
+
+// Pool returns the pool of connections that commands are sent on.
+func (c *Cache) Pool() *Pool {
+	return c.pool
+}
+
+// Do sends a command without caching its reply. Writes sent through Do invalidate the cache like any other client's.
+func (c *Cache) Do(cmd string, args ...interface{}) fred.Resp {
+	return c.pool.Do(cmd, args...)
+}
+
+// Get returns the value of key, or ErrNil if key doesn't exist. Both are cached.
+func (c *Cache) Get(key string) (string, error) {
+	v, err := c.fetch("GET", key, func(resp fred.Resp) (interface{}, error) {
+		s, err := str(resp)
+		if err == ErrNil {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		return s, nil
+	})
+	if err != nil {
+		return "", err
+	} else if v == nil {
+		return "", ErrNil
+	}
+	return v.(string), nil
+}
+
+// HGetAll returns the fields and values of the hash at key. It returns an empty map if key doesn't exist. The returned
+// map is a copy and may be modified.
+func (c *Cache) HGetAll(key string) (map[string]string, error) {
+	v, err := c.fetch("HGETALL", key, func(resp fred.Resp) (interface{}, error) {
+		var fields map[string]string
+		if err := resp.Scan(&fields); err != nil {
+			return nil, err
+		}
+		return fields, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	fields := v.(map[string]string)
+	result := make(map[string]string, len(fields))
+	for k, v := range fields {
+		result[k] = v
+	}
+	return result, nil
+}
+
+// fetch returns the cached reply to cmd for key, or sends cmd, converts its reply with parse, and caches the result.
+func (c *Cache) fetch(cmd, key string, parse func(fred.Resp) (interface{}, error)) (interface{}, error) {
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok && elem.Value.(*cacheEntry).cmd == cmd {
+		c.lru.MoveToFront(elem)
+		v := elem.Value.(*cacheEntry).value
+		c.mu.Unlock()
+		return v, nil
+	}
+	var f *flight
+	if c.ready && c.cacheable(key) {
+		f = &flight{}
+		c.pending[key] = append(c.pending[key], f)
+	}
+	c.mu.Unlock()
+
+	v, err := parse(c.pool.Do(cmd, key))
+	if f == nil {
+		return v, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.land(key, f)
+	if err == nil && !f.stale {
+		c.store(&cacheEntry{key: key, cmd: cmd, value: v})
+	}
+	return v, err
+}
+
+// cacheable reports whether the server sends invalidations for key.
+func (c *Cache) cacheable(key string) bool {
+	if !c.opts.Broadcast || len(c.opts.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range c.opts.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// land removes f from the commands in flight for key. The caller must hold c.mu.
+func (c *Cache) land(key string, f *flight) {
+	flights := c.pending[key]
+	for i := range flights {
+		if flights[i] == f {
+			flights = append(flights[:i], flights[i+1:]...)
+			break
+		}
+	}
+	if len(flights) == 0 {
+		delete(c.pending, key)
+	} else {
+		c.pending[key] = flights
+	}
+}
+
+// store caches e, evicting the least recently used entry if the cache is full. The caller must hold c.mu.
+func (c *Cache) store(e *cacheEntry) {
+	if elem, ok := c.entries[e.key]; ok {
+		elem.Value = e
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.opts.MaxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *Cache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
+
+// invalidate evicts keys and keeps the replies to commands in flight for them from being cached. The caller must hold
+// c.mu.
+func (c *Cache) invalidate(keys []string) {
+	for _, key := range keys {
+		if elem, ok := c.entries[key]; ok {
+			c.remove(elem)
+		}
+		for _, f := range c.pending[key] {
+			f.stale = true
+		}
+	}
+}
+
+// flush evicts all keys. The caller must hold c.mu.
+func (c *Cache) flush() {
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+	for _, flights := range c.pending {
+		for _, f := range flights {
+			f.stale = true
+		}
+	}
+}
+
+// Len returns the number of keys cached.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Flush evicts all keys.
+func (c *Cache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flush()
+}
+
+// Close closes the invalidation connection and the pool.
+func (c *Cache) Close() error {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		close(c.closed)
+		if c.conn != nil {
+			c.conn.Close()
+		}
+		c.mu.Unlock()
+	})
+	<-c.done
+	return c.pool.Close()
+}
+
+// dialConn dials a connection for the pool and enables tracking on it, redirecting invalidations to the invalidation
+// connection. While it is disconnected, connections are dialed without tracking, and the pool is reset once it
+// reconnects.
+func (c *Cache) dialConn() (*Conn, error) {
+	nc, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	conn := NewConn(nc)
+	conn.ReadTimeout, conn.WriteTimeout = c.opts.Timeout, c.opts.Timeout
+
+	c.mu.Lock()
+	id := c.id
+	c.mu.Unlock()
+	if id == 0 {
+		return conn, nil
+	}
+
+	args := []interface{}{"TRACKING", "ON", "REDIRECT", id}
+	if c.opts.Broadcast {
+		args = append(args, "BCAST")
+		for _, prefix := range c.opts.Prefixes {
+			args = append(args, "PREFIX", prefix)
+		}
+	}
+	if resp := conn.Do("CLIENT", args...); resp.Err != nil {
+		conn.Close()
+		return nil, resp.Err
+	}
+	return conn, nil
+}
+
+// connect dials the invalidation connection, switches it to RESP3, and returns its client ID.
+func (c *Cache) connect() (net.Conn, *bufio.Reader, int64, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	conn.SetDeadline(time.Now().Add(c.opts.Timeout))
+	r, enc := bufio.NewReader(conn), resv.NewEncoder(conn)
+	enc.EncodeCommand("HELLO", "3")
+	enc.EncodeCommand("CLIENT", "ID")
+	if err = enc.Flush(); err != nil {
+		conn.Close()
+		return nil, nil, 0, err
+	}
+
+	if resp := fred.Read(r); resp.Err != nil {
+		conn.Close()
+		return nil, nil, 0, resp.Err
+	}
+	id, err := fred.Read(r).Int()
+	if err != nil {
+		conn.Close()
+		return nil, nil, 0, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, r, id, nil
+}
+
+// attach makes conn the invalidation connection. The pool is reset first, so that connections tracked by the previous
+// invalidation connection, or not tracked at all, aren't used once keys are cached again.
+func (c *Cache) attach(conn net.Conn, id int64) bool {
+	c.mu.Lock()
+	select {
+	case <-c.closed:
+		c.mu.Unlock()
+		conn.Close()
+		return false
+	default:
+	}
+	c.conn, c.id = conn, id
+	c.mu.Unlock()
+
+	c.pool.Reset()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ready = true
+	return true
+}
+
+// detach closes the invalidation connection and flushes the cache, since invalidations may have been missed.
+func (c *Cache) detach() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.Close()
+	c.conn, c.id, c.ready = nil, 0, false
+	c.flush()
+}
+
+// run reads invalidations and reconnects until the Cache is closed. conn is the first connection.
+func (c *Cache) run(conn net.Conn, r *bufio.Reader) {
+	defer close(c.done)
+
+	backoff := newBackoff(c.opts.MinBackoff, c.opts.MaxBackoff)
+	for {
+		if conn == nil {
+			var (
+				id  int64
+				err error
+			)
+			if conn, r, id, err = c.connect(); err != nil {
+				select {
+				case <-time.After(backoff.next()):
+				case <-c.closed:
+					return
+				}
+				continue
+			}
+			if !c.attach(conn, id) {
+				return
+			}
+		}
+
+		if c.read(conn, r) {
+			backoff.reset()
+		}
+		c.detach()
+		conn = nil
+
+		select {
+		case <-c.closed:
+			return
+		default:
+		}
+	}
+}
+
+// read reads invalidations from conn until it fails. It returns true if anything was read.
+func (c *Cache) read(conn net.Conn, r *bufio.Reader) (ok bool) {
+	stopPing := make(chan struct{})
+	defer close(stopPing)
+	go c.ping(conn, stopPing)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(c.opts.PingInterval + c.opts.Timeout))
+		resp := fred.Read(r)
+		if resp.Err != nil && !resp.IsType(fred.Err) {
+			return ok
+		}
+		ok = true
+		if resp.IsType(fred.Push) {
+			c.handle(resp)
+		}
+	}
+}
+
+// ping sends a PING every PingInterval until stop is closed.
+func (c *Cache) ping(conn net.Conn, stop <-chan struct{}) {
+	ticker := time.NewTicker(c.opts.PingInterval)
+	defer ticker.Stop()
+	enc := resv.NewEncoder(conn)
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		conn.SetWriteDeadline(time.Now().Add(c.opts.Timeout))
+		if enc.EncodeCommand("PING") == nil {
+			enc.Flush()
+		}
+	}
+}
+
+// handle handles a push message. An invalidate message holds the keys to evict, or nil if all keys must be evicted,
+// as after FLUSHALL.
+func (c *Cache) handle(resp fred.Resp) {
+	ary, err := resp.Array()
+	if err != nil || len(ary) != 2 {
+		return
+	}
+	if kind, _ := ary[0].Str(); kind != "invalidate" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ary[1].IsType(fred.Nil) {
+		c.flush()
+		return
+	}
+	keys, err := ary[1].StrList()
+	if err != nil {
+		return
+	}
+	c.invalidate(keys)
+}
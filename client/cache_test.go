@@ -0,0 +1,387 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/resv"
+)
+
+// trackingServer is a minimal Redis server with client tracking. Invalidations are pushed to the connection each
+// client redirects them to, so it is written directly on a net.Listener.
+type trackingServer struct {
+	l net.Listener
+
+	mu     sync.Mutex
+	nextID int64
+	conns  map[int64]*trackingConn
+	values map[string]interface{} // string or map[string]string
+	// readers holds the redirect targets of the default-mode clients that have read each key.
+	readers  map[string]map[int64]bool
+	gets     int
+	tracking [][]string // the arguments of each CLIENT TRACKING command
+}
+
+type trackingConn struct {
+	id   int64
+	conn net.Conn
+	// redirect is the ID of the connection that invalidations are sent to, or zero if tracking is off.
+	redirect int64
+	bcast    bool
+	prefixes []string
+}
+
+func startTrackingServer(t *testing.T) *trackingServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &trackingServer{
+		l:       l,
+		conns:   map[int64]*trackingConn{},
+		values:  map[string]interface{}{},
+		readers: map[string]map[int64]bool{},
+	}
+	t.Cleanup(func() {
+		l.Close()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, c := range s.conns {
+			c.conn.Close()
+		}
+	})
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *trackingServer) serve(conn net.Conn) {
+	s.mu.Lock()
+	s.nextID++
+	c := &trackingConn{id: s.nextID, conn: conn}
+	s.conns[c.id] = c
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c.id)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	for {
+		args, err := fred.Read(r).StrList()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		reply := s.handle(c, args)
+		s.mu.Unlock()
+		conn.Write(reply)
+	}
+}
+
+// handle returns the reply to a command. The caller must hold s.mu.
+func (s *trackingServer) handle(c *trackingConn, args []string) []byte {
+	reply := func(v interface{}) []byte {
+		p, _ := resv.MarshalRESP(v)
+		return p
+	}
+
+	switch cmd := strings.ToUpper(args[0]); cmd {
+	case "HELLO":
+		return []byte("%1\r\n+server\r\n+tracking\r\n")
+	case "PING":
+		return reply(resv.SimpleString("PONG"))
+
+	case "CLIENT":
+		switch strings.ToUpper(args[1]) {
+		case "ID":
+			return reply(c.id)
+		case "TRACKING":
+			s.tracking = append(s.tracking, args[2:])
+			c.redirect, _ = strconv.ParseInt(args[4], 10, 64)
+			for i := 5; i < len(args); i++ {
+				switch strings.ToUpper(args[i]) {
+				case "BCAST":
+					c.bcast = true
+				case "PREFIX":
+					i++
+					c.prefixes = append(c.prefixes, args[i])
+				}
+			}
+			return reply(resv.SimpleString("OK"))
+		}
+
+	case "GET", "HGETALL":
+		s.gets++
+		if c.redirect != 0 && !c.bcast {
+			if s.readers[args[1]] == nil {
+				s.readers[args[1]] = map[int64]bool{}
+			}
+			s.readers[args[1]][c.redirect] = true
+		}
+		v := s.values[args[1]]
+		if cmd == "HGETALL" {
+			fields, _ := v.(map[string]string)
+			flat := []string{}
+			for k, v := range fields {
+				flat = append(flat, k, v)
+			}
+			return reply(flat)
+		}
+		if v == nil {
+			return []byte("$-1\r\n")
+		}
+		return reply(v)
+
+	case "SET":
+		s.values[args[1]] = args[2]
+		s.invalidate(args[1])
+		return reply(resv.SimpleString("OK"))
+
+	case "HSET":
+		fields, _ := s.values[args[1]].(map[string]string)
+		if fields == nil {
+			fields = map[string]string{}
+			s.values[args[1]] = fields
+		}
+		for i := 2; i+1 < len(args); i += 2 {
+			fields[args[i]] = args[i+1]
+		}
+		s.invalidate(args[1])
+		return reply(1)
+
+	case "FLUSHALL":
+		s.values = map[string]interface{}{}
+		s.readers = map[string]map[int64]bool{}
+		for _, target := range s.redirects() {
+			target.conn.Write([]byte(">2\r\n$10\r\ninvalidate\r\n_\r\n"))
+		}
+		return reply(resv.SimpleString("OK"))
+	}
+	return reply(fred.Error("ERR unknown command '" + args[0] + "'"))
+}
+
+// redirects returns the connections that tracking clients redirect invalidations to. The caller must hold s.mu.
+func (s *trackingServer) redirects() map[int64]*trackingConn {
+	targets := map[int64]*trackingConn{}
+	for _, c := range s.conns {
+		if target := s.conns[c.redirect]; target != nil {
+			targets[c.redirect] = target
+		}
+	}
+	return targets
+}
+
+// invalidate pushes an invalidation for key to each client tracking it. The caller must hold s.mu.
+func (s *trackingServer) invalidate(key string) {
+	targets := map[int64]bool{}
+	for id := range s.readers[key] {
+		targets[id] = true
+	}
+	delete(s.readers, key)
+	for _, c := range s.conns {
+		if !c.bcast || c.redirect == 0 {
+			continue
+		}
+		match := len(c.prefixes) == 0
+		for _, prefix := range c.prefixes {
+			match = match || strings.HasPrefix(key, prefix)
+		}
+		if match {
+			targets[c.redirect] = true
+		}
+	}
+
+	msg := fmt.Sprintf(">2\r\n$10\r\ninvalidate\r\n*1\r\n$%d\r\n%s\r\n", len(key), key)
+	for id := range targets {
+		if target := s.conns[id]; target != nil {
+			target.conn.Write([]byte(msg))
+		}
+	}
+}
+
+// getCount returns the number of GET and HGETALL commands received.
+func (s *trackingServer) getCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.gets
+}
+
+// dropRedirects closes the connections that invalidations are redirected to.
+func (s *trackingServer) dropRedirects() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, target := range s.redirects() {
+		target.conn.Close()
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for i := 0; !cond(); i++ {
+		if i == 200 {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCache(t *testing.T) {
+	srv := startTrackingServer(t)
+	cache, err := DialCache("tcp", srv.l.Addr().String(), CacheOptions{MaxEntries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	// Missing keys are cached too.
+	for i := 0; i < 2; i++ {
+		if v, err := cache.Get("a"); err != ErrNil {
+			t.Fatalf("Get(a) = %q, %v; want ErrNil", v, err)
+		}
+	}
+	if n := srv.getCount(); n != 1 {
+		t.Errorf("server received %d GETs; want 1", n)
+	}
+
+	// Writes, including the cache's own, evict the key.
+	cache.Do("SET", "a", "1")
+	waitFor(t, "invalidation", func() bool { return cache.Len() == 0 })
+	for i := 0; i < 2; i++ {
+		if v, err := cache.Get("a"); v != "1" || err != nil {
+			t.Fatalf("Get(a) = %q, %v; want 1", v, err)
+		}
+	}
+	if n := srv.getCount(); n != 2 {
+		t.Errorf("server received %d GETs; want 2", n)
+	}
+
+	other, err := Dial("tcp", srv.l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	other.Do("HSET", "h", "f", "1")
+	fields, err := cache.HGetAll("h")
+	if err != nil || fields["f"] != "1" {
+		t.Fatalf("HGetAll(h) = %v, %v; want f=1", fields, err)
+	}
+	fields["f"] = "modified"
+	if fields, _ = cache.HGetAll("h"); fields["f"] != "1" {
+		t.Errorf("HGetAll(h) after modifying its result = %v; want f=1", fields)
+	}
+	other.Do("HSET", "h", "f", "2")
+	waitFor(t, "invalidation of h", func() bool {
+		fields, _ := cache.HGetAll("h")
+		return fields["f"] == "2"
+	})
+
+	// The least recently used key is evicted once there are more than MaxEntries.
+	cache.Get("a")
+	cache.Get("b")
+	if n := cache.Len(); n != 2 {
+		t.Errorf("Len() = %d; want 2", n)
+	}
+	gets := srv.getCount()
+	cache.Get("a")
+	if cache.HGetAll("h"); srv.getCount() != gets+1 {
+		t.Errorf("h was not evicted")
+	}
+
+	other.Do("FLUSHALL")
+	waitFor(t, "flush", func() bool { return cache.Len() == 0 })
+}
+
+func TestCacheBroadcast(t *testing.T) {
+	srv := startTrackingServer(t)
+	cache, err := DialCache("tcp", srv.l.Addr().String(), CacheOptions{Broadcast: true, Prefixes: []string{"user:"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	cache.Get("user:1")
+	cache.Get("user:1")
+	cache.Get("other")
+	cache.Get("other")
+	if n := srv.getCount(); n != 3 {
+		t.Errorf("server received %d GETs; want 3", n)
+	}
+	srv.mu.Lock()
+	if got := fmt.Sprint(srv.tracking); !strings.HasSuffix(got, "BCAST PREFIX user:]]") {
+		t.Errorf("CLIENT TRACKING arguments = %s; want BCAST PREFIX user:", got)
+	}
+	srv.mu.Unlock()
+
+	cache.Do("SET", "user:1", "x")
+	waitFor(t, "invalidation", func() bool {
+		v, _ := cache.Get("user:1")
+		return v == "x"
+	})
+}
+
+func TestCacheReconnect(t *testing.T) {
+	srv := startTrackingServer(t)
+	cache, err := DialCache("tcp", srv.l.Addr().String(), CacheOptions{MinBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	cache.Do("SET", "a", "1")
+	cache.Get("a")
+	if n := cache.Len(); n != 1 {
+		t.Fatalf("Len() = %d; want 1", n)
+	}
+
+	// Invalidations may be missed while disconnected, so the cache is flushed. Once reconnected, the pool's
+	// connections redirect their invalidations to the new connection.
+	srv.dropRedirects()
+	waitFor(t, "reconnect", func() bool {
+		cache.Get("a")
+		return cache.Len() == 1
+	})
+	cache.Do("SET", "a", "2")
+	waitFor(t, "invalidation", func() bool {
+		v, _ := cache.Get("a")
+		return v == "2"
+	})
+}
+
+func TestConnPush(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		r := bufio.NewReader(server)
+		fred.Read(r)
+		server.Write([]byte(">2\r\n$10\r\ninvalidate\r\n*1\r\n$1\r\nk\r\n+OK\r\n"))
+	}()
+
+	conn := NewConn(client)
+	defer conn.Close()
+	var pushes []fred.Resp
+	conn.OnPush = func(resp fred.Resp) { pushes = append(pushes, resp) }
+	if s, err := conn.Do("SET", "k", "v").Str(); s != "OK" || err != nil {
+		t.Errorf("SET = %q, %v; want OK", s, err)
+	}
+	if len(pushes) != 1 || !pushes[0].IsType(fred.Push) {
+		t.Errorf("pushes = %v; want one invalidate message", pushes)
+	}
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/resv/memstore"
+	"github.com/nilium/fred/resv/resvtest"
+)
+
+// dialStore starts a memstore server and returns a connection to it.
+func dialStore(t *testing.T) (*Conn, *resvtest.Server) {
+	srv := resvtest.NewServer(memstore.New())
+	t.Cleanup(srv.Close)
+
+	c, err := Dial("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, srv
+}
+
+func TestDo(t *testing.T) {
+	c, _ := dialStore(t)
+
+	resvtest.AssertReply(t, c.Do("SET", "k", 12), "OK")
+	resvtest.AssertReply(t, c.Do("INCRBY", "k", int64(30)), 42)
+	resvtest.AssertReply(t, c.Do("GET", []byte("k")), "42")
+	resvtest.AssertReply(t, c.Do("LPUSH", "k", "x"),
+		fred.Error("WRONGTYPE Operation against a key holding the wrong kind of value"))
+
+	if resp := c.Do("SET", "k", struct{}{}); resp.Err == nil {
+		t.Error("Do succeeded with an unencodable argument")
+	}
+	if err := c.Err(); err != nil {
+		t.Fatal("connection failed:", err)
+	}
+
+	c.Close()
+	if resp := c.Do("GET", "k"); resp.Err != ErrClosed {
+		t.Errorf("Do after Close: Err = %v; want %v", resp.Err, ErrClosed)
+	}
+}
+
+func TestArgs(t *testing.T) {
+	type Base struct {
+		ID int64 `redis:"id"`
+	}
+	type Extra struct {
+		Note string `redis:"note"`
+	}
+	type user struct {
+		Base
+		*Extra
+		Name    string  `redis:"name"`
+		Email   string  `redis:"email,omitempty"`
+		Score   float64 // untagged fields use their names
+		Admin   bool    `redis:"admin"`
+		Secret  string  `redis:"-"`
+		private string
+	}
+	u := &user{Base: Base{ID: 7}, Name: "ann", Score: 1.5, Admin: true, Secret: "x", private: "y"}
+
+	args := Args{"user:7"}.AddFlat(u).Add("tags", []byte("a,b"), nil)
+	args = args.AddFlat([]int{1, 2}).AddFlat(map[string]int{"k": 3})
+	command, err := CommandArgs("HSET", args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"HSET", "user:7", "id", "7", "name", "ann", "Score", "1.5", "admin", "1", "tags", "a,b", "", "1", "2", "k", "3"}
+	if !reflect.DeepEqual(command, want) {
+		t.Errorf("CommandArgs() = %q; want %q", command, want)
+	}
+
+	// An Args passed as a single argument is expanded.
+	c, _ := dialStore(t)
+	resvtest.AssertReply(t, c.Do("RPUSH", "list", Args{}.AddFlat([]interface{}{1, 2.5, "x"})), 3)
+	resvtest.AssertReply(t, c.Do("LRANGE", "list", 0, -1), []string{"1", "2.5", "x"})
+
+	// Embedded struct pointers are followed if they're not nil.
+	u.Extra = &Extra{Note: "hi"}
+	if command, _ := CommandArgs("HSET", Args{}.AddFlat(u)...); len(command) != 11 || command[3] != "note" {
+		t.Errorf("CommandArgs() with an embedded pointer = %q; want note after id", command)
+	}
+
+	// Errors give the argument's position in the whole command.
+	_, err = CommandArgs("SET", "k", Args{"v"}.Add(struct{}{}))
+	if err == nil || !strings.Contains(err.Error(), "argument 3") {
+		t.Errorf("CommandArgs() with an unencodable value in an Args = %v; want an error for argument 3", err)
+	}
+}
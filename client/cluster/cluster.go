@@ -0,0 +1,274 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/client"
+)
+
+// ErrNoNodes is returned by New if none of the seed nodes could be reached.
+var ErrNoNodes = errors.New("cluster: no nodes reachable")
+
+// DefaultMaxRedirects is the number of MOVED or ASK redirections a Client follows for a single command.
+const DefaultMaxRedirects = 5
+
+// Options configures a Client.
+type Options struct {
+	// Dial connects to a node. If nil, nodes are dialed over TCP with client.Dial.
+	Dial func(addr string) (*client.Conn, error)
+	// MaxRedirects is the maximum number of redirections followed for a single command. If zero,
+	// DefaultMaxRedirects is used.
+	MaxRedirects int
+}
+
+// Client is a Redis Cluster client. It routes each command to the master serving its keys' slot, and follows MOVED and
+// ASK redirections, reloading the cluster's topology when slots move. It is safe for concurrent use.
+type Client struct {
+	opts  Options
+	seeds []string
+
+	mu     sync.RWMutex
+	slots  [Slots]string // master address by slot; empty if unassigned
+	ranges []SlotRange
+	nodes  map[string]*client.Conn
+}
+
+// New connects to the cluster through the first reachable seed node and loads its topology.
+func New(opts Options, seeds ...string) (*Client, error) {
+	if opts.Dial == nil {
+		opts.Dial = func(addr string) (*client.Conn, error) {
+			return client.Dial("tcp", addr)
+		}
+	}
+	if opts.MaxRedirects <= 0 {
+		opts.MaxRedirects = DefaultMaxRedirects
+	}
+
+	c := &Client{
+		opts:  opts,
+		seeds: seeds,
+		nodes: make(map[string]*client.Conn),
+	}
+	if err := c.Reload(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close closes the connections to all nodes.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for addr, conn := range c.nodes {
+		conn.Close()
+		delete(c.nodes, addr)
+	}
+	return nil
+}
+
+// Ranges returns the slot ranges loaded by the last Reload.
+func (c *Client) Ranges() []SlotRange {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]SlotRange(nil), c.ranges...)
+}
+
+// Reload reloads the cluster's topology from the first node that replies, trying known masters before the seed nodes.
+func (c *Client) Reload() error {
+	c.mu.RLock()
+	var addrs []string
+	for _, sr := range c.ranges {
+		addrs = append(addrs, sr.Master)
+	}
+	c.mu.RUnlock()
+	addrs = append(addrs, c.seeds...)
+
+	err := ErrNoNodes
+	for _, addr := range addrs {
+		conn, cerr := c.node(addr)
+		if cerr != nil {
+			err = cerr
+			continue
+		}
+
+		ranges, lerr := LoadTopology(conn)
+		if lerr != nil {
+			err = lerr
+			if conn.Err() != nil {
+				c.drop(addr, conn)
+			}
+			continue
+		}
+
+		c.setTopology(ranges)
+		return nil
+	}
+	return err
+}
+
+func (c *Client) setTopology(ranges []SlotRange) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ranges = ranges
+	c.slots = [Slots]string{}
+	for _, sr := range ranges {
+		for slot := sr.Start; slot <= sr.End; slot++ {
+			c.slots[slot] = sr.Master
+		}
+	}
+}
+
+// NodeFor returns the address of the master serving the slot of key.
+func (c *Client) NodeFor(key string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.slots[Slot(key)]
+}
+
+// Do sends a command to the master serving its keys' slot and returns its reply. Arguments are converted as they are by
+// client.Conn.Do. Commands without keys are sent to an arbitrary master.
+//
+// If the command's keys hash to different slots, Do returns a Resp whose Err is ErrCrossSlot without sending it.
+func (c *Client) Do(cmd string, args ...interface{}) fred.Resp {
+	command, err := client.CommandArgs(cmd, args...)
+	if err != nil {
+		return fred.Resp{Err: err}
+	}
+
+	slot, err := commandSlot(cmd, command[1:])
+	if err != nil {
+		return fred.Resp{Err: err}
+	}
+
+	addr, err := c.addrFor(slot)
+	if err != nil {
+		return fred.Resp{Err: err}
+	}
+
+	asking := false
+	for redirects := 0; ; redirects++ {
+		resp := c.send(addr, asking, command)
+
+		rerr, ok := resp.Err.(fred.Error)
+		if !ok || redirects >= c.opts.MaxRedirects {
+			return resp
+		}
+
+		kind, rslot, raddr, ok := parseRedirect(string(rerr))
+		if !ok {
+			return resp
+		}
+
+		addr, asking = raddr, kind == "ASK"
+		if kind == "MOVED" {
+			// Route the slot immediately, then reload the topology since other slots have likely moved with it.
+			c.mu.Lock()
+			c.slots[rslot] = raddr
+			c.mu.Unlock()
+			c.Reload()
+		}
+	}
+}
+
+// send sends a command to the node at addr, preceded by ASKING if asking is true.
+func (c *Client) send(addr string, asking bool, command []string) fred.Resp {
+	conn, err := c.node(addr)
+	if err != nil {
+		return fred.Resp{Err: err}
+	}
+
+	args := make([]interface{}, len(command)-1)
+	for i, arg := range command[1:] {
+		args[i] = arg
+	}
+
+	var resp fred.Resp
+	if asking {
+		p := conn.Pipeline()
+		p.Send("ASKING")
+		p.Send(command[0], args...)
+		results, _ := p.Exec()
+		resp = results[1]
+	} else {
+		resp = conn.Do(command[0], args...)
+	}
+
+	if conn.Err() != nil {
+		c.drop(addr, conn)
+	}
+	return resp
+}
+
+// addrFor returns the address of the master serving slot, or of an arbitrary master if slot is -1.
+func (c *Client) addrFor(slot int) (string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if slot == -1 {
+		if len(c.ranges) == 0 {
+			return "", ErrNoNodes
+		}
+		return c.ranges[rand.Intn(len(c.ranges))].Master, nil
+	}
+
+	if addr := c.slots[slot]; addr != "" {
+		return addr, nil
+	}
+	return "", fmt.Errorf("cluster: slot %d is not served by any node", slot)
+}
+
+// node returns the connection to the node at addr, connecting to it if needed.
+func (c *Client) node(addr string) (*client.Conn, error) {
+	c.mu.RLock()
+	conn := c.nodes[addr]
+	c.mu.RUnlock()
+	if conn != nil {
+		return conn, nil
+	}
+
+	conn, err := c.opts.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if existing := c.nodes[addr]; existing != nil {
+		conn.Close()
+		return existing, nil
+	}
+	c.nodes[addr] = conn
+	return conn, nil
+}
+
+// drop closes and forgets a broken connection.
+func (c *Client) drop(addr string, conn *client.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.nodes[addr] == conn {
+		delete(c.nodes, addr)
+	}
+	conn.Close()
+}
+
+// parseRedirect parses a MOVED or ASK error, such as "MOVED 3999 127.0.0.1:6381".
+func parseRedirect(msg string) (kind string, slot int, addr string, ok bool) {
+	fields := strings.Fields(msg)
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return "", 0, "", false
+	}
+
+	slot, err := strconv.Atoi(fields[1])
+	if err != nil || slot < 0 || slot >= Slots {
+		return "", 0, "", false
+	}
+	return fields[0], slot, fields[2], true
+}
@@ -0,0 +1,321 @@
+package cluster
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/resv"
+	"github.com/nilium/fred/resv/memstore"
+	"github.com/nilium/fred/resv/resvtest"
+)
+
+// testCluster is a set of resv servers acting as cluster nodes. Each node serves its own memstore and redirects
+// commands for slots it doesn't own.
+type testCluster struct {
+	mu        sync.Mutex
+	nodes     []*testNode
+	owner     [Slots]int     // index of the node owning each slot
+	migrating map[int]int    // slot -> index of the node it is being migrated to
+	noShards  bool           // if true, CLUSTER SHARDS is rejected as an unknown subcommand
+	calls     map[string]int // CLUSTER subcommand calls
+}
+
+type testNode struct {
+	c      *testCluster
+	index  int
+	addr   string
+	store  *memstore.Store
+	direct *resvtest.Server // serves store without redirections
+}
+
+type askingKey struct{}
+
+func startCluster(t *testing.T, n int) *testCluster {
+	tc := &testCluster{migrating: map[int]int{}, calls: map[string]int{}}
+	for i := 0; i < n; i++ {
+		node := &testNode{c: tc, index: i, store: memstore.New()}
+		srv := resvtest.NewServer(node)
+		t.Cleanup(srv.Close)
+		node.addr = srv.Addr
+		node.direct = resvtest.NewServer(node.store)
+		t.Cleanup(node.direct.Close)
+		tc.nodes = append(tc.nodes, node)
+	}
+	for slot := range tc.owner {
+		tc.owner[slot] = slot * n / Slots
+	}
+	return tc
+}
+
+// assign moves slots start through end to node i.
+func (tc *testCluster) assign(start, end, i int) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	for slot := start; slot <= end; slot++ {
+		tc.owner[slot] = i
+	}
+}
+
+func (tc *testCluster) seed() string {
+	return tc.nodes[0].addr
+}
+
+// ranges returns the contiguous slot ranges owned by each node.
+func (tc *testCluster) ranges() (ranges [][3]int) {
+	start := 0
+	for slot := 1; slot <= Slots; slot++ {
+		if slot == Slots || tc.owner[slot] != tc.owner[start] {
+			ranges = append(ranges, [3]int{start, slot - 1, tc.owner[start]})
+			start = slot
+		}
+	}
+	return ranges
+}
+
+func splitAddr(addr string) (string, int) {
+	host, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+	return host, p
+}
+
+func (tc *testCluster) slotsReply() interface{} {
+	var reply []interface{}
+	for _, r := range tc.ranges() {
+		host, port := splitAddr(tc.nodes[r[2]].addr)
+		reply = append(reply, []interface{}{r[0], r[1], []interface{}{host, port, "node" + strconv.Itoa(r[2])}})
+	}
+	return reply
+}
+
+func (tc *testCluster) shardsReply() interface{} {
+	var reply []interface{}
+	for i, node := range tc.nodes {
+		var slots []interface{}
+		for _, r := range tc.ranges() {
+			if r[2] == i {
+				slots = append(slots, r[0], r[1])
+			}
+		}
+		host, port := splitAddr(node.addr)
+		reply = append(reply, resv.Map{
+			{Key: "slots", Value: slots},
+			{Key: "nodes", Value: []interface{}{resv.Map{
+				{Key: "id", Value: "node" + strconv.Itoa(i)},
+				{Key: "port", Value: port},
+				{Key: "ip", Value: host},
+				{Key: "endpoint", Value: host},
+				{Key: "role", Value: "master"},
+				{Key: "health", Value: "online"},
+			}}},
+		})
+	}
+	return reply
+}
+
+func (n *testNode) ServeRESP(w resv.ResponseWriter, r fred.Resp) error {
+	args, err := r.StrList()
+	if err != nil {
+		return err
+	}
+	c := resv.ClientOf(w)
+
+	tc := n.c
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	switch cmd := resv.CommandName(r); cmd {
+	case "CLUSTER":
+		sub := strings.ToUpper(args[1])
+		tc.calls[sub]++
+		switch {
+		case sub == "SLOTS":
+			return w.Write(tc.slotsReply())
+		case sub == "SHARDS" && !tc.noShards:
+			return w.Write(tc.shardsReply())
+		}
+		return w.Write(fred.Error("ERR unknown subcommand '" + args[1] + "'"))
+
+	case "ASKING":
+		c.SetValue(askingKey{}, true)
+		return w.Write(resv.SimpleString("OK"))
+	}
+
+	asking := c.Value(askingKey{}) != nil
+	c.SetValue(askingKey{}, nil)
+
+	slot, err := commandSlot(args[0], args[1:])
+	if err == ErrCrossSlot {
+		return w.Write(fred.Error("CROSSSLOT Keys in request don't hash to the same slot"))
+	} else if slot != -1 {
+		owner := tc.owner[slot]
+		target, migrating := tc.migrating[slot]
+		switch {
+		case owner == n.index && migrating:
+			return w.Write(fred.Error(fmt.Sprintf("ASK %d %s", slot, tc.nodes[target].addr)))
+		case owner != n.index && !(asking && migrating && target == n.index):
+			return w.Write(fred.Error(fmt.Sprintf("MOVED %d %s", slot, tc.nodes[owner].addr)))
+		}
+	}
+	return n.store.ServeRESP(w, r)
+}
+
+// get reads key directly from node i's store.
+func (tc *testCluster) get(t *testing.T, i int, key string) fred.Resp {
+	conn := tc.nodes[i].direct.Conn(t)
+	defer conn.Close()
+	return conn.Do("GET", key)
+}
+
+func newClient(t *testing.T, tc *testCluster) *Client {
+	c, err := New(Options{}, "127.0.0.1:1", tc.seed())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestClusterRouting(t *testing.T) {
+	tc := startCluster(t, 3)
+	c := newClient(t, tc)
+
+	if n := len(c.Ranges()); n != 3 {
+		t.Fatalf("len(Ranges()) = %d; want 3", n)
+	}
+
+	for _, key := range []string{"foo", "bar", "hello", "{foo}.x"} {
+		resvtest.AssertReply(t, c.Do("SET", key, key+"-value"), "OK")
+		resvtest.AssertReply(t, c.Do("GET", key), key+"-value")
+
+		owner := tc.owner[Slot(key)]
+		if c.NodeFor(key) != tc.nodes[owner].addr {
+			t.Errorf("NodeFor(%q) = %s; want %s", key, c.NodeFor(key), tc.nodes[owner].addr)
+		}
+		resvtest.AssertReply(t, tc.get(t, owner, key), key+"-value")
+	}
+
+	resvtest.AssertReply(t, c.Do("MGET", "foo", "{foo}.x"), []string{"foo-value", "{foo}.x-value"})
+	if resp := c.Do("MGET", "foo", "bar"); resp.Err != ErrCrossSlot {
+		t.Errorf("MGET across slots: Err = %v; want %v", resp.Err, ErrCrossSlot)
+	}
+	resvtest.AssertReply(t, c.Do("PING"), "PONG")
+}
+
+func TestClusterMoved(t *testing.T) {
+	tc := startCluster(t, 3)
+	c := newClient(t, tc)
+
+	slot := Slot("foo")
+	from := tc.owner[slot]
+	to := (from + 1) % 3
+	tc.assign(0, Slots-1, to)
+
+	resvtest.AssertReply(t, c.Do("SET", "foo", "moved"), "OK")
+	resvtest.AssertReply(t, tc.get(t, to, "foo"), "moved")
+	if c.NodeFor("foo") != tc.nodes[to].addr {
+		t.Errorf("NodeFor(foo) = %s after MOVED; want %s", c.NodeFor("foo"), tc.nodes[to].addr)
+	}
+	if n := len(c.Ranges()); n != 1 {
+		t.Errorf("len(Ranges()) = %d after reload; want 1", n)
+	}
+}
+
+func TestClusterAsk(t *testing.T) {
+	tc := startCluster(t, 3)
+	c := newClient(t, tc)
+
+	slot := Slot("bar")
+	from := tc.owner[slot]
+	to := (from + 1) % 3
+	tc.mu.Lock()
+	tc.migrating[slot] = to
+	tc.mu.Unlock()
+
+	resvtest.AssertReply(t, c.Do("SET", "bar", "asked"), "OK")
+	resvtest.AssertReply(t, tc.get(t, to, "bar"), "asked")
+	resvtest.AssertReply(t, tc.get(t, from, "bar"), nil)
+
+	// ASK does not change the slot's owner.
+	if c.NodeFor("bar") != tc.nodes[from].addr {
+		t.Errorf("NodeFor(bar) = %s after ASK; want %s", c.NodeFor("bar"), tc.nodes[from].addr)
+	}
+}
+
+func TestClusterSlotsFallback(t *testing.T) {
+	tc := startCluster(t, 2)
+	tc.noShards = true
+	c := newClient(t, tc)
+
+	ranges := c.Ranges()
+	if len(ranges) != 2 || ranges[0].Start != 0 || ranges[1].End != Slots-1 {
+		t.Fatalf("Ranges() = %+v", ranges)
+	}
+	if tc.calls["SLOTS"] == 0 {
+		t.Error("CLUSTER SLOTS was not used")
+	}
+	resvtest.AssertReply(t, c.Do("SET", "foo", "1"), "OK")
+}
+
+func TestParseShardsFailover(t *testing.T) {
+	node := func(port int, role, health string) resv.Map {
+		return resv.Map{
+			{Key: "port", Value: port},
+			{Key: "endpoint", Value: ""},
+			{Key: "role", Value: role},
+			{Key: "health", Value: health},
+		}
+	}
+	// The second shard's master has failed and its replica hasn't been promoted yet.
+	p, err := resv.MarshalRESP([]interface{}{
+		resv.Map{
+			{Key: "slots", Value: []int{0, 8191}},
+			{Key: "nodes", Value: []interface{}{node(7000, "master", "online"), node(7001, "replica", "online")}},
+		},
+		resv.Map{
+			{Key: "slots", Value: []int{8192, 16383}},
+			{Key: "nodes", Value: []interface{}{node(7002, "master", "failed"), node(7003, "replica", "online")}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ranges, err := ParseShards(fred.Read(bytes.NewBuffer(p)), "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SlotRange{{Start: 0, End: 8191, Master: "10.0.0.1:7000", Replicas: []string{"10.0.0.1:7001"}}}
+	if !reflect.DeepEqual(ranges, want) {
+		t.Errorf("ParseShards() = %+v; want %+v", ranges, want)
+	}
+}
+
+func TestCommandKeys(t *testing.T) {
+	cases := []struct {
+		cmd  string
+		args []string
+		keys []string
+	}{
+		{"GET", []string{"a"}, []string{"a"}},
+		{"PING", nil, nil},
+		{"MSET", []string{"a", "1", "b", "2"}, []string{"a", "b"}},
+		{"BLPOP", []string{"a", "b", "0"}, []string{"a", "b"}},
+		{"EVAL", []string{"return 1", "2", "a", "b", "x"}, []string{"a", "b"}},
+		{"ZUNIONSTORE", []string{"d", "2", "a", "b", "WEIGHTS", "1", "2"}, []string{"d", "a", "b"}},
+		{"XREADGROUP", []string{"GROUP", "g", "c", "STREAMS", "s1", "s2", ">", ">"}, []string{"s1", "s2"}},
+		{"OBJECT", []string{"ENCODING", "a"}, []string{"a"}},
+	}
+	for _, c := range cases {
+		got := commandKeys(c.cmd, c.args)
+		if strings.Join(got, ",") != strings.Join(c.keys, ",") {
+			t.Errorf("commandKeys(%s, %q) = %q; want %q", c.cmd, c.args, got, c.keys)
+		}
+	}
+}
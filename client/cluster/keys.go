@@ -0,0 +1,130 @@
+package cluster
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrCrossSlot is returned for a command whose keys don't all hash to the same slot. Redis Cluster rejects these
+// commands; use hash tags to place related keys in the same slot.
+var ErrCrossSlot = errors.New("cluster: keys in request don't hash to the same slot")
+
+// keySpec describes the positions of a command's keys in its arguments, not counting the command name. Keys are at
+// first, first+step, ..., up to last. A negative last counts from the end of the arguments, as in Redis's COMMAND
+// reply.
+type keySpec struct {
+	first, last, step int
+}
+
+// keySpecs holds the key positions of commands whose keys are not just their first argument. Commands without keys
+// have a zero first position.
+var keySpecs = map[string]keySpec{
+	// No keys
+	"PING": {}, "ECHO": {}, "INFO": {}, "TIME": {}, "DBSIZE": {}, "FLUSHDB": {}, "FLUSHALL": {}, "CLUSTER": {},
+	"CLIENT": {}, "CONFIG": {}, "COMMAND": {}, "SCRIPT": {}, "FUNCTION": {}, "PUBLISH": {}, "KEYS": {}, "SCAN": {},
+	"RANDOMKEY": {}, "WAIT": {}, "READONLY": {}, "READWRITE": {}, "HELLO": {}, "AUTH": {}, "SELECT": {},
+
+	// All arguments are keys
+	"DEL": {1, -1, 1}, "UNLINK": {1, -1, 1}, "EXISTS": {1, -1, 1}, "TOUCH": {1, -1, 1}, "MGET": {1, -1, 1},
+	"WATCH": {1, -1, 1}, "SINTER": {1, -1, 1}, "SUNION": {1, -1, 1}, "SDIFF": {1, -1, 1}, "PFCOUNT": {1, -1, 1},
+	"SINTERSTORE": {1, -1, 1}, "SUNIONSTORE": {1, -1, 1}, "SDIFFSTORE": {1, -1, 1}, "PFMERGE": {1, -1, 1},
+
+	// Alternating keys and values
+	"MSET": {1, -1, 2}, "MSETNX": {1, -1, 2},
+
+	// Two keys
+	"RENAME": {1, 2, 1}, "RENAMENX": {1, 2, 1}, "SMOVE": {1, 2, 1}, "RPOPLPUSH": {1, 2, 1}, "LMOVE": {1, 2, 1},
+	"COPY": {1, 2, 1}, "BRPOPLPUSH": {1, 2, 1}, "BLMOVE": {1, 2, 1},
+
+	// All keys but the last argument
+	"BLPOP": {1, -2, 1}, "BRPOP": {1, -2, 1}, "BZPOPMIN": {1, -2, 1}, "BZPOPMAX": {1, -2, 1},
+}
+
+// commandKeys returns the keys of a command. args does not include the command name.
+func commandKeys(cmd string, args []string) []string {
+	cmd = strings.ToUpper(cmd)
+	switch cmd {
+	case "EVAL", "EVALSHA", "EVAL_RO", "EVALSHA_RO", "FCALL", "FCALL_RO":
+		return numKeys(args, 1)
+	case "ZUNIONSTORE", "ZINTERSTORE", "ZDIFFSTORE":
+		if len(args) == 0 {
+			return nil
+		}
+		return append([]string{args[0]}, numKeys(args[1:], 0)...)
+	case "ZUNION", "ZINTER", "ZDIFF", "SINTERCARD", "LMPOP", "ZMPOP":
+		return numKeys(args, 0)
+	case "BLMPOP", "BZMPOP":
+		if len(args) == 0 {
+			return nil
+		}
+		return numKeys(args[1:], 0)
+	case "XREAD", "XREADGROUP":
+		return streamKeys(args)
+	case "OBJECT", "MEMORY", "XINFO":
+		// Subcommand followed by a key
+		if len(args) < 2 {
+			return nil
+		}
+		return args[1:2]
+	}
+
+	spec, ok := keySpecs[cmd]
+	if !ok {
+		spec = keySpec{1, 1, 1}
+	}
+	if spec.first == 0 || len(args) < spec.first {
+		return nil
+	}
+
+	last := spec.last
+	if last < 0 {
+		last += len(args) + 1
+	}
+	if last > len(args) {
+		last = len(args)
+	}
+
+	var keys []string
+	for i := spec.first; i <= last; i += spec.step {
+		keys = append(keys, args[i-1])
+	}
+	return keys
+}
+
+// numKeys returns the keys of a command whose args[i] is the number of keys that follow it.
+func numKeys(args []string, i int) []string {
+	if len(args) <= i {
+		return nil
+	}
+	n, err := strconv.Atoi(args[i])
+	if err != nil || n < 0 || i+1+n > len(args) {
+		return nil
+	}
+	return args[i+1 : i+1+n]
+}
+
+// streamKeys returns the keys of an XREAD or XREADGROUP command: the first half of the arguments after STREAMS.
+func streamKeys(args []string) []string {
+	for i, arg := range args {
+		if strings.EqualFold(arg, "STREAMS") {
+			rest := args[i+1:]
+			return rest[:len(rest)/2]
+		}
+	}
+	return nil
+}
+
+// commandSlot returns the slot of a command's keys, or -1 if it has no keys. It returns ErrCrossSlot if the keys are in
+// different slots.
+func commandSlot(cmd string, args []string) (int, error) {
+	slot := -1
+	for _, key := range commandKeys(cmd, args) {
+		s := Slot(key)
+		if slot != -1 && s != slot {
+			return 0, ErrCrossSlot
+		}
+		slot = s
+	}
+	return slot, nil
+}
@@ -0,0 +1,52 @@
+// Package cluster implements a Redis Cluster client that routes each command to the node serving its keys' hash slot.
+package cluster
+
+import "strings"
+
+// Slots is the number of hash slots in a Redis Cluster.
+const Slots = 16384
+
+// Slot returns the hash slot of key. If key contains a hash tag -- a non-empty substring between the first '{' and the
+// following '}' -- only the hash tag is hashed, so that related keys such as {user1}.name and {user1}.email share a
+// slot.
+func Slot(key string) int {
+	return int(crc16(hashTag(key))) % Slots
+}
+
+// hashTag returns the part of key that is hashed to compute its slot.
+func hashTag(key string) string {
+	start := strings.IndexByte(key, '{')
+	if start == -1 {
+		return key
+	}
+	end := strings.IndexByte(key[start+1:], '}')
+	if end <= 0 {
+		return key
+	}
+	return key[start+1 : start+1+end]
+}
+
+// crc16 computes the CRC16-CCITT (XMODEM) checksum used by Redis Cluster.
+func crc16(s string) uint16 {
+	crc := uint16(0)
+	for i := 0; i < len(s); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
+
+var crc16Table = func() (table [256]uint16) {
+	const poly = 0x1021
+	for i := range table {
+		crc := uint16(i) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ poly
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
@@ -0,0 +1,37 @@
+package cluster
+
+import "testing"
+
+func TestCRC16(t *testing.T) {
+	if got := crc16("123456789"); got != 0x31c3 {
+		t.Errorf("crc16(%q) = %#x; want 0x31c3", "123456789", got)
+	}
+}
+
+func TestSlot(t *testing.T) {
+	cases := []struct {
+		key  string
+		slot int
+	}{
+		{"foo", 12182},
+		{"bar", 5061},
+		{"hello", 866},
+		{"{foo}.bar", 12182},
+		{"x{foo}y{bar}", 12182},
+		{"{}foo", 9500},
+		{"", 0},
+	}
+	for _, c := range cases {
+		if got := Slot(c.key); got != c.slot {
+			t.Errorf("Slot(%q) = %d; want %d", c.key, got, c.slot)
+		}
+	}
+
+	// An empty hash tag hashes the whole key.
+	if Slot("{}foo") == Slot("{}bar") {
+		t.Errorf("Slot(%q) == Slot(%q); empty hash tags should be ignored", "{}foo", "{}bar")
+	}
+	if Slot("{user1}.name") != Slot("{user1}.email") {
+		t.Errorf("keys with the same hash tag have different slots")
+	}
+}
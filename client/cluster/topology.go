@@ -0,0 +1,194 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/client"
+)
+
+// SlotRange is a range of slots and the nodes serving them.
+type SlotRange struct {
+	// Start and End are the first and last slots in the range, inclusive.
+	Start, End int
+	// Master is the address of the master serving the range, as host:port.
+	Master string
+	// Replicas are the addresses of the master's replicas.
+	Replicas []string
+}
+
+var errMalformedTopology = errors.New("cluster: malformed topology reply")
+
+// LoadTopology reads the cluster's slot ranges from the node c is connected to. It uses CLUSTER SHARDS, falling back
+// to CLUSTER SLOTS for servers older than Redis 7.
+//
+// Nodes that report an empty host are assumed to share the host of c's address.
+func LoadTopology(c *client.Conn) ([]SlotRange, error) {
+	host := ""
+	if addr := c.RemoteAddr(); addr != nil {
+		host, _, _ = net.SplitHostPort(addr.String())
+	}
+
+	resp := c.Do("CLUSTER", "SHARDS")
+	if _, ok := resp.Err.(fred.Error); ok {
+		return ParseSlots(c.Do("CLUSTER", "SLOTS"), host)
+	}
+	return ParseShards(resp, host)
+}
+
+// ParseSlots parses a CLUSTER SLOTS reply. Nodes with an empty host are given defaultHost.
+func ParseSlots(resp fred.Resp, defaultHost string) ([]SlotRange, error) {
+	entries, err := resp.Array()
+	if err != nil {
+		return nil, err
+	}
+
+	ranges := make([]SlotRange, 0, len(entries))
+	for _, entry := range entries {
+		fields, err := entry.Array()
+		if err != nil || len(fields) < 3 {
+			return nil, errMalformedTopology
+		}
+
+		var sr SlotRange
+		start, err1 := fields[0].Int()
+		end, err2 := fields[1].Int()
+		if err1 != nil || err2 != nil {
+			return nil, errMalformedTopology
+		}
+		sr.Start, sr.End = int(start), int(end)
+
+		for i, node := range fields[2:] {
+			info, err := node.Array()
+			if err != nil || len(info) < 2 {
+				return nil, errMalformedTopology
+			}
+			host, _ := info[0].Str()
+			port, err := info[1].Int()
+			if err != nil {
+				return nil, errMalformedTopology
+			}
+			addr := nodeAddr(host, port, defaultHost)
+			if i == 0 {
+				sr.Master = addr
+			} else {
+				sr.Replicas = append(sr.Replicas, addr)
+			}
+		}
+
+		if err := sr.validate(); err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, sr)
+	}
+	return ranges, nil
+}
+
+// ParseShards parses a CLUSTER SHARDS reply. Nodes with an empty host are given defaultHost. Nodes whose health is not
+// "online" are skipped. The slots of a shard with no online master, such as during a failover, are left out, so that
+// they're unassigned until the topology is next loaded.
+func ParseShards(resp fred.Resp, defaultHost string) ([]SlotRange, error) {
+	shards, err := resp.Array()
+	if err != nil {
+		return nil, err
+	}
+
+	var ranges []SlotRange
+	for _, shard := range shards {
+		fields, err := respMap(shard)
+		if err != nil {
+			return nil, err
+		}
+
+		var master string
+		var replicas []string
+		nodes, _ := fields["nodes"].Array()
+		for _, node := range nodes {
+			info, err := respMap(node)
+			if err != nil {
+				return nil, err
+			}
+
+			if health, _ := info["health"].Str(); health != "" && health != "online" {
+				continue
+			}
+
+			host, _ := info["endpoint"].Str()
+			if host == "" || host == "?" {
+				host, _ = info["ip"].Str()
+			}
+			port, err := info["port"].Int()
+			if err != nil {
+				if port, err = info["tls-port"].Int(); err != nil {
+					return nil, errMalformedTopology
+				}
+			}
+
+			addr := nodeAddr(host, port, defaultHost)
+			if role, _ := info["role"].Str(); role == "master" {
+				master = addr
+			} else {
+				replicas = append(replicas, addr)
+			}
+		}
+
+		slots, _ := fields["slots"].Array()
+		if len(slots)%2 != 0 {
+			return nil, errMalformedTopology
+		} else if master == "" {
+			continue
+		}
+		for i := 0; i < len(slots); i += 2 {
+			start, err1 := slots[i].Int()
+			end, err2 := slots[i+1].Int()
+			if err1 != nil || err2 != nil {
+				return nil, errMalformedTopology
+			}
+
+			sr := SlotRange{Start: int(start), End: int(end), Master: master, Replicas: replicas}
+			if err := sr.validate(); err != nil {
+				return nil, err
+			}
+			ranges = append(ranges, sr)
+		}
+	}
+	return ranges, nil
+}
+
+func (sr *SlotRange) validate() error {
+	if sr.Start < 0 || sr.End >= Slots || sr.Start > sr.End {
+		return fmt.Errorf("cluster: invalid slot range %d-%d", sr.Start, sr.End)
+	} else if sr.Master == "" {
+		return fmt.Errorf("cluster: slot range %d-%d has no master", sr.Start, sr.End)
+	}
+	return nil
+}
+
+// respMap converts a RESP2 map reply, a flat array of alternating keys and values, to a map.
+func respMap(resp fred.Resp) (map[string]fred.Resp, error) {
+	ary, err := resp.Array()
+	if err != nil || len(ary)%2 != 0 {
+		return nil, errMalformedTopology
+	}
+
+	m := make(map[string]fred.Resp, len(ary)/2)
+	for i := 0; i < len(ary); i += 2 {
+		key, err := ary[i].Str()
+		if err != nil {
+			return nil, errMalformedTopology
+		}
+		m[strings.ToLower(key)] = ary[i+1]
+	}
+	return m, nil
+}
+
+func nodeAddr(host string, port int64, defaultHost string) string {
+	if host == "" {
+		host = defaultHost
+	}
+	return net.JoinHostPort(host, strconv.FormatInt(port, 10))
+}
@@ -0,0 +1,415 @@
+package client
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nilium/fred"
+)
+
+// ErrNil is returned by Commands' methods when the server replies with nil, such as for GET of a missing key.
+var ErrNil = errors.New("client: nil reply")
+
+// Doer sends a command and returns its reply. It is implemented by Conn, Pool, Sentinel, and Retrier.
+type Doer interface {
+	Do(cmd string, args ...interface{}) fred.Resp
+}
+
+// Commands sends common commands through a Doer and converts their replies to Go types. If the server replies with an
+// error, it is returned as a fred.Error.
+type Commands struct {
+	d Doer
+}
+
+// NewCommands returns Commands that are sent through d.
+func NewCommands(d Doer) Commands {
+	return Commands{d: d}
+}
+
+// str returns a reply as a string, or ErrNil if it is nil.
+func str(resp fred.Resp) (string, error) {
+	if resp.Err == nil && resp.IsType(fred.Nil) {
+		return "", ErrNil
+	}
+	return resp.Str()
+}
+
+// Get returns the value of key, or ErrNil if key doesn't exist.
+func (c Commands) Get(key string) (string, error) {
+	return str(c.d.Do("GET", key))
+}
+
+// SetCondition limits when SET stores a value.
+type SetCondition int
+
+const (
+	// SetAlways stores the value whether or not the key exists.
+	SetAlways SetCondition = iota
+	// SetIfNotExists (NX) stores the value only if the key doesn't exist.
+	SetIfNotExists
+	// SetIfExists (XX) stores the value only if the key exists.
+	SetIfExists
+)
+
+// SetOptions are the options to SET.
+type SetOptions struct {
+	// Expiration, if positive, is the time until the key expires. It's sent as EX if it's a whole number of seconds and
+	// as PX otherwise, rounded up to a whole number of milliseconds.
+	Expiration time.Duration
+	// KeepTTL keeps the key's existing expiration. It can't be combined with Expiration.
+	KeepTTL   bool
+	Condition SetCondition
+}
+
+var errKeepTTL = errors.New("client: SET KeepTTL can't be combined with Expiration")
+
+// Set stores value at key. value may be any argument accepted by Conn.Do. It returns false if the value wasn't stored
+// because of opts.Condition.
+func (c Commands) Set(key string, value interface{}, opts SetOptions) (bool, error) {
+	if opts.KeepTTL && opts.Expiration > 0 {
+		return false, errKeepTTL
+	}
+
+	args := []interface{}{key, value}
+	if d := opts.Expiration; d > 0 {
+		if d%time.Second == 0 {
+			args = append(args, "EX", int64(d/time.Second))
+		} else {
+			args = append(args, "PX", millis(d))
+		}
+	}
+	if opts.KeepTTL {
+		args = append(args, "KEEPTTL")
+	}
+	switch opts.Condition {
+	case SetIfNotExists:
+		args = append(args, "NX")
+	case SetIfExists:
+		args = append(args, "XX")
+	}
+
+	if _, err := str(c.d.Do("SET", args...)); err == ErrNil {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Del deletes keys and returns the number of keys deleted.
+func (c Commands) Del(keys ...string) (int64, error) {
+	return c.d.Do("DEL", strArgs(keys)...).Int()
+}
+
+// IncrBy increments the integer at key by n and returns its new value.
+func (c Commands) IncrBy(key string, n int64) (int64, error) {
+	return c.d.Do("INCRBY", key, n).Int()
+}
+
+// HGet returns the value of field in the hash at key, or ErrNil if either doesn't exist.
+func (c Commands) HGet(key, field string) (string, error) {
+	return str(c.d.Do("HGET", key, field))
+}
+
+// HSet sets fields in the hash at key and returns the number of fields added.
+func (c Commands) HSet(key string, fields map[string]interface{}) (int64, error) {
+	args := []interface{}{key}
+	for _, field := range sortedKeys(fields) {
+		args = append(args, field, fields[field])
+	}
+	return c.d.Do("HSET", args...).Int()
+}
+
+// HGetAll returns the fields and values of the hash at key. It returns an empty map if key doesn't exist.
+func (c Commands) HGetAll(key string) (map[string]string, error) {
+	var fields map[string]string
+	if err := c.d.Do("HGETALL", key).Scan(&fields); err != nil {
+		return nil, err
+	}
+	if fields == nil {
+		fields = map[string]string{}
+	}
+	return fields, nil
+}
+
+// Z is a member of a sorted set and its score.
+type Z struct {
+	Member string
+	Score  float64
+}
+
+// ZAdd adds members to the sorted set at key, updating the scores of existing members, and returns the number of
+// members added.
+func (c Commands) ZAdd(key string, members ...Z) (int64, error) {
+	args := []interface{}{key}
+	for _, z := range members {
+		args = append(args, z.Score, z.Member)
+	}
+	return c.d.Do("ZADD", args...).Int()
+}
+
+// ZRangeWithScores returns the members of the sorted set at key from rank start to stop, inclusive, along with their
+// scores. Negative ranks count from the end of the set.
+func (c Commands) ZRangeWithScores(key string, start, stop int64) ([]Z, error) {
+	var flat []string
+	if err := c.d.Do("ZRANGE", key, start, stop, "WITHSCORES").Scan(&flat); err != nil {
+		return nil, err
+	}
+	if len(flat)%2 != 0 {
+		return nil, fred.ErrMapLength
+	}
+
+	zs := make([]Z, len(flat)/2)
+	for i := range zs {
+		score, err := strconv.ParseFloat(flat[2*i+1], 64)
+		if err != nil {
+			return nil, err
+		}
+		zs[i] = Z{Member: flat[2*i], Score: score}
+	}
+	return zs, nil
+}
+
+// XAddArgs are the arguments to XADD.
+type XAddArgs struct {
+	Stream string
+	// ID is the new entry's ID. Defaults to "*", which has the server generate one.
+	ID string
+	// MaxLen, if positive, trims the stream to about MaxLen entries if Approx is set, or exactly MaxLen otherwise.
+	MaxLen int64
+	Approx bool
+	// NoMkStream doesn't create the stream if it doesn't exist. XAdd then returns ErrNil.
+	NoMkStream bool
+	// Values are the entry's fields and values. They're sent in order of their fields.
+	Values map[string]interface{}
+}
+
+// XAdd appends an entry to a stream and returns its ID.
+func (c Commands) XAdd(a XAddArgs) (string, error) {
+	args := []interface{}{a.Stream}
+	if a.NoMkStream {
+		args = append(args, "NOMKSTREAM")
+	}
+	if a.MaxLen > 0 {
+		args = append(args, "MAXLEN")
+		if a.Approx {
+			args = append(args, "~")
+		}
+		args = append(args, a.MaxLen)
+	}
+	if a.ID == "" {
+		a.ID = "*"
+	}
+	args = append(args, a.ID)
+	for _, field := range sortedKeys(a.Values) {
+		args = append(args, field, a.Values[field])
+	}
+	return str(c.d.Do("XADD", args...))
+}
+
+// BlockForever is an XReadGroupArgs.Block that waits for entries without a timeout.
+const BlockForever time.Duration = -1
+
+// XReadGroupArgs are the arguments to XREADGROUP.
+type XReadGroupArgs struct {
+	Group, Consumer string
+	// Streams are the streams to read. IDs are the IDs to read each stream after, where ">" reads entries never
+	// delivered to another consumer. If IDs is empty, ">" is used for every stream.
+	Streams []string
+	IDs     []string
+	// Count, if positive, limits the number of entries returned per stream.
+	Count int64
+	// Block, if positive, waits up to Block for entries if there are none. If BlockForever, it waits indefinitely. If
+	// zero, it doesn't wait.
+	Block time.Duration
+	// NoAck doesn't add the entries to the group's pending entries list.
+	NoAck bool
+}
+
+// XMessage is a stream entry.
+type XMessage struct {
+	ID string
+	// Values are the entry's fields and values. It's nil if the entry has been deleted since it was delivered.
+	Values map[string]string
+}
+
+// XStream is the entries read from a stream.
+type XStream struct {
+	Stream   string
+	Messages []XMessage
+}
+
+// XReadGroup reads entries from streams as a consumer in a group. It returns ErrNil if there are no entries and Block
+// elapsed.
+func (c Commands) XReadGroup(a XReadGroupArgs) ([]XStream, error) {
+	if len(a.IDs) == 0 {
+		a.IDs = make([]string, len(a.Streams))
+		for i := range a.IDs {
+			a.IDs[i] = ">"
+		}
+	} else if len(a.IDs) != len(a.Streams) {
+		return nil, errors.New("client: XReadGroup needs one ID per stream")
+	}
+
+	args := []interface{}{"GROUP", a.Group, a.Consumer}
+	if a.Count > 0 {
+		args = append(args, "COUNT", a.Count)
+	}
+	switch {
+	case a.Block > 0:
+		args = append(args, "BLOCK", millis(a.Block))
+	case a.Block == BlockForever:
+		args = append(args, "BLOCK", 0)
+	}
+	if a.NoAck {
+		args = append(args, "NOACK")
+	}
+	args = append(args, "STREAMS")
+	args = append(args, strArgs(a.Streams)...)
+	args = append(args, strArgs(a.IDs)...)
+
+	resp := c.d.Do("XREADGROUP", args...)
+	if resp.Err == nil && resp.IsType(fred.Nil) {
+		return nil, ErrNil
+	}
+	return parseXStreams(resp)
+}
+
+// XAck acknowledges entries read by a consumer group, removing them from its pending entries list, and returns the
+// number of entries acknowledged.
+func (c Commands) XAck(stream, group string, ids ...string) (int64, error) {
+	args := append([]interface{}{stream, group}, strArgs(ids)...)
+	return c.d.Do("XACK", args...).Int()
+}
+
+// XGroupCreate creates a consumer group that reads stream after the entry ID start, where "$" is the stream's last
+// entry and "0" is its beginning. If mkStream is set, the stream is created if it doesn't exist.
+func (c Commands) XGroupCreate(stream, group, start string, mkStream bool) error {
+	args := []interface{}{"CREATE", stream, group, start}
+	if mkStream {
+		args = append(args, "MKSTREAM")
+	}
+	_, err := c.d.Do("XGROUP", args...).Str()
+	return err
+}
+
+// XAutoClaimArgs are the arguments to XAUTOCLAIM.
+type XAutoClaimArgs struct {
+	Stream, Group, Consumer string
+	// MinIdle is the minimum time since an entry was last delivered for it to be claimed.
+	MinIdle time.Duration
+	// Start is the ID to scan the group's pending entries from. Defaults to "0-0".
+	Start string
+	// Count, if positive, limits the number of entries claimed. The server defaults to 100.
+	Count int64
+}
+
+// XAutoClaim transfers ownership of pending entries that have been idle for at least MinIdle to a consumer, and returns
+// them along with the ID to start the next scan from. The next ID is "0-0" once the scan is complete.
+func (c Commands) XAutoClaim(a XAutoClaimArgs) (next string, msgs []XMessage, err error) {
+	if a.Start == "" {
+		a.Start = "0-0"
+	}
+	args := []interface{}{a.Stream, a.Group, a.Consumer, millis(a.MinIdle), a.Start}
+	if a.Count > 0 {
+		args = append(args, "COUNT", a.Count)
+	}
+
+	// The reply also holds the IDs of deleted entries since Redis 7. They're removed from the pending entries list by
+	// the server, so they're ignored.
+	reply, err := c.d.Do("XAUTOCLAIM", args...).Array()
+	if err != nil {
+		return "", nil, err
+	} else if len(reply) < 2 {
+		return "", nil, fred.ErrWrongType
+	}
+	if next, err = reply[0].Str(); err != nil {
+		return "", nil, err
+	}
+	if msgs, err = parseXMessages(reply[1]); err != nil {
+		return "", nil, err
+	}
+	return next, msgs, nil
+}
+
+// parseXStreams parses an XREAD or XREADGROUP reply.
+func parseXStreams(resp fred.Resp) ([]XStream, error) {
+	streams, err := resp.Array()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]XStream, len(streams))
+	for i, stream := range streams {
+		pair, err := stream.Array()
+		if err != nil {
+			return nil, err
+		} else if len(pair) != 2 {
+			return nil, fred.ErrWrongType
+		}
+		if result[i].Stream, err = pair[0].Str(); err != nil {
+			return nil, err
+		}
+		if result[i].Messages, err = parseXMessages(pair[1]); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// parseXMessages parses a list of stream entries, as replied by XRANGE.
+func parseXMessages(resp fred.Resp) ([]XMessage, error) {
+	entries, err := resp.Array()
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]XMessage, 0, len(entries))
+	for _, entry := range entries {
+		// XAUTOCLAIM in Redis 6.2 replies with nil in place of deleted entries.
+		if entry.IsType(fred.Nil) {
+			continue
+		}
+		fields, err := entry.Array()
+		if err != nil {
+			return nil, err
+		} else if len(fields) != 2 {
+			return nil, fred.ErrWrongType
+		}
+		var msg XMessage
+		if msg.ID, err = fields[0].Str(); err != nil {
+			return nil, err
+		}
+		if !fields[1].IsType(fred.Nil) {
+			if err := fields[1].Scan(&msg.Values); err != nil {
+				return nil, err
+			}
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// millis returns d in milliseconds, rounded up so that a positive duration isn't sent as zero.
+func millis(d time.Duration) int64 {
+	return int64((d + time.Millisecond - 1) / time.Millisecond)
+}
+
+func strArgs(strs []string) []interface{} {
+	args := make([]interface{}, len(strs))
+	for i, s := range strs {
+		args[i] = s
+	}
+	return args
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
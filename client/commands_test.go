@@ -0,0 +1,162 @@
+package client
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/resv"
+	"github.com/nilium/fred/resv/resvtest"
+)
+
+func TestCommands(t *testing.T) {
+	conn, _ := dialStore(t)
+	c := NewCommands(conn)
+
+	if _, err := c.Get("k"); err != ErrNil {
+		t.Errorf("Get of a missing key error = %v; want %v", err, ErrNil)
+	}
+	if ok, err := c.Set("k", "v", SetOptions{Expiration: time.Minute}); !ok || err != nil {
+		t.Fatalf("Set() = %v, %v; want true, nil", ok, err)
+	}
+	if ok, err := c.Set("k", "w", SetOptions{Condition: SetIfNotExists}); ok || err != nil {
+		t.Errorf("Set(NX) of an existing key = %v, %v; want false, nil", ok, err)
+	}
+	if v, err := c.Get("k"); v != "v" || err != nil {
+		t.Errorf("Get() = %q, %v; want %q, nil", v, err, "v")
+	}
+	if ttl, _ := conn.Do("PTTL", "k").Int(); ttl <= 59000 || ttl > 60000 {
+		t.Errorf("PTTL = %d; want about 60000", ttl)
+	}
+	if _, err := c.Set("k", "v", SetOptions{Expiration: time.Minute, KeepTTL: true}); err != errKeepTTL {
+		t.Errorf("Set() with KeepTTL and Expiration = %v; want %v", err, errKeepTTL)
+	}
+	// Expirations under a millisecond are rounded up rather than sent as PX 0.
+	if ok, err := c.Set("short", "v", SetOptions{Expiration: time.Microsecond}); !ok || err != nil {
+		t.Errorf("Set() with a 1µs expiration = %v, %v; want true, nil", ok, err)
+	}
+
+	if n, err := c.IncrBy("n", 5); n != 5 || err != nil {
+		t.Errorf("IncrBy() = %d, %v; want 5, nil", n, err)
+	}
+	if _, err := c.IncrBy("k", 1); err == nil {
+		t.Error("IncrBy of a string succeeded")
+	} else if _, ok := err.(fred.Error); !ok {
+		t.Errorf("IncrBy of a string error = %T; want a fred.Error", err)
+	}
+	if n, err := c.Del("k", "n", "missing"); n != 2 || err != nil {
+		t.Errorf("Del() = %d, %v; want 2, nil", n, err)
+	}
+
+	if n, err := c.HSet("h", map[string]interface{}{"a": 1, "b": "two"}); n != 2 || err != nil {
+		t.Errorf("HSet() = %d, %v; want 2, nil", n, err)
+	}
+	if v, err := c.HGet("h", "a"); v != "1" || err != nil {
+		t.Errorf("HGet() = %q, %v; want %q, nil", v, err, "1")
+	}
+	if fields, err := c.HGetAll("h"); err != nil || !reflect.DeepEqual(fields, map[string]string{"a": "1", "b": "two"}) {
+		t.Errorf("HGetAll() = %v, %v", fields, err)
+	}
+	if fields, err := c.HGetAll("missing"); err != nil || fields == nil || len(fields) != 0 {
+		t.Errorf("HGetAll of a missing key = %#v, %v; want an empty map", fields, err)
+	}
+
+	if n, err := c.ZAdd("z", Z{"a", 2}, Z{"b", 1.5}, Z{"c", -1}); n != 3 || err != nil {
+		t.Errorf("ZAdd() = %d, %v; want 3, nil", n, err)
+	}
+	zs, err := c.ZRangeWithScores("z", 0, -1)
+	if want := []Z{{"c", -1}, {"b", 1.5}, {"a", 2}}; err != nil || !reflect.DeepEqual(zs, want) {
+		t.Errorf("ZRangeWithScores() = %v, %v; want %v", zs, err, want)
+	}
+}
+
+// streamHandler records the commands it's sent and replies with reply. If reply is a func, it's called to get the
+// reply.
+type streamHandler struct {
+	commands [][]string
+	reply    interface{}
+}
+
+func (h *streamHandler) ServeRESP(w resv.ResponseWriter, r fred.Resp) error {
+	args, err := r.StrList()
+	if err != nil {
+		return err
+	}
+	h.commands = append(h.commands, args)
+	if f, ok := h.reply.(func(resv.ResponseWriter) interface{}); ok {
+		return w.Write(f(w))
+	}
+	return w.Write(h.reply)
+}
+
+func TestStreamCommands(t *testing.T) {
+	h := &streamHandler{}
+	srv := resvtest.NewServer(h)
+	defer srv.Close()
+	conn, err := Dial("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := NewCommands(conn)
+
+	h.reply = "1-0"
+	id, err := c.XAdd(XAddArgs{Stream: "s", MaxLen: 100, Approx: true, Values: map[string]interface{}{"b": 2, "a": "x"}})
+	if id != "1-0" || err != nil {
+		t.Errorf("XAdd() = %q, %v; want 1-0, nil", id, err)
+	}
+
+	h.reply = []interface{}{
+		[]interface{}{"s1", []interface{}{
+			[]interface{}{"1-0", []string{"a", "x", "b", "2"}},
+			[]interface{}{"2-0", nil},
+		}},
+		[]interface{}{"s2", []interface{}{}},
+	}
+	streams, err := c.XReadGroup(XReadGroupArgs{
+		Group:    "g",
+		Consumer: "c",
+		Streams:  []string{"s1", "s2"},
+		Count:    10,
+		Block:    time.Second,
+	})
+	want := []XStream{
+		{Stream: "s1", Messages: []XMessage{
+			{ID: "1-0", Values: map[string]string{"a": "x", "b": "2"}},
+			{ID: "2-0"},
+		}},
+		{Stream: "s2", Messages: []XMessage{}},
+	}
+	if err != nil || !reflect.DeepEqual(streams, want) {
+		t.Errorf("XReadGroup() = %+v, %v; want %+v", streams, err, want)
+	}
+
+	h.reply = resv.NullArray
+	if _, err := c.XReadGroup(XReadGroupArgs{Group: "g", Consumer: "c", Streams: []string{"s1"}, Block: BlockForever}); err != ErrNil {
+		t.Errorf("XReadGroup() with no entries error = %v; want %v", err, ErrNil)
+	}
+
+	// Durations under a millisecond round up rather than becoming BLOCK 0, which blocks forever.
+	if _, err := c.XReadGroup(XReadGroupArgs{Group: "g", Consumer: "c", Streams: []string{"s1"}, Block: time.Microsecond}); err != ErrNil {
+		t.Errorf("XReadGroup() with no entries error = %v; want %v", err, ErrNil)
+	}
+	h.reply = []interface{}{"0-0", []interface{}{}}
+	if _, _, err := c.XAutoClaim(XAutoClaimArgs{Stream: "s1", Group: "g", Consumer: "c", MinIdle: 1500 * time.Microsecond}); err != nil {
+		t.Errorf("XAutoClaim() error = %v", err)
+	}
+
+	wantCommands := []string{
+		"XADD s MAXLEN ~ 100 * a x b 2",
+		"XREADGROUP GROUP g c COUNT 10 BLOCK 1000 STREAMS s1 s2 > >",
+		"XREADGROUP GROUP g c BLOCK 0 STREAMS s1 >",
+		"XREADGROUP GROUP g c BLOCK 1 STREAMS s1 >",
+		"XAUTOCLAIM s1 g c 2 0-0",
+	}
+	for i, want := range wantCommands {
+		if got := strings.Join(h.commands[i], " "); got != want {
+			t.Errorf("command %d = %q; want %q", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+// Package client implements a client for Redis and other RESP servers, such as those built with resv.
+//
+// Commands are encoded with resv.Encoder and replies are read with fred.Read. Replies are returned as fred.Resp
+// values, so they can be converted with Resp's accessors or fred.Scan. An error reply is returned as a Resp whose Err is
+// a fred.Error.
+package client
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/resv"
+)
+
+// ErrClosed is returned by a Conn's methods after it's closed.
+var ErrClosed = errors.New("client: connection closed")
+
+// Conn is a single connection to a server. It is safe for concurrent use, but commands are sent one at a time: each
+// call to Do holds the connection until its reply is read.
+type Conn struct {
+	// ReadTimeout and WriteTimeout, if non-zero, limit the time to read a reply and to write a command.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// OnPush, if not nil, is called with each push message read from a RESP3 connection, such as a client tracking
+	// invalidation. Push messages are not replies, so they are skipped whether or not OnPush is set.
+	OnPush func(fred.Resp)
+	// Hook, if not nil, is called before each command is sent and after its reply is read, including commands sent in
+	// a Pipeline.
+	Hook resv.Hook
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+	enc  *resv.Encoder
+	// err is the error that broke the connection. Once set, all commands fail with it.
+	err error
+}
+
+// Dial connects to the server at addr on the named network.
+func Dial(network, addr string) (*Conn, error) {
+	nc, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(nc), nil
+}
+
+// NewConn returns a Conn that sends commands over nc.
+func NewConn(nc net.Conn) *Conn {
+	return &Conn{
+		conn: nc,
+		r:    bufio.NewReader(nc),
+		enc:  resv.NewEncoder(nc),
+	}
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == ErrClosed {
+		return nil
+	}
+	c.err = ErrClosed
+	return c.conn.Close()
+}
+
+// Err returns the error that broke the connection, if any. A Conn is unusable once it has failed to read or write a
+// command, since it can no longer tell which reply belongs to which command.
+func (c *Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// RemoteAddr returns the server's address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Do sends a command and returns its reply. Each of args is sent as a bulk string and must be a string, []byte, integer,
+// float, bool, fmt.Stringer, or nil, which is sent as an empty string. An Args argument is expanded into its values;
+// use Args.AddFlat to send maps, slices, and structs.
+//
+// If the server replies with an error, the returned Resp's Err is a fred.Error and the connection remains usable. Any
+// other error means the command could not be sent or its reply could not be read.
+func (c *Conn) Do(cmd string, args ...interface{}) fred.Resp {
+	command, err := CommandArgs(cmd, args...)
+	if err != nil {
+		return fred.Resp{Err: err}
+	}
+
+	info := c.before(command)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	resp := fred.Resp{Err: c.send(command)}
+	if resp.Err == nil {
+		resp = c.receive()
+	}
+	c.after(info, resp)
+	return resp
+}
+
+// before calls the Hook, if any, before command is sent, and returns the CommandInfo to pass to after.
+func (c *Conn) before(command []string) *resv.CommandInfo {
+	if c.Hook == nil {
+		return nil
+	}
+	info := &resv.CommandInfo{Name: strings.ToUpper(command[0]), Args: command[1:], Start: time.Now()}
+	c.Hook.BeforeCommand(info)
+	return info
+}
+
+// after calls the Hook, if any, with the reply to the command described by info.
+func (c *Conn) after(info *resv.CommandInfo, resp fred.Resp) {
+	if info == nil {
+		return
+	}
+	info.Duration = time.Since(info.Start)
+	info.ReplyType, info.Err = resp.Type(), resp.Err
+	c.Hook.AfterCommand(info)
+}
+
+// send encodes and flushes a command. The caller must hold c.mu.
+func (c *Conn) send(command []string) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.setWriteDeadline()
+	if err := c.enc.EncodeCommand(command[0], command[1:]...); err != nil {
+		return c.fail(err)
+	}
+	if err := c.enc.Flush(); err != nil {
+		return c.fail(err)
+	}
+	return nil
+}
+
+func (c *Conn) setWriteDeadline() {
+	if c.WriteTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.WriteTimeout))
+	}
+}
+
+// receive reads a single reply. The caller must hold c.mu.
+func (c *Conn) receive() fred.Resp {
+	if c.err != nil {
+		return fred.Resp{Err: c.err}
+	}
+
+	for {
+		if c.ReadTimeout > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(c.ReadTimeout))
+		}
+		resp := fred.Read(c.r)
+		if resp.Err != nil && !resp.IsType(fred.Err) {
+			c.fail(resp.Err)
+		} else if resp.IsType(fred.Push) {
+			if c.OnPush != nil {
+				c.OnPush(resp)
+			}
+			continue
+		}
+		return resp
+	}
+}
+
+// fail breaks the connection with err, returning err.
+func (c *Conn) fail(err error) error {
+	if c.err == nil {
+		c.err = err
+		c.conn.Close()
+	}
+	return err
+}
@@ -0,0 +1,238 @@
+package client
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConsumerOptions configures a Consumer.
+type ConsumerOptions struct {
+	Stream, Group, Consumer string
+	// CreateGroup creates the group when Run starts, along with the stream if it doesn't exist. A new group only reads
+	// entries added after it's created. It is not an error if the group already exists.
+	CreateGroup bool
+
+	// Count is the maximum number of entries read at once. Defaults to 10.
+	Count int64
+	// Block is the maximum time to wait for new entries before checking for entries to claim or whether the Consumer
+	// is stopped. If the Doer is a Pool or Conn, its read timeout must be longer. Defaults to 5 seconds.
+	Block time.Duration
+
+	// MinIdle is the minimum time since an entry was delivered to another consumer, without being acknowledged, for
+	// this Consumer to claim it. Defaults to 1 minute. If negative, entries are never claimed.
+	MinIdle time.Duration
+	// ClaimInterval is the time between checks for entries to claim. Defaults to MinIdle.
+	ClaimInterval time.Duration
+
+	// MinBackoff and MaxBackoff bound the delay before retrying after a command fails. The delay doubles after each
+	// failure. They default to 100 milliseconds and 10 seconds.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// OnError, if not nil, is called with each error returned by a command or by the handler passed to Run.
+	OnError func(error)
+}
+
+func (o *ConsumerOptions) setDefaults() {
+	if o.Count <= 0 {
+		o.Count = 10
+	}
+	if o.Block <= 0 {
+		o.Block = 5 * time.Second
+	}
+	if o.MinIdle == 0 {
+		o.MinIdle = time.Minute
+	}
+	if o.ClaimInterval <= 0 {
+		o.ClaimInterval = o.MinIdle
+	}
+	setBackoffDefaults(&o.MinBackoff, &o.MaxBackoff, 100*time.Millisecond, 10*time.Second)
+}
+
+// Consumer reads a stream as a member of a consumer group, passing each entry to a handler and acknowledging it once
+// handled.
+//
+// Entries that the handler fails stay in the group's pending entries list. When Run starts, it first handles the
+// entries still pending for this consumer, such as those it was handling when it last stopped. While running, it
+// claims entries that other consumers have left pending for MinIdle, such as consumers that crashed.
+type Consumer struct {
+	cmds Commands
+	opts ConsumerOptions
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	mu       sync.Mutex
+	done     chan struct{} // closed when Run returns, or nil if it isn't running
+}
+
+// NewConsumer returns a Consumer that sends commands through d.
+func NewConsumer(d Doer, opts ConsumerOptions) *Consumer {
+	opts.setDefaults()
+	return &Consumer{cmds: NewCommands(d), opts: opts, stop: make(chan struct{})}
+}
+
+// Run reads entries and passes them to handle until the Consumer is stopped. An entry is acknowledged if handle returns
+// nil. Entries that have been deleted from the stream since they were delivered are acknowledged without being
+// handled.
+//
+// Failed commands are retried, so Run returns nil once stopped, unless the group can't be created. A Consumer may only
+// be run once.
+func (c *Consumer) Run(handle func(XMessage) error) error {
+	c.mu.Lock()
+	if c.done != nil {
+		c.mu.Unlock()
+		return errors.New("client: Consumer is already running")
+	}
+	done := make(chan struct{})
+	c.done = done
+	c.mu.Unlock()
+	defer close(done)
+
+	o := &c.opts
+	if o.CreateGroup {
+		err := c.cmds.XGroupCreate(o.Stream, o.Group, "$", true)
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return err
+		}
+	}
+
+	backoff := newBackoff(o.MinBackoff, o.MaxBackoff)
+	// retry reports whether to go on after err, waiting before retrying if it's not nil.
+	retry := func(err error) bool {
+		if err == nil {
+			backoff.reset()
+			return !c.stopped()
+		}
+		c.report(err)
+		select {
+		case <-time.After(backoff.next()):
+		case <-c.stop:
+			return false
+		}
+		return true
+	}
+
+	// Handle the entries still pending for this consumer. Reading from an ID other than ">" returns them without
+	// blocking, and once they're all read, an empty list.
+	for after := "0"; ; {
+		msgs, err := c.read(after, 0)
+		if !retry(err) {
+			return nil
+		} else if err != nil {
+			continue
+		} else if len(msgs) == 0 {
+			break
+		}
+		if !c.handle(msgs, handle) {
+			return nil
+		}
+		after = msgs[len(msgs)-1].ID
+	}
+
+	var lastClaim time.Time
+	for {
+		if o.MinIdle > 0 && time.Since(lastClaim) >= o.ClaimInterval {
+			err := c.claim(handle)
+			if !retry(err) {
+				return nil
+			} else if err != nil {
+				continue
+			}
+			lastClaim = time.Now()
+		}
+
+		msgs, err := c.read(">", o.Block)
+		if err == ErrNil {
+			err = nil
+		}
+		if !retry(err) {
+			return nil
+		} else if err != nil {
+			continue
+		}
+		if !c.handle(msgs, handle) {
+			return nil
+		}
+	}
+}
+
+// Stop stops the Consumer and waits for Run to return. The entry being handled, if any, is finished first. Entries
+// read but not yet handled stay pending and are handled when the Consumer is next run.
+func (c *Consumer) Stop() {
+	c.stopOnce.Do(func() { close(c.stop) })
+	c.mu.Lock()
+	done := c.done
+	c.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+func (c *Consumer) stopped() bool {
+	select {
+	case <-c.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Consumer) report(err error) {
+	if c.opts.OnError != nil {
+		c.opts.OnError(err)
+	}
+}
+
+// read reads entries after the ID after, waiting up to block for them if after is ">".
+func (c *Consumer) read(after string, block time.Duration) ([]XMessage, error) {
+	o := &c.opts
+	streams, err := c.cmds.XReadGroup(XReadGroupArgs{
+		Group:    o.Group,
+		Consumer: o.Consumer,
+		Streams:  []string{o.Stream},
+		IDs:      []string{after},
+		Count:    o.Count,
+		Block:    block,
+	})
+	if err != nil || len(streams) == 0 {
+		return nil, err
+	}
+	return streams[0].Messages, nil
+}
+
+// claim claims and handles entries that have been pending for at least MinIdle.
+func (c *Consumer) claim(handle func(XMessage) error) error {
+	o := &c.opts
+	args := XAutoClaimArgs{Stream: o.Stream, Group: o.Group, Consumer: o.Consumer, MinIdle: o.MinIdle, Count: o.Count}
+	for {
+		next, msgs, err := c.cmds.XAutoClaim(args)
+		if err != nil {
+			return err
+		}
+		if !c.handle(msgs, handle) || next == "0-0" || next == args.Start {
+			return nil
+		}
+		args.Start = next
+	}
+}
+
+// handle passes msgs to handle and acknowledges those it handles. It returns false if the Consumer was stopped.
+func (c *Consumer) handle(msgs []XMessage, handle func(XMessage) error) bool {
+	for _, msg := range msgs {
+		if c.stopped() {
+			return false
+		}
+		if msg.Values != nil {
+			if err := handle(msg); err != nil {
+				c.report(err)
+				continue
+			}
+		}
+		if _, err := c.cmds.XAck(c.opts.Stream, c.opts.Group, msg.ID); err != nil {
+			c.report(err)
+		}
+	}
+	return true
+}
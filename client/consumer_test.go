@@ -0,0 +1,284 @@
+package client
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/resv"
+	"github.com/nilium/fred/resv/resvtest"
+)
+
+// streamServer is a single stream with consumer groups. Entry IDs are "n-0", where n counts from 1. XREADGROUP waits
+// at most 10 milliseconds for new entries.
+type streamServer struct {
+	mu      sync.Mutex
+	entries []streamEntry // entries[n-1] has ID "n-0"
+	groups  map[string]*streamGroup
+}
+
+type streamEntry struct {
+	fields  []string
+	deleted bool
+}
+
+type streamGroup struct {
+	last    int // the last entry delivered by ">"
+	pending map[int]*pendingEntry
+}
+
+type pendingEntry struct {
+	consumer  string
+	delivered time.Time
+}
+
+func seq(id string) int {
+	n, _ := strconv.Atoi(strings.TrimSuffix(id, "-0"))
+	return n
+}
+
+func (s *streamServer) add(fields ...string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, streamEntry{fields: fields})
+	return strconv.Itoa(len(s.entries)) + "-0"
+}
+
+// entry returns the reply for entry n. The caller must hold s.mu.
+func (s *streamServer) entry(n int) interface{} {
+	id := strconv.Itoa(n) + "-0"
+	if s.entries[n-1].deleted {
+		return []interface{}{id, nil}
+	}
+	return []interface{}{id, s.entries[n-1].fields}
+}
+
+// pending returns the IDs pending for each consumer in group, or nil if there's no such group.
+func (s *streamServer) pending(group string) map[string][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.groups[group] == nil {
+		return nil
+	}
+	ids := map[string][]string{}
+	var ns []int
+	for n := range s.groups[group].pending {
+		ns = append(ns, n)
+	}
+	sort.Ints(ns)
+	for _, n := range ns {
+		consumer := s.groups[group].pending[n].consumer
+		ids[consumer] = append(ids[consumer], strconv.Itoa(n)+"-0")
+	}
+	return ids
+}
+
+func (s *streamServer) ServeRESP(w resv.ResponseWriter, r fred.Resp) error {
+	args, err := r.StrList()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch resv.CommandName(r) {
+	case "XGROUP":
+		if s.groups[args[3]] != nil {
+			return w.Write(fred.Error("BUSYGROUP Consumer Group name already exists"))
+		}
+		s.groups[args[3]] = &streamGroup{last: len(s.entries), pending: map[int]*pendingEntry{}}
+		return w.Write(resv.SimpleString("OK"))
+
+	case "XACK":
+		acked := 0
+		for _, id := range args[3:] {
+			if _, ok := s.groups[args[2]].pending[seq(id)]; ok {
+				delete(s.groups[args[2]].pending, seq(id))
+				acked++
+			}
+		}
+		return w.Write(acked)
+
+	case "XREADGROUP":
+		g, consumer := s.groups[args[2]], args[3]
+		count, block := len(s.entries), -1
+		for i := 4; args[i] != "STREAMS"; i++ {
+			switch args[i] {
+			case "COUNT":
+				i++
+				count, _ = strconv.Atoi(args[i])
+			case "BLOCK":
+				i++
+				block, _ = strconv.Atoi(args[i])
+			}
+		}
+		after := args[len(args)-1]
+
+		var entries []interface{}
+		if after == ">" {
+			if g.last == len(s.entries) && block >= 0 {
+				s.mu.Unlock()
+				time.Sleep(10 * time.Millisecond)
+				s.mu.Lock()
+			}
+			for g.last < len(s.entries) && len(entries) < count {
+				g.last++
+				g.pending[g.last] = &pendingEntry{consumer: consumer, delivered: time.Now()}
+				entries = append(entries, s.entry(g.last))
+			}
+			if len(entries) == 0 {
+				return w.Write(resv.NullArray(w))
+			}
+		} else {
+			for n := seq(after) + 1; n <= len(s.entries) && len(entries) < count; n++ {
+				if p := g.pending[n]; p != nil && p.consumer == consumer {
+					p.delivered = time.Now()
+					entries = append(entries, s.entry(n))
+				}
+			}
+		}
+		return w.Write([]interface{}{[]interface{}{args[len(args)-2], entries}})
+
+	case "XAUTOCLAIM":
+		g, consumer := s.groups[args[2]], args[3]
+		minIdle, _ := strconv.Atoi(args[4])
+		entries := []interface{}{}
+		for n := seq(args[5]); n <= len(s.entries); n++ {
+			p := g.pending[n]
+			if p == nil || time.Since(p.delivered) < time.Duration(minIdle)*time.Millisecond {
+				continue
+			}
+			p.consumer, p.delivered = consumer, time.Now()
+			entries = append(entries, s.entry(n))
+		}
+		return w.Write([]interface{}{"0-0", entries, []string{}})
+	}
+	return w.Write(fred.Error("ERR unsupported command"))
+}
+
+func TestConsumer(t *testing.T) {
+	ss := &streamServer{groups: map[string]*streamGroup{}}
+	srv := resvtest.NewServer(ss)
+	defer srv.Close()
+	pool := NewPool(func() (*Conn, error) { return Dial("tcp", srv.Addr) }, 2)
+	defer pool.Close()
+
+	ss.add("before", "group")
+	opts := ConsumerOptions{
+		Stream:      "s",
+		Group:       "g",
+		Consumer:    "c1",
+		CreateGroup: true,
+		Block:       10 * time.Millisecond,
+		MinIdle:     50 * time.Millisecond,
+	}
+	var (
+		mu      sync.Mutex
+		handled []string
+		errs    []error
+	)
+	opts.OnError = func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+	}
+	handle := func(msg XMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, msg.ID+":"+msg.Values["n"])
+		if msg.Values["n"] == "fail" && len(handled) == 2 {
+			return fred.Error("ERR handler failed")
+		}
+		return nil
+	}
+	consumer := NewConsumer(pool, opts)
+	result := make(chan error, 1)
+	go func() { result <- consumer.Run(handle) }()
+
+	// The group only reads entries added after it's created. An entry the handler fails is claimed and handled again
+	// once it's been pending for MinIdle.
+	waitFor(t, "the group", func() bool { return ss.pending("g") != nil })
+	ss.add("n", "ok")
+	ss.add("n", "fail")
+	ss.add("n", "ok2")
+	waitFor(t, "entries to be handled", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(handled) == 4
+	})
+	consumer.Stop()
+	if err := <-result; err != nil {
+		t.Errorf("Run() = %v", err)
+	}
+
+	if want := []string{"2-0:ok", "3-0:fail", "4-0:ok2", "3-0:fail"}; !reflect.DeepEqual(handled, want) {
+		t.Errorf("handled %v; want %v", handled, want)
+	}
+	if len(errs) != 1 || errs[0] != fred.Error("ERR handler failed") {
+		t.Errorf("errors = %v; want the handler's error", errs)
+	}
+	if p := ss.pending("g"); len(p) != 0 {
+		t.Errorf("pending = %v; want none", p)
+	}
+}
+
+func TestConsumerRecovery(t *testing.T) {
+	ss := &streamServer{groups: map[string]*streamGroup{}}
+	srv := resvtest.NewServer(ss)
+	defer srv.Close()
+	conn, err := Dial("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// c1 and c2 each read entries and stopped before acknowledging them. One of c1's entries was then deleted.
+	cmds := NewCommands(conn)
+	if err := cmds.XGroupCreate("s", "g", "$", true); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 4; i++ {
+		ss.add("n", strconv.Itoa(i))
+	}
+	read := func(consumer string, count int64) {
+		if _, err := cmds.XReadGroup(XReadGroupArgs{Group: "g", Consumer: consumer, Streams: []string{"s"}, Count: count}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read("c1", 2)
+	read("c2", 1)
+	read("c1", 1)
+	ss.entries[1].deleted = true
+
+	var handled []string
+	consumer := NewConsumer(conn, ConsumerOptions{
+		Stream:   "s",
+		Group:    "g",
+		Consumer: "c1",
+		Block:    10 * time.Millisecond,
+		MinIdle:  20 * time.Millisecond,
+	})
+	result := make(chan error, 1)
+	go func() {
+		result <- consumer.Run(func(msg XMessage) error {
+			handled = append(handled, msg.ID)
+			return nil
+		})
+	}()
+	waitFor(t, "pending entries", func() bool { return len(ss.pending("g")) == 0 })
+	consumer.Stop()
+	if err := <-result; err != nil {
+		t.Errorf("Run() = %v", err)
+	}
+
+	// c1's own entries are handled first, then c2's once it's claimed. The deleted entry is acknowledged without being
+	// handled.
+	if want := []string{"1-0", "4-0", "3-0"}; !reflect.DeepEqual(handled, want) {
+		t.Errorf("handled %v; want %v", handled, want)
+	}
+}
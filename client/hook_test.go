@@ -0,0 +1,69 @@
+package client
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/resv"
+)
+
+// recordingHook records the commands passed to it.
+type recordingHook struct {
+	mu     sync.Mutex
+	before []string
+	after  []resv.CommandInfo
+}
+
+func (h *recordingHook) BeforeCommand(info *resv.CommandInfo) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.before = append(h.before, info.Name)
+}
+
+func (h *recordingHook) AfterCommand(info *resv.CommandInfo) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.after = append(h.after, *info)
+}
+
+func TestConnHook(t *testing.T) {
+	c, _ := dialStore(t)
+	hook := &recordingHook{}
+	c.Hook = hook
+
+	c.Do("set", "k", 1)
+	c.Do("LPUSH", "k", "x")
+	p := c.Pipeline()
+	p.Send("GET", "k")
+	p.Send("GET", struct{}{}) // not sent, so not passed to the hook
+	p.Send("GET", "missing")
+	p.Exec()
+
+	want := []struct {
+		name  string
+		args  []string
+		reply fred.Type
+		err   bool
+	}{
+		{"SET", []string{"k", "1"}, fred.SimpleStr, false},
+		{"LPUSH", []string{"k", "x"}, fred.Err, true},
+		{"GET", []string{"k"}, fred.BulkStr, false},
+		{"GET", []string{"missing"}, fred.Nil, false},
+	}
+	if len(hook.before) != len(want) || len(hook.after) != len(want) {
+		t.Fatalf("hook called for %v before and %d commands after; want %d", hook.before, len(hook.after), len(want))
+	}
+	for i, w := range want {
+		info := hook.after[i]
+		if info.Name != w.name || len(info.Args) != len(w.args) || info.ReplyType != w.reply || (info.Err != nil) != w.err {
+			t.Errorf("AfterCommand(%+v); want %s %v with reply %v", info, w.name, w.args, w.reply)
+			continue
+		}
+		for j := range w.args {
+			if info.Args[j] != w.args[j] {
+				t.Errorf("AfterCommand args = %v; want %v", info.Args, w.args)
+			}
+		}
+	}
+}
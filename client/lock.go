@@ -0,0 +1,225 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotObtained is returned by Locker.Obtain if the lock is held by someone else, or couldn't be set on a majority
+	// of servers.
+	ErrNotObtained = errors.New("client: lock not obtained")
+	// ErrNotHeld is returned by Lock's methods if the lock has expired and may have been obtained by someone else.
+	ErrNotHeld = errors.New("client: lock not held")
+)
+
+// releaseScript deletes a lock's key if it still holds the lock's token, so that a lock that expired and was obtained
+// by someone else isn't released.
+var releaseScript = NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// extendScript resets a lock's expiration if its key still holds the lock's token.
+var extendScript = NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// LockOptions configures a Locker.
+type LockOptions struct {
+	// Retries is the number of times to try again to obtain a lock held by someone else. Defaults to no retries.
+	Retries int
+	// RetryDelay is the maximum delay before trying again. The delay is chosen at random up to RetryDelay, so that
+	// clients contending for a lock don't retry in lockstep. Defaults to 50 milliseconds.
+	RetryDelay time.Duration
+	// DriftFactor is the fraction of a lock's TTL by which the servers' clocks may drift from the client's. It's
+	// subtracted, along with the time taken to obtain the lock and 2 milliseconds, from the time the lock is valid for.
+	// Defaults to 0.01.
+	DriftFactor float64
+}
+
+func (o *LockOptions) setDefaults() {
+	if o.Retries < 0 {
+		o.Retries = 0
+	}
+	if o.RetryDelay <= 0 {
+		o.RetryDelay = 50 * time.Millisecond
+	}
+	if o.DriftFactor <= 0 {
+		o.DriftFactor = 0.01
+	}
+}
+
+// Locker obtains locks, each a key set with SET NX PX to a random token. It is safe for concurrent use.
+//
+// A Locker with a single Doer locks a single server. A Locker with several implements the Redlock algorithm, where
+// each Doer is an independent server, such as the masters of separate deployments: a lock is held only if it's set on
+// a majority of them. A server failing to reply counts as a failure to lock it.
+type Locker struct {
+	doers []Doer
+	opts  LockOptions
+}
+
+// NewLocker returns a Locker that sets locks through doers.
+func NewLocker(opts LockOptions, doers ...Doer) *Locker {
+	opts.setDefaults()
+	return &Locker{doers: doers, opts: opts}
+}
+
+// Lock is a lock obtained by a Locker.
+type Lock struct {
+	lk    *Locker
+	key   string
+	token string
+
+	mu    sync.Mutex
+	until time.Time
+}
+
+// Obtain obtains the lock named by key, which expires after ttl unless extended. If the lock can't be obtained, Obtain
+// retries up to the Locker's Retries and then returns ErrNotObtained.
+func (lk *Locker) Obtain(key string, ttl time.Duration) (*Lock, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, err
+	}
+	l := &Lock{lk: lk, key: key, token: hex.EncodeToString(b[:])}
+	px := int64(ttl / time.Millisecond)
+
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		n := lk.each(func(d Doer) bool {
+			ok, err := d.Do("SET", key, l.token, "PX", px, "NX").Str()
+			return ok == "OK" && err == nil
+		})
+		if lk.quorum(n) && l.setValidity(start, ttl) {
+			return l, nil
+		}
+
+		// Release any servers that were locked, so that the lock isn't held by no one until it expires.
+		lk.each(func(d Doer) bool {
+			return releaseScript.Do(d, []string{key}, l.token).Err == nil
+		})
+		if attempt >= lk.opts.Retries {
+			return nil, ErrNotObtained
+		}
+		time.Sleep(lk.retryDelay())
+	}
+}
+
+// each calls fn concurrently for each Doer and returns the number of calls that returned true.
+func (lk *Locker) each(fn func(Doer) bool) int {
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+		n  int
+	)
+	for _, d := range lk.doers {
+		wg.Add(1)
+		go func(d Doer) {
+			defer wg.Done()
+			if fn(d) {
+				mu.Lock()
+				n++
+				mu.Unlock()
+			}
+		}(d)
+	}
+	wg.Wait()
+	return n
+}
+
+// quorum reports whether n servers are a majority.
+func (lk *Locker) quorum(n int) bool {
+	return n >= len(lk.doers)/2+1
+}
+
+func (lk *Locker) retryDelay() time.Duration {
+	d, err := rand.Int(rand.Reader, big.NewInt(int64(lk.opts.RetryDelay)+1))
+	if err != nil {
+		return lk.opts.RetryDelay
+	}
+	return time.Duration(d.Int64())
+}
+
+// setValidity sets the time the lock is valid until, given that it was set with ttl starting at start. It returns
+// false if the lock has already expired.
+func (l *Lock) setValidity(start time.Time, ttl time.Duration) bool {
+	drift := time.Duration(float64(ttl)*l.lk.opts.DriftFactor) + 2*time.Millisecond
+	until := start.Add(ttl - drift)
+	if !time.Now().Before(until) {
+		return false
+	}
+	l.mu.Lock()
+	l.until = until
+	l.mu.Unlock()
+	return true
+}
+
+// Key returns the lock's key.
+func (l *Lock) Key() string {
+	return l.key
+}
+
+// Token returns the random value the lock's key is set to.
+func (l *Lock) Token() string {
+	return l.token
+}
+
+// Until returns the time the lock is valid until, accounting for the time taken to obtain or extend it and for clock
+// drift. Work done under the lock should finish before then.
+func (l *Lock) Until() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.until
+}
+
+// Extend resets the lock to expire after ttl. It returns ErrNotHeld if the lock isn't still held on a majority of
+// servers or expires before it's extended.
+func (l *Lock) Extend(ttl time.Duration) error {
+	start := time.Now()
+	px := int64(ttl / time.Millisecond)
+	n := l.lk.each(func(d Doer) bool {
+		held, err := extendScript.Do(d, []string{l.key}, l.token, px).Int()
+		return held == 1 && err == nil
+	})
+	if !l.lk.quorum(n) || !l.setValidity(start, ttl) {
+		return ErrNotHeld
+	}
+	return nil
+}
+
+// Release releases the lock. It returns ErrNotHeld if the lock had expired on every server, or the first error if the
+// lock couldn't be released from any server.
+func (l *Lock) Release() error {
+	var (
+		mu       sync.Mutex
+		firstErr error
+	)
+	n := l.lk.each(func(d Doer) bool {
+		released, err := releaseScript.Do(d, []string{l.key}, l.token).Int()
+		if err != nil {
+			mu.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			mu.Unlock()
+		}
+		return released == 1
+	})
+
+	l.mu.Lock()
+	l.until = time.Time{}
+	l.mu.Unlock()
+	if n > 0 {
+		return nil
+	} else if firstErr != nil {
+		return firstErr
+	}
+	return ErrNotHeld
+}
@@ -0,0 +1,214 @@
+package client
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/resv"
+	"github.com/nilium/fred/resv/resvtest"
+)
+
+// lockServer stores strings with expirations and runs the lock scripts, which it recognizes by their hashes.
+type lockServer struct {
+	mu     sync.Mutex
+	values map[string]string
+	expiry map[string]time.Time
+}
+
+func startLockServer(t *testing.T) (*lockServer, Doer) {
+	s := &lockServer{values: map[string]string{}, expiry: map[string]time.Time{}}
+	srv := resvtest.NewServer(s)
+	t.Cleanup(srv.Close)
+	pool := NewPool(func() (*Conn, error) { return Dial("tcp", srv.Addr) }, 2)
+	t.Cleanup(func() { pool.Close() })
+	return s, pool
+}
+
+// get returns the value of key. The caller must hold s.mu.
+func (s *lockServer) get(key string) (string, bool) {
+	if exp, ok := s.expiry[key]; ok && !time.Now().Before(exp) {
+		delete(s.values, key)
+		delete(s.expiry, key)
+	}
+	v, ok := s.values[key]
+	return v, ok
+}
+
+// set sets key to value, expiring after px milliseconds. The caller must hold s.mu.
+func (s *lockServer) set(key, value string, px int) {
+	s.values[key] = value
+	s.expiry[key] = time.Now().Add(time.Duration(px) * time.Millisecond)
+}
+
+// pttl returns the time until key expires.
+func (s *lockServer) pttl(key string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.get(key); !ok {
+		return 0
+	}
+	return time.Until(s.expiry[key])
+}
+
+func (s *lockServer) ServeRESP(w resv.ResponseWriter, r fred.Resp) error {
+	args, err := r.StrList()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch resv.CommandName(r) {
+	case "SET": // SET key value PX ms NX
+		if _, ok := s.get(args[1]); ok {
+			return w.Write(nil)
+		}
+		px, _ := strconv.Atoi(args[4])
+		s.set(args[1], args[2], px)
+		return w.Write(resv.SimpleString("OK"))
+
+	case "EVALSHA":
+		key, token := args[3], args[4]
+		if v, ok := s.get(key); !ok || v != token {
+			return w.Write(0)
+		}
+		switch args[1] {
+		case releaseScript.Hash():
+			delete(s.values, key)
+			return w.Write(1)
+		case extendScript.Hash():
+			px, _ := strconv.Atoi(args[5])
+			s.set(key, token, px)
+			return w.Write(1)
+		}
+		return w.Write(fred.Error("NOSCRIPT No matching script. Please use EVAL."))
+	}
+	return w.Write(fred.Error("ERR unsupported command"))
+}
+
+func TestLock(t *testing.T) {
+	s, d := startLockServer(t)
+	locker := NewLocker(LockOptions{}, d)
+
+	start := time.Now()
+	l, err := locker.Obtain("lock", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The validity accounts for 1% clock drift and 2 milliseconds.
+	until := l.Until()
+	if until.After(time.Now().Add(988*time.Millisecond)) || until.Before(start.Add(900*time.Millisecond)) {
+		t.Errorf("Until() = %v after Obtain started; want about 988ms", until.Sub(start))
+	}
+	if _, err := locker.Obtain("lock", time.Second); err != ErrNotObtained {
+		t.Errorf("Obtain() of a held lock = %v; want %v", err, ErrNotObtained)
+	}
+
+	if err := l.Extend(time.Minute); err != nil {
+		t.Errorf("Extend() = %v", err)
+	}
+	if ttl := s.pttl("lock"); ttl < 59*time.Second {
+		t.Errorf("TTL after Extend = %v; want about 1m", ttl)
+	}
+
+	if err := l.Release(); err != nil {
+		t.Errorf("Release() = %v", err)
+	}
+	if err := l.Release(); err != ErrNotHeld {
+		t.Errorf("second Release() = %v; want %v", err, ErrNotHeld)
+	}
+	if err := l.Extend(time.Minute); err != ErrNotHeld {
+		t.Errorf("Extend() after Release = %v; want %v", err, ErrNotHeld)
+	}
+
+	// Once a lock expires, someone else may obtain it, and the first holder can no longer release it.
+	l, err = locker.Obtain("lock", 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	other, err := NewLocker(LockOptions{Retries: 5, RetryDelay: 10 * time.Millisecond}, d).Obtain("lock", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Release(); err != ErrNotHeld {
+		t.Errorf("Release() of an expired lock = %v; want %v", err, ErrNotHeld)
+	}
+	if err := other.Release(); err != nil {
+		t.Errorf("Release() = %v", err)
+	}
+}
+
+func TestRedlock(t *testing.T) {
+	var (
+		servers []*lockServer
+		doers   []Doer
+	)
+	for i := 0; i < 5; i++ {
+		s, d := startLockServer(t)
+		servers, doers = append(servers, s), append(doers, d)
+	}
+
+	// A lock is obtained if a majority of servers are available.
+	down := func(n int) []Doer {
+		ds := append([]Doer(nil), doers...)
+		for i := 0; i < n; i++ {
+			addr := deadAddr(t)
+			pool := NewPool(func() (*Conn, error) { return Dial("tcp", addr) }, 1)
+			t.Cleanup(func() { pool.Close() })
+			ds[i] = pool
+		}
+		return ds
+	}
+	l, err := NewLocker(LockOptions{}, down(2)...).Obtain("lock", time.Second)
+	if err != nil {
+		t.Fatalf("Obtain() with 2 of 5 servers down = %v", err)
+	}
+	if err := l.Release(); err != nil {
+		t.Errorf("Release() = %v", err)
+	}
+	if _, err := NewLocker(LockOptions{}, down(3)...).Obtain("lock", time.Second); err != ErrNotObtained {
+		t.Errorf("Obtain() with 3 of 5 servers down = %v; want %v", err, ErrNotObtained)
+	}
+
+	// If the lock is held on a majority of servers, the servers that were locked are released.
+	for _, s := range servers[:3] {
+		s.mu.Lock()
+		s.set("lock", "someone else", 1000)
+		s.mu.Unlock()
+	}
+	if _, err := NewLocker(LockOptions{}, doers...).Obtain("lock", time.Second); err != ErrNotObtained {
+		t.Errorf("Obtain() of a lock held on 3 of 5 servers = %v; want %v", err, ErrNotObtained)
+	}
+	for i, s := range servers[3:] {
+		if ttl := s.pttl("lock"); ttl != 0 {
+			t.Errorf("server %d still locked after a failed Obtain", i+3)
+		}
+	}
+
+	// A lock held on a minority of servers can be obtained.
+	for _, s := range servers[:3] {
+		s.mu.Lock()
+		delete(s.values, "lock")
+		s.mu.Unlock()
+	}
+	servers[0].mu.Lock()
+	servers[0].set("lock", "someone else", 1000)
+	servers[0].mu.Unlock()
+	l, err = NewLocker(LockOptions{}, doers...).Obtain("lock", time.Second)
+	if err != nil {
+		t.Fatalf("Obtain() of a lock held on 1 of 5 servers = %v", err)
+	}
+	if err := l.Extend(time.Minute); err != nil {
+		t.Errorf("Extend() = %v", err)
+	}
+	if ttl := servers[0].pttl("lock"); ttl > time.Second {
+		t.Errorf("Extend() extended a lock held by someone else")
+	}
+	if ttl := servers[4].pttl("lock"); ttl < 59*time.Second {
+		t.Errorf("TTL after Extend = %v; want about 1m", ttl)
+	}
+}
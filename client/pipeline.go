@@ -0,0 +1,128 @@
+package client
+
+import (
+	"bytes"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/resv"
+)
+
+// Pipeline buffers commands to send to a Conn in a single write. Replies are read back in order once all commands are
+// sent, so a batch of commands costs a single round trip.
+//
+// A Pipeline is not safe for concurrent use. It may be reused after Exec.
+type Pipeline struct {
+	c   *Conn
+	buf bytes.Buffer
+	enc *resv.Encoder
+	// cmds holds the queued commands if the Conn has a Hook.
+	cmds [][]string
+	// errs holds errors for commands that could not be encoded, indexed by their position in the pipeline. These
+	// commands are not sent.
+	errs map[int]error
+	n    int
+}
+
+// Pipeline returns an empty Pipeline for c.
+func (c *Conn) Pipeline() *Pipeline {
+	p := &Pipeline{c: c}
+	p.enc = resv.NewEncoder(&p.buf)
+	return p
+}
+
+// Send queues a command. Its arguments are converted as they are by Conn.Do. If they can't be converted, the command
+// is not sent and its result from Exec holds the error.
+func (p *Pipeline) Send(cmd string, args ...interface{}) {
+	command, err := CommandArgs(cmd, args...)
+	if err != nil {
+		if p.errs == nil {
+			p.errs = make(map[int]error)
+		}
+		p.errs[p.n] = err
+	} else {
+		// Writing to a bytes.Buffer can't fail.
+		p.enc.EncodeCommand(command[0], command[1:]...)
+		p.enc.Flush()
+	}
+	if p.c.Hook != nil {
+		p.cmds = append(p.cmds, command)
+	}
+	p.n++
+}
+
+// Len returns the number of commands queued.
+func (p *Pipeline) Len() int {
+	return p.n
+}
+
+// Reset discards all queued commands.
+func (p *Pipeline) Reset() {
+	p.buf.Reset()
+	p.errs = nil
+	p.cmds = nil
+	p.n = 0
+}
+
+// Exec sends all queued commands and returns their replies in the order they were queued. The pipeline is reset
+// afterward.
+//
+// An error reply to one command does not affect the others: its Resp's Err is a fred.Error. The returned error is
+// non-nil only if the connection failed, in which case each command whose reply was not read has a Resp holding that
+// error.
+func (p *Pipeline) Exec() ([]fred.Resp, error) {
+	defer p.Reset()
+
+	results := make([]fred.Resp, p.n)
+	if p.n == 0 {
+		return results, nil
+	}
+
+	c := p.c
+	var infos []*resv.CommandInfo
+	if len(p.cmds) == p.n {
+		infos = make([]*resv.CommandInfo, p.n)
+		for i, command := range p.cmds {
+			if _, ok := p.errs[i]; !ok {
+				infos[i] = c.before(command)
+			}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Commands are written while replies are read, so that neither side blocks on a full socket buffer when the
+	// pipeline is large.
+	err := c.err
+	written := make(chan error, 1)
+	if err != nil || p.buf.Len() == 0 {
+		written <- nil
+	} else {
+		c.setWriteDeadline()
+		go func(b []byte) {
+			_, werr := c.conn.Write(b)
+			if werr != nil {
+				c.conn.Close() // Unblock the reads below.
+			}
+			written <- werr
+		}(p.buf.Bytes())
+	}
+
+	for i := range results {
+		if cerr, ok := p.errs[i]; ok {
+			results[i] = fred.Resp{Err: cerr}
+		} else if err != nil {
+			results[i] = fred.Resp{Err: err}
+		} else if results[i] = c.receive(); c.err != nil {
+			err = c.err
+		}
+		if infos != nil {
+			c.after(infos[i], results[i])
+		}
+	}
+
+	if werr := <-written; werr != nil {
+		c.err, err = werr, werr
+	}
+	return results, err
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/resv/resvtest"
+)
+
+func TestPipeline(t *testing.T) {
+	c, _ := dialStore(t)
+
+	p := c.Pipeline()
+	p.Send("SET", "s", "v")
+	p.Send("HSET", "h", "f", 1)
+	p.Send("HSET", "s", "f", 1) // WRONGTYPE
+	p.Send("HSET", "h", "g", make(chan int))
+	p.Send("HGETALL", "h")
+
+	if n := p.Len(); n != 5 {
+		t.Fatalf("Len() = %d; want 5", n)
+	}
+
+	results, err := p.Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 5 {
+		t.Fatalf("len(results) = %d; want 5", len(results))
+	}
+
+	resvtest.AssertReply(t, results[0], "OK")
+	resvtest.AssertReply(t, results[1], 1)
+	if _, ok := results[2].Err.(fred.Error); !ok {
+		t.Errorf("results[2].Err = %v; want an error reply", results[2].Err)
+	}
+	if err := results[3].Err; err == nil {
+		t.Error("results[3].Err = nil; want an encoding error")
+	}
+	resvtest.AssertReply(t, results[4], []string{"f", "1"})
+
+	if p.Len() != 0 {
+		t.Errorf("Len() = %d after Exec; want 0", p.Len())
+	}
+}
+
+func TestPipelineLarge(t *testing.T) {
+	c, _ := dialStore(t)
+
+	const n = 20000
+	p := c.Pipeline()
+	for i := 0; i < n; i++ {
+		p.Send("HSET", "h", "field:"+strconv.Itoa(i), i)
+	}
+
+	results, err := p.Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, resp := range results {
+		if v, err := resp.Int(); err != nil || v != 1 {
+			t.Fatalf("results[%d] = %#v; want 1", i, resp)
+		}
+	}
+	resvtest.AssertReply(t, c.Do("HLEN", "h"), n)
+}
+
+func TestPipelineBrokenConn(t *testing.T) {
+	c, srv := dialStore(t)
+	srv.Close()
+
+	p := c.Pipeline()
+	p.Send("PING")
+	p.Send("PING")
+	results, err := p.Exec()
+	if err == nil {
+		t.Fatal("Exec succeeded on a closed connection")
+	}
+	for i, resp := range results {
+		if resp.Err == nil {
+			t.Errorf("results[%d].Err = nil; want an error", i)
+		}
+	}
+	if c.Err() == nil {
+		t.Error("Err() = nil; want the connection's error")
+	}
+}
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"sync"
+
+	"github.com/nilium/fred"
+)
+
+// Pool is a pool of connections to a single server. It is safe for concurrent use.
+type Pool struct {
+	dial    func() (*Conn, error)
+	maxIdle int
+
+	mu     sync.Mutex
+	closed bool
+	idle   []*Conn
+	// gen is incremented by Reset. Connections taken from an older generation are closed when put back.
+	gen    uint64
+	active map[*Conn]uint64
+}
+
+// NewPool returns a Pool that opens connections with dial and keeps up to maxIdle of them open while unused.
+func NewPool(dial func() (*Conn, error), maxIdle int) *Pool {
+	return &Pool{
+		dial:    dial,
+		maxIdle: maxIdle,
+		active:  make(map[*Conn]uint64),
+	}
+}
+
+// Get returns an idle connection, or a new one if none are idle. It must be returned to the pool with Put.
+func (p *Pool) Get() (*Conn, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrClosed
+	}
+	for len(p.idle) > 0 {
+		c := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if c.Err() != nil {
+			continue
+		}
+		p.active[c] = p.gen
+		p.mu.Unlock()
+		return c, nil
+	}
+	gen := p.gen
+	p.mu.Unlock()
+
+	c, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		c.Close()
+		return nil, ErrClosed
+	}
+	p.active[c] = gen
+	return c, nil
+}
+
+// Put returns a connection taken with Get to the pool. Broken connections, and connections taken before the last
+// Reset, are closed instead.
+func (p *Pool) Put(c *Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	gen, ok := p.active[c]
+	delete(p.active, c)
+	if !ok || p.closed || gen != p.gen || len(p.idle) >= p.maxIdle || c.Err() != nil {
+		c.Close()
+		return
+	}
+	p.idle = append(p.idle, c)
+}
+
+// Do sends a command on a pooled connection and returns its reply, as Conn.Do does.
+func (p *Pool) Do(cmd string, args ...interface{}) fred.Resp {
+	c, err := p.Get()
+	if err != nil {
+		return fred.Resp{Err: err}
+	}
+	defer p.Put(c)
+	return c.Do(cmd, args...)
+}
+
+// Reset closes all idle connections. Connections in use are closed when they're put back, so later calls to Get open
+// new connections. It's used when the server's address changes.
+func (p *Pool) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.gen++
+	p.closeIdle()
+}
+
+// Close closes all idle connections and stops the pool from opening new ones. Connections in use are closed when
+// they're put back.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.closeIdle()
+	return nil
+}
+
+func (p *Pool) closeIdle() {
+	for _, c := range p.idle {
+		c.Close()
+	}
+	p.idle = nil
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/nilium/fred/resv/memstore"
+	"github.com/nilium/fred/resv/resvtest"
+)
+
+func TestPool(t *testing.T) {
+	srv := resvtest.NewServer(memstore.New())
+	defer srv.Close()
+
+	dials := 0
+	p := NewPool(func() (*Conn, error) {
+		dials++
+		return Dial("tcp", srv.Addr)
+	}, 1)
+	defer p.Close()
+
+	resvtest.AssertReply(t, p.Do("SET", "k", "v"), "OK")
+	resvtest.AssertReply(t, p.Do("GET", "k"), "v")
+	if dials != 1 {
+		t.Errorf("dials = %d after two commands; want 1", dials)
+	}
+
+	// Only one connection is kept idle.
+	c1, _ := p.Get()
+	c2, _ := p.Get()
+	p.Put(c1)
+	p.Put(c2)
+	if c2.Err() != ErrClosed {
+		t.Errorf("connection beyond maxIdle was not closed: %v", c2.Err())
+	}
+
+	// Connections taken before a Reset are closed when put back.
+	c3, _ := p.Get()
+	if c3 != c1 {
+		t.Error("idle connection was not reused")
+	}
+	p.Reset()
+	p.Put(c3)
+	if c3.Err() != ErrClosed {
+		t.Errorf("connection taken before Reset was not closed: %v", c3.Err())
+	}
+	resvtest.AssertReply(t, p.Do("GET", "k"), "v")
+	if dials != 3 {
+		t.Errorf("dials = %d; want 3", dials)
+	}
+
+	p.Close()
+	if _, err := p.Get(); err != ErrClosed {
+		t.Errorf("Get after Close error = %v; want %v", err, ErrClosed)
+	}
+}
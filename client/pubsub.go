@@ -0,0 +1,425 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/resv"
+)
+
+// ErrTimeout is returned by PubSub's methods if the server doesn't confirm a subscription change in time.
+var ErrTimeout = errors.New("client: timed out waiting for the server")
+
+// Message is a message received from a subscribed channel.
+type Message struct {
+	// Pattern is the pattern that matched Channel, if the message was received through PSubscribe.
+	Pattern string
+	Channel string
+	Data    []byte
+}
+
+// PubSubOptions configures a PubSub.
+type PubSubOptions struct {
+	// PingInterval is the time between PINGs sent to check that the connection is alive. If the server sends nothing
+	// for PingInterval plus Timeout, the connection is closed and reopened. Defaults to 30 seconds. If negative, no
+	// PINGs are sent and idle connections are never closed.
+	PingInterval time.Duration
+	// Timeout limits the time to connect and to wait for a subscription change to be confirmed. Defaults to 5 seconds.
+	Timeout time.Duration
+
+	// MinBackoff and MaxBackoff bound the delay between attempts to reconnect. The delay doubles after each failed
+	// attempt. They default to 100 milliseconds and 10 seconds.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// BufferSize is the capacity of the Messages channel. Defaults to 100.
+	BufferSize int
+}
+
+func (o *PubSubOptions) setDefaults() {
+	if o.PingInterval == 0 {
+		o.PingInterval = 30 * time.Second
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 5 * time.Second
+	}
+	setBackoffDefaults(&o.MinBackoff, &o.MaxBackoff, 100*time.Millisecond, 10*time.Second)
+	if o.BufferSize < 0 {
+		o.BufferSize = 0
+	} else if o.BufferSize == 0 {
+		o.BufferSize = 100
+	}
+}
+
+// PubSub is a connection subscribed to channels and patterns. Messages are delivered on the channel returned by
+// Messages.
+//
+// If the connection is lost, PubSub reconnects and subscribes to its channels and patterns again. Messages published
+// while it is disconnected are lost.
+type PubSub struct {
+	dial func() (net.Conn, error)
+	opts PubSubOptions
+
+	msgs   chan Message
+	closed chan struct{}
+	done   chan struct{}
+
+	mu        sync.Mutex
+	closeOnce sync.Once
+	conn      net.Conn // nil while disconnected
+	enc       *resv.Encoder
+	channels  map[string]struct{}
+	patterns  map[string]struct{}
+	// waiters are closed when the server confirms a subscription change, keyed by the confirmation's kind and
+	// channel, as in "subscribe:news".
+	waiters map[string][]chan struct{}
+}
+
+// DialPubSub connects to the server at addr on the named network and returns a PubSub with no subscriptions. If the
+// first connection fails, DialPubSub returns its error. Later connections are retried.
+func DialPubSub(network, addr string, opts PubSubOptions) (*PubSub, error) {
+	opts.setDefaults()
+	dial := func() (net.Conn, error) {
+		return net.DialTimeout(network, addr, opts.Timeout)
+	}
+
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+
+	ps := newPubSub(dial, opts)
+	go ps.run(conn)
+	return ps, nil
+}
+
+// NewPubSub returns a PubSub that connects using dial. It connects in the background and retries failed connections
+// until closed.
+func NewPubSub(dial func() (net.Conn, error), opts PubSubOptions) *PubSub {
+	opts.setDefaults()
+	ps := newPubSub(dial, opts)
+	go ps.run(nil)
+	return ps
+}
+
+func newPubSub(dial func() (net.Conn, error), opts PubSubOptions) *PubSub {
+	return &PubSub{
+		dial:     dial,
+		opts:     opts,
+		msgs:     make(chan Message, opts.BufferSize),
+		closed:   make(chan struct{}),
+		done:     make(chan struct{}),
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+		waiters:  make(map[string][]chan struct{}),
+	}
+}
+
+// Messages returns the channel that messages are delivered on. It is closed once the PubSub is closed. If messages
+// aren't received, the PubSub stops reading from the server once the channel's buffer is full.
+func (ps *PubSub) Messages() <-chan Message {
+	return ps.msgs
+}
+
+// Subscribe subscribes to channels and waits for the server to confirm the subscriptions. If they aren't confirmed
+// within the Timeout, Subscribe returns ErrTimeout, but the PubSub remains subscribed and will retry when it
+// reconnects.
+func (ps *PubSub) Subscribe(channels ...string) error {
+	return ps.change("subscribe", ps.channels, true, channels)
+}
+
+// PSubscribe subscribes to channels matching patterns. It otherwise behaves like Subscribe.
+func (ps *PubSub) PSubscribe(patterns ...string) error {
+	return ps.change("psubscribe", ps.patterns, true, patterns)
+}
+
+// Unsubscribe unsubscribes from channels, or from all channels if none are given, and waits for the server to confirm
+// it.
+func (ps *PubSub) Unsubscribe(channels ...string) error {
+	return ps.change("unsubscribe", ps.channels, false, channels)
+}
+
+// PUnsubscribe unsubscribes from patterns, or from all patterns if none are given, and waits for the server to confirm
+// it.
+func (ps *PubSub) PUnsubscribe(patterns ...string) error {
+	return ps.change("punsubscribe", ps.patterns, false, patterns)
+}
+
+// Channels returns the channels and patterns the PubSub is subscribed to.
+func (ps *PubSub) Channels() (channels, patterns []string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return keys(ps.channels), keys(ps.patterns)
+}
+
+// change adds names to or removes them from set, sends the command kind, and waits for each name to be confirmed.
+func (ps *PubSub) change(kind string, set map[string]struct{}, add bool, names []string) error {
+	ps.mu.Lock()
+	select {
+	case <-ps.closed:
+		ps.mu.Unlock()
+		return ErrClosed
+	default:
+	}
+
+	if !add && len(names) == 0 {
+		names = keys(set)
+	}
+	if len(names) == 0 {
+		ps.mu.Unlock()
+		return nil
+	}
+
+	// Removing a subscription while disconnected needs no confirmation, since it won't be renewed on reconnect.
+	confirm := add || ps.conn != nil
+
+	var (
+		waits []chan struct{}
+		keys  []string
+	)
+	for _, name := range names {
+		if add {
+			set[name] = struct{}{}
+		} else {
+			delete(set, name)
+		}
+		if !confirm {
+			continue
+		}
+		wait := make(chan struct{})
+		key := kind + ":" + name
+		waits, keys = append(waits, wait), append(keys, key)
+		ps.waiters[key] = append(ps.waiters[key], wait)
+	}
+
+	// If the command can't be sent, the connection is broken and the reader will reconnect and resubscribe.
+	if ps.conn != nil {
+		ps.send(append([]string{strings.ToUpper(kind)}, names...))
+	}
+	ps.mu.Unlock()
+
+	timer := time.NewTimer(ps.opts.Timeout)
+	defer timer.Stop()
+	for _, wait := range waits {
+		select {
+		case <-wait:
+		case <-timer.C:
+			ps.removeWaiters(keys, waits)
+			return ErrTimeout
+		case <-ps.closed:
+			ps.removeWaiters(keys, waits)
+			return ErrClosed
+		}
+	}
+	return nil
+}
+
+// removeWaiters removes waits, keyed by keys, from ps.waiters if they haven't been confirmed, so that waiters for
+// changes the server never confirms don't accumulate.
+func (ps *PubSub) removeWaiters(keys []string, waits []chan struct{}) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for i, key := range keys {
+		list := ps.waiters[key]
+		for j, wait := range list {
+			if wait == waits[i] {
+				list = append(list[:j:j], list[j+1:]...)
+				break
+			}
+		}
+		if len(list) == 0 {
+			delete(ps.waiters, key)
+		} else {
+			ps.waiters[key] = list
+		}
+	}
+}
+
+// Close closes the connection and the Messages channel.
+func (ps *PubSub) Close() error {
+	ps.closeOnce.Do(func() {
+		ps.mu.Lock()
+		close(ps.closed)
+		if ps.conn != nil {
+			ps.conn.Close()
+		}
+		ps.mu.Unlock()
+	})
+	<-ps.done
+	return nil
+}
+
+// send writes a command to the current connection. The caller must hold ps.mu. Write errors are left for the reader
+// to discover, since the connection will fail to read as well.
+func (ps *PubSub) send(command []string) {
+	ps.conn.SetWriteDeadline(time.Now().Add(ps.opts.Timeout))
+	if ps.enc.EncodeCommand(command[0], command[1:]...) == nil {
+		ps.enc.Flush()
+	}
+}
+
+// run connects, reads messages, and reconnects until the PubSub is closed. conn, if not nil, is the first connection.
+func (ps *PubSub) run(conn net.Conn) {
+	defer close(ps.done)
+	defer close(ps.msgs)
+
+	backoff := newBackoff(ps.opts.MinBackoff, ps.opts.MaxBackoff)
+	for {
+		if conn == nil {
+			var err error
+			if conn, err = ps.dial(); err != nil {
+				select {
+				case <-time.After(backoff.next()):
+				case <-ps.closed:
+					return
+				}
+				continue
+			}
+		}
+
+		if !ps.attach(conn) {
+			return
+		}
+		if ps.read(conn) {
+			backoff.reset()
+		}
+		ps.detach()
+		conn = nil
+
+		select {
+		case <-ps.closed:
+			return
+		default:
+		}
+	}
+}
+
+// attach makes conn the current connection and subscribes to all channels and patterns. It returns false if the
+// PubSub is closed.
+func (ps *PubSub) attach(conn net.Conn) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	select {
+	case <-ps.closed:
+		conn.Close()
+		return false
+	default:
+	}
+
+	ps.conn = conn
+	ps.enc = resv.NewEncoder(conn)
+	if len(ps.channels) > 0 {
+		ps.send(append([]string{"SUBSCRIBE"}, keys(ps.channels)...))
+	}
+	if len(ps.patterns) > 0 {
+		ps.send(append([]string{"PSUBSCRIBE"}, keys(ps.patterns)...))
+	}
+	return true
+}
+
+func (ps *PubSub) detach() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.conn.Close()
+	ps.conn, ps.enc = nil, nil
+}
+
+// read reads from conn until it fails. It returns true if anything was read.
+func (ps *PubSub) read(conn net.Conn) (ok bool) {
+	stopPing := make(chan struct{})
+	defer close(stopPing)
+	if ps.opts.PingInterval > 0 {
+		go ps.ping(conn, stopPing)
+	}
+
+	r := bufio.NewReader(conn)
+	for {
+		if ps.opts.PingInterval > 0 {
+			conn.SetReadDeadline(time.Now().Add(ps.opts.PingInterval + ps.opts.Timeout))
+		}
+
+		resp := fred.Read(r)
+		if resp.Err != nil && !resp.IsType(fred.Err) {
+			return ok
+		}
+		ok = true
+		ps.handle(resp)
+	}
+}
+
+// ping sends a PING every PingInterval until stop is closed.
+func (ps *PubSub) ping(conn net.Conn, stop <-chan struct{}) {
+	ticker := time.NewTicker(ps.opts.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+
+		ps.mu.Lock()
+		if ps.conn == conn {
+			ps.send([]string{"PING"})
+		}
+		ps.mu.Unlock()
+	}
+}
+
+// handle handles a single reply or message from the server.
+func (ps *PubSub) handle(resp fred.Resp) {
+	ary, err := resp.Array()
+	if err != nil || len(ary) < 2 {
+		return // PONG or an error reply
+	}
+
+	kind, _ := ary[0].Str()
+	switch kind = strings.ToLower(kind); kind {
+	case "message":
+		if len(ary) == 3 {
+			channel, _ := ary[1].Str()
+			data, _ := ary[2].Bytes()
+			ps.deliver(Message{Channel: channel, Data: data})
+		}
+
+	case "pmessage":
+		if len(ary) == 4 {
+			pattern, _ := ary[1].Str()
+			channel, _ := ary[2].Str()
+			data, _ := ary[3].Bytes()
+			ps.deliver(Message{Pattern: pattern, Channel: channel, Data: data})
+		}
+
+	case "subscribe", "psubscribe", "unsubscribe", "punsubscribe":
+		name, _ := ary[1].Str()
+		key := kind + ":" + name
+
+		ps.mu.Lock()
+		for _, wait := range ps.waiters[key] {
+			close(wait)
+		}
+		delete(ps.waiters, key)
+		ps.mu.Unlock()
+	}
+}
+
+func (ps *PubSub) deliver(m Message) {
+	select {
+	case ps.msgs <- m:
+	case <-ps.closed:
+	}
+}
+
+func keys(set map[string]struct{}) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,334 @@
+package client
+
+import (
+	"bufio"
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/resv"
+	"github.com/nilium/fred/resv/resvtest"
+)
+
+// pubsubServer is a minimal Redis pub/sub server. resv handlers reply once per command, so it is written directly on a
+// net.Listener. Other commands are passed to handler, if set.
+type pubsubServer struct {
+	l net.Listener
+
+	mu       sync.Mutex
+	conns    map[*pubsubConn]struct{}
+	accepted int
+	pings    int
+	silent   bool // if true, PINGs and subscription changes are not answered
+	handler  resv.Handler
+}
+
+type pubsubConn struct {
+	mu       sync.Mutex
+	conn     net.Conn
+	channels map[string]bool
+	patterns map[string]bool
+}
+
+func (c *pubsubConn) write(v interface{}) {
+	p, _ := resv.MarshalRESP(v)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.Write(p)
+}
+
+func startPubSubServer(t *testing.T) *pubsubServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &pubsubServer{l: l, conns: map[*pubsubConn]struct{}{}}
+	t.Cleanup(func() {
+		l.Close()
+		s.dropAll()
+	})
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(&pubsubConn{conn: conn, channels: map[string]bool{}, patterns: map[string]bool{}})
+		}
+	}()
+	return s
+}
+
+func (s *pubsubServer) serve(c *pubsubConn) {
+	s.mu.Lock()
+	s.conns[c] = struct{}{}
+	s.accepted++
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.conn.Close()
+	}()
+
+	r := bufio.NewReader(c.conn)
+	for {
+		args, err := fred.Read(r).StrList()
+		if err != nil {
+			return
+		}
+
+		kind := strings.ToLower(args[0])
+		switch kind {
+		case "subscribe", "psubscribe", "unsubscribe", "punsubscribe":
+			s.mu.Lock()
+			set := c.channels
+			if kind[0] == 'p' {
+				set = c.patterns
+			}
+			for _, name := range args[1:] {
+				if strings.HasPrefix(strings.TrimPrefix(kind, "p"), "un") {
+					delete(set, name)
+				} else {
+					set[name] = true
+				}
+			}
+			n := len(c.channels) + len(c.patterns)
+			silent := s.silent
+			s.mu.Unlock()
+			if silent {
+				continue
+			}
+			for _, name := range args[1:] {
+				c.write([]interface{}{kind, name, n})
+			}
+
+		case "ping":
+			s.mu.Lock()
+			s.pings++
+			silent := s.silent
+			s.mu.Unlock()
+			if !silent {
+				c.write([]string{"pong", ""})
+			}
+
+		default:
+			s.mu.Lock()
+			handler := s.handler
+			s.mu.Unlock()
+			if handler == nil {
+				c.write(fred.Error("ERR unknown command '" + args[0] + "'"))
+				continue
+			}
+			rec, err := resvtest.Serve(handler, args...)
+			if err != nil {
+				return
+			}
+			c.mu.Lock()
+			c.conn.Write(rec.Body.Bytes())
+			c.mu.Unlock()
+		}
+	}
+}
+
+// publish sends a message to all subscribers, returning the number of subscribers.
+func (s *pubsubServer) publish(channel, data string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for c := range s.conns {
+		if c.channels[channel] {
+			c.write([]string{"message", channel, data})
+			n++
+		}
+		for pattern := range c.patterns {
+			if ok, _ := path.Match(pattern, channel); ok {
+				c.write([]string{"pmessage", pattern, channel, data})
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func (s *pubsubServer) dropAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.conn.Close()
+		delete(s.conns, c)
+	}
+}
+
+func (s *pubsubServer) subscribers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for c := range s.conns {
+		n += len(c.channels) + len(c.patterns)
+	}
+	return n
+}
+
+func expectMessage(t *testing.T, ps *PubSub, want Message) {
+	t.Helper()
+	select {
+	case m := <-ps.Messages():
+		if m.Pattern != want.Pattern || m.Channel != want.Channel || string(m.Data) != string(want.Data) {
+			t.Fatalf("message = %q %q %q; want %q %q %q", m.Pattern, m.Channel, m.Data, want.Pattern, want.Channel, want.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a message")
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	srv := startPubSubServer(t)
+	ps, err := DialPubSub("tcp", srv.l.Addr().String(), PubSubOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+
+	if err := ps.Subscribe("news", "sports"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.PSubscribe("user.*"); err != nil {
+		t.Fatal(err)
+	}
+
+	channels, patterns := ps.Channels()
+	sort.Strings(channels)
+	if strings.Join(channels, ",") != "news,sports" || strings.Join(patterns, ",") != "user.*" {
+		t.Errorf("Channels() = %q, %q", channels, patterns)
+	}
+
+	srv.publish("news", "hello")
+	expectMessage(t, ps, Message{Channel: "news", Data: []byte("hello")})
+	srv.publish("user.1", "login")
+	expectMessage(t, ps, Message{Pattern: "user.*", Channel: "user.1", Data: []byte("login")})
+
+	if err := ps.Unsubscribe("news"); err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.publish("news", "ignored"); n != 0 {
+		t.Errorf("publish reached %d subscribers after Unsubscribe; want 0", n)
+	}
+
+	ps.Close()
+	if _, ok := <-ps.Messages(); ok {
+		t.Error("Messages channel is open after Close")
+	}
+	if err := ps.Subscribe("news"); err != ErrClosed {
+		t.Errorf("Subscribe after Close = %v; want %v", err, ErrClosed)
+	}
+}
+
+func TestPubSubReconnect(t *testing.T) {
+	srv := startPubSubServer(t)
+	ps, err := DialPubSub("tcp", srv.l.Addr().String(), PubSubOptions{MinBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+
+	if err := ps.Subscribe("news"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.PSubscribe("user.*"); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.dropAll()
+	for i := 0; srv.subscribers() != 2; i++ {
+		if i == 500 {
+			t.Fatalf("subscribers = %d after reconnecting; want 2", srv.subscribers())
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	srv.publish("user.2", "back")
+	expectMessage(t, ps, Message{Pattern: "user.*", Channel: "user.2", Data: []byte("back")})
+	srv.publish("news", "again")
+	expectMessage(t, ps, Message{Channel: "news", Data: []byte("again")})
+}
+
+func TestPubSubPing(t *testing.T) {
+	srv := startPubSubServer(t)
+	ps, err := DialPubSub("tcp", srv.l.Addr().String(), PubSubOptions{PingInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+
+	if err := ps.Subscribe("news"); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	srv.mu.Lock()
+	pings := srv.pings
+	srv.mu.Unlock()
+	if pings == 0 {
+		t.Error("no PINGs were sent")
+	}
+
+	srv.publish("news", "still here")
+	expectMessage(t, ps, Message{Channel: "news", Data: []byte("still here")})
+}
+
+func TestPubSubDeadConn(t *testing.T) {
+	srv := startPubSubServer(t)
+	srv.silent = true
+	ps, err := DialPubSub("tcp", srv.l.Addr().String(), PubSubOptions{
+		PingInterval: 10 * time.Millisecond,
+		Timeout:      50 * time.Millisecond,
+		MinBackoff:   time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+
+	for i := 0; ; i++ {
+		srv.mu.Lock()
+		accepted := srv.accepted
+		srv.mu.Unlock()
+		if accepted > 1 {
+			break
+		} else if i == 500 {
+			t.Fatal("unresponsive connection was not replaced")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPubSubTimeout(t *testing.T) {
+	srv := startPubSubServer(t)
+	srv.silent = true
+	ps, err := DialPubSub("tcp", srv.l.Addr().String(), PubSubOptions{Timeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+
+	// Changes the server never confirms time out without leaving their waiters behind.
+	for i := 0; i < 3; i++ {
+		if err := ps.Subscribe("news"); err != ErrTimeout {
+			t.Fatalf("Subscribe() = %v; want %v", err, ErrTimeout)
+		}
+	}
+	ps.mu.Lock()
+	n := len(ps.waiters)
+	ps.mu.Unlock()
+	if n != 0 {
+		t.Errorf("%d waiters left after timeouts; want 0", n)
+	}
+}
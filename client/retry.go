@@ -0,0 +1,239 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nilium/fred"
+)
+
+// ErrorClass is a kind of error, used to decide whether a command that failed with it can be retried.
+type ErrorClass int
+
+const (
+	// ClassNone is the class of a nil error.
+	ClassNone ErrorClass = iota
+	// ClassOther is any error not in another class. These errors, such as WRONGTYPE, aren't retried by default.
+	ClassOther
+	// ClassConnect is a failure to connect. The command wasn't sent.
+	ClassConnect
+	// ClassNetwork is a connection that was closed or reset while sending a command or reading its reply. The command
+	// may have run.
+	ClassNetwork
+	// ClassTimeout is a timeout while sending a command or reading its reply. The command may have run.
+	ClassTimeout
+	// ClassLoading is a LOADING reply from a server that is loading its dataset.
+	ClassLoading
+	// ClassTryAgain is a TRYAGAIN reply, sent by Redis Cluster when a multi-key command's keys are being migrated.
+	ClassTryAgain
+	// ClassBusy is a BUSY reply from a server that is running a long script or function.
+	ClassBusy
+	// ClassClusterDown is a CLUSTERDOWN or MASTERDOWN reply.
+	ClassClusterDown
+)
+
+var classNames = [...]string{
+	ClassNone:        "none",
+	ClassOther:       "other",
+	ClassConnect:     "connect",
+	ClassNetwork:     "network",
+	ClassTimeout:     "timeout",
+	ClassLoading:     "loading",
+	ClassTryAgain:    "tryagain",
+	ClassBusy:        "busy",
+	ClassClusterDown: "clusterdown",
+}
+
+func (c ErrorClass) String() string {
+	if c >= 0 && int(c) < len(classNames) {
+		return classNames[c]
+	}
+	return "ErrorClass(" + strconv.Itoa(int(c)) + ")"
+}
+
+// Classify returns the class of err.
+func Classify(err error) ErrorClass {
+	if err == nil {
+		return ClassNone
+	}
+
+	if e, ok := err.(fred.Error); ok {
+		code := string(e)
+		if i := strings.IndexByte(code, ' '); i != -1 {
+			code = code[:i]
+		}
+		switch code {
+		case "LOADING":
+			return ClassLoading
+		case "TRYAGAIN":
+			return ClassTryAgain
+		case "BUSY":
+			return ClassBusy
+		case "CLUSTERDOWN", "MASTERDOWN":
+			return ClassClusterDown
+		}
+		return ClassOther
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return ClassConnect
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ClassTimeout
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, net.ErrClosed) || netErr != nil {
+		return ClassNetwork
+	}
+	return ClassOther
+}
+
+// RetryRule says when commands that failed with an error of some class are retried.
+type RetryRule int
+
+const (
+	// NoRetry never retries.
+	NoRetry RetryRule = iota
+	// RetryIdempotent retries only idempotent commands, since the failed command may have run.
+	RetryIdempotent
+	// RetryAlways retries all commands.
+	RetryAlways
+)
+
+// DefaultRetryRules are the rules used for classes missing from RetryPolicy.Rules. Errors that mean the command
+// wasn't run are always retried, and errors that mean it may have run are retried for idempotent commands.
+var DefaultRetryRules = map[ErrorClass]RetryRule{
+	ClassConnect:     RetryAlways,
+	ClassNetwork:     RetryIdempotent,
+	ClassTimeout:     RetryIdempotent,
+	ClassLoading:     RetryAlways,
+	ClassTryAgain:    RetryAlways,
+	ClassBusy:        RetryAlways,
+	ClassClusterDown: RetryAlways,
+}
+
+// idempotentCommands are commands with no side effects, so sending them twice is harmless.
+var idempotentCommands = map[string]bool{
+	"PING": true, "ECHO": true, "TIME": true, "INFO": true, "DBSIZE": true, "EXISTS": true, "TYPE": true,
+	"TTL": true, "PTTL": true, "KEYS": true, "SCAN": true, "GET": true, "MGET": true, "STRLEN": true,
+	"GETRANGE": true, "HGET": true, "HMGET": true, "HGETALL": true, "HKEYS": true, "HVALS": true, "HLEN": true,
+	"HEXISTS": true, "HSCAN": true, "LRANGE": true, "LLEN": true, "LINDEX": true, "SMEMBERS": true,
+	"SISMEMBER": true, "SCARD": true, "SINTER": true, "SUNION": true, "SDIFF": true, "SSCAN": true, "ZSCORE": true,
+	"ZRANK": true, "ZREVRANK": true, "ZRANGE": true, "ZREVRANGE": true, "ZRANGEBYSCORE": true,
+	"ZREVRANGEBYSCORE": true, "ZCARD": true, "ZCOUNT": true, "ZSCAN": true, "XRANGE": true, "XREVRANGE": true,
+	"XLEN": true, "PFCOUNT": true, "EVAL_RO": true, "EVALSHA_RO": true, "FCALL_RO": true,
+}
+
+// IsIdempotent reports whether cmd is a read-only command that can safely be sent again if it may have run.
+func IsIdempotent(cmd string) bool {
+	return idempotentCommands[strings.ToUpper(cmd)]
+}
+
+// RetryEvent describes a retry. It is passed to RetryPolicy.OnRetry.
+type RetryEvent struct {
+	Command string
+	// Attempt is the number of the attempt that failed, starting at 1.
+	Attempt int
+	Err     error
+	Class   ErrorClass
+	// Delay is the time waited before the next attempt.
+	Delay time.Duration
+}
+
+// RetryPolicy configures a Retrier.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times a command is sent, including the first. Defaults to 3.
+	MaxAttempts int
+
+	// MinBackoff and MaxBackoff bound the delay between attempts. The delay before the nth retry is chosen at random
+	// between zero and MinBackoff * 2^(n-1), capped at MaxBackoff. They default to 10 milliseconds and 1 second.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Rules override DefaultRetryRules for some error classes.
+	Rules map[ErrorClass]RetryRule
+	// Idempotent reports whether a command can be retried by RetryIdempotent rules. Defaults to IsIdempotent.
+	Idempotent func(cmd string) bool
+
+	// OnRetry, if not nil, is called before waiting to retry a command.
+	OnRetry func(RetryEvent)
+}
+
+func (p *RetryPolicy) setDefaults() {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 3
+	}
+	setBackoffDefaults(&p.MinBackoff, &p.MaxBackoff, 10*time.Millisecond, time.Second)
+	if p.Idempotent == nil {
+		p.Idempotent = IsIdempotent
+	}
+}
+
+// rule returns the rule for errors of class.
+func (p *RetryPolicy) rule(class ErrorClass) RetryRule {
+	if rule, ok := p.Rules[class]; ok {
+		return rule
+	}
+	return DefaultRetryRules[class]
+}
+
+// backoff returns the delay before the retry following attempt.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.MaxBackoff
+	if shift := uint(attempt - 1); shift < 32 {
+		if exp := p.MinBackoff << shift; exp > 0 && exp < d {
+			d = exp
+		}
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// Retrier sends commands through a Doer and retries those that fail with transient errors. The Doer should be a Pool
+// or another Doer that reconnects, since a Conn is unusable once a command fails with a network error.
+type Retrier struct {
+	d      Doer
+	policy RetryPolicy
+}
+
+// NewRetrier returns a Retrier that sends commands through d and retries them according to policy.
+func NewRetrier(d Doer, policy RetryPolicy) *Retrier {
+	policy.setDefaults()
+	return &Retrier{d: d, policy: policy}
+}
+
+// Do sends a command, retrying it while it fails with an error whose class's rule allows it, up to MaxAttempts times.
+// It returns the reply to the last attempt.
+func (r *Retrier) Do(cmd string, args ...interface{}) fred.Resp {
+	p := &r.policy
+	for attempt := 1; ; attempt++ {
+		resp := r.d.Do(cmd, args...)
+		if resp.Err == nil || attempt >= p.MaxAttempts {
+			return resp
+		}
+
+		class := Classify(resp.Err)
+		switch p.rule(class) {
+		case RetryAlways:
+		case RetryIdempotent:
+			if !p.Idempotent(cmd) {
+				return resp
+			}
+		default:
+			return resp
+		}
+
+		delay := p.backoff(attempt)
+		if p.OnRetry != nil {
+			p.OnRetry(RetryEvent{Command: cmd, Attempt: attempt, Err: resp.Err, Class: class, Delay: delay})
+		}
+		time.Sleep(delay)
+	}
+}
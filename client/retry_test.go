@@ -0,0 +1,171 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/resv"
+	"github.com/nilium/fred/resv/resvtest"
+)
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		err   error
+		class ErrorClass
+	}{
+		{nil, ClassNone},
+		{errors.New("boom"), ClassOther},
+		{fred.Error("WRONGTYPE Operation against a key holding the wrong kind of value"), ClassOther},
+		{fred.Error("LOADING Redis is loading the dataset in memory"), ClassLoading},
+		{fred.Error("TRYAGAIN Multiple keys request during rehashing of slot"), ClassTryAgain},
+		{fred.Error("BUSY Redis is busy running a script"), ClassBusy},
+		{fred.Error("BUSYGROUP Consumer Group name already exists"), ClassOther},
+		{fred.Error("CLUSTERDOWN The cluster is down"), ClassClusterDown},
+		{fred.Error("MASTERDOWN Link with MASTER is down"), ClassClusterDown},
+		{io.EOF, ClassNetwork},
+		{io.ErrUnexpectedEOF, ClassNetwork},
+		{&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, ClassNetwork},
+		{&net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, ClassTimeout},
+		{&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, ClassConnect},
+		{fmt.Errorf("wrapped: %w", io.ErrUnexpectedEOF), ClassNetwork},
+	}
+	for _, c := range cases {
+		if got := Classify(c.err); got != c.class {
+			t.Errorf("Classify(%v) = %v; want %v", c.err, got, c.class)
+		}
+	}
+}
+
+// doerFunc is a Doer implemented by a function.
+type doerFunc func(cmd string, args ...interface{}) fred.Resp
+
+func (f doerFunc) Do(cmd string, args ...interface{}) fred.Resp {
+	return f(cmd, args...)
+}
+
+// failing returns a Doer that fails with errs in order, then replies OK, and counts its calls.
+func failing(calls *int, errs ...error) Doer {
+	return doerFunc(func(string, ...interface{}) fred.Resp {
+		*calls++
+		if *calls <= len(errs) {
+			return fred.Resp{Err: errs[*calls-1]}
+		}
+		return resvtest.Command("OK")
+	})
+}
+
+func TestRetrier(t *testing.T) {
+	var events []RetryEvent
+	policy := RetryPolicy{
+		MaxAttempts: 3,
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  2 * time.Millisecond,
+		OnRetry:     func(e RetryEvent) { events = append(events, e) },
+	}
+	loading := fred.Error("LOADING Redis is loading the dataset in memory")
+
+	// Errors that mean the command didn't run are retried for any command.
+	calls := 0
+	resp := NewRetrier(failing(&calls, loading, loading), policy).Do("INCR", "k")
+	if resp.Err != nil || calls != 3 {
+		t.Errorf("INCR after two LOADING replies = %v after %d calls; want OK after 3", resp.Err, calls)
+	}
+	if len(events) != 2 || events[0].Attempt != 1 || events[1].Attempt != 2 || events[1].Class != ClassLoading ||
+		events[1].Err != loading || events[1].Command != "INCR" || events[1].Delay > 2*time.Millisecond {
+		t.Errorf("events = %+v", events)
+	}
+
+	// MaxAttempts limits the number of attempts.
+	calls, events = 0, nil
+	resp = NewRetrier(failing(&calls, loading, loading, loading), policy).Do("GET", "k")
+	if resp.Err != loading || calls != 3 || len(events) != 2 {
+		t.Errorf("GET = %v after %d calls and %d retries; want LOADING after 3 calls and 2 retries", resp.Err, calls,
+			len(events))
+	}
+
+	// Network errors are only retried for idempotent commands.
+	calls = 0
+	resp = NewRetrier(failing(&calls, io.ErrUnexpectedEOF), policy).Do("GET", "k")
+	if resp.Err != nil || calls != 2 {
+		t.Errorf("GET after EOF = %v after %d calls; want OK after 2", resp.Err, calls)
+	}
+	calls = 0
+	resp = NewRetrier(failing(&calls, io.ErrUnexpectedEOF), policy).Do("INCR", "k")
+	if resp.Err != io.ErrUnexpectedEOF || calls != 1 {
+		t.Errorf("INCR after EOF = %v after %d calls; want EOF after 1", resp.Err, calls)
+	}
+
+	// Other errors aren't retried.
+	calls = 0
+	wrongType := fred.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+	if resp = NewRetrier(failing(&calls, wrongType), policy).Do("GET", "k"); resp.Err != wrongType || calls != 1 {
+		t.Errorf("GET after WRONGTYPE = %v after %d calls; want WRONGTYPE after 1", resp.Err, calls)
+	}
+
+	// Rules override the defaults per class.
+	policy.Rules = map[ErrorClass]RetryRule{ClassNetwork: RetryAlways, ClassLoading: NoRetry}
+	calls = 0
+	if resp = NewRetrier(failing(&calls, io.EOF), policy).Do("INCR", "k"); resp.Err != nil || calls != 2 {
+		t.Errorf("INCR after EOF with RetryAlways = %v after %d calls; want OK after 2", resp.Err, calls)
+	}
+	calls = 0
+	if resp = NewRetrier(failing(&calls, loading), policy).Do("GET", "k"); resp.Err != loading || calls != 1 {
+		t.Errorf("GET after LOADING with NoRetry = %v after %d calls; want LOADING after 1", resp.Err, calls)
+	}
+}
+
+func TestRetrierBackoff(t *testing.T) {
+	p := RetryPolicy{MinBackoff: 10 * time.Millisecond, MaxBackoff: 35 * time.Millisecond}
+	p.setDefaults()
+	cases := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, 10 * time.Millisecond},
+		{2, 20 * time.Millisecond},
+		{3, 35 * time.Millisecond},
+		{40, 35 * time.Millisecond},
+	}
+	for _, c := range cases {
+		for i := 0; i < 100; i++ {
+			if d := p.backoff(c.attempt); d < 0 || d > c.max {
+				t.Fatalf("backoff(%d) = %v; want between 0 and %v", c.attempt, d, c.max)
+			}
+		}
+	}
+}
+
+// TestRetrierPool checks that a Pool replaces a connection dropped by the server before the command is retried.
+func TestRetrierPool(t *testing.T) {
+	var mu sync.Mutex
+	hangups := 1
+	srv := resvtest.NewServer(resv.HandlerFunc(func(w resv.ResponseWriter, r fred.Resp) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if hangups > 0 {
+			hangups--
+			w.Close()
+			return nil
+		}
+		return w.Write("value")
+	}))
+	defer srv.Close()
+
+	pool := NewPool(func() (*Conn, error) { return Dial("tcp", srv.Addr) }, 1)
+	defer pool.Close()
+
+	retries := 0
+	r := NewRetrier(pool, RetryPolicy{MinBackoff: time.Millisecond, OnRetry: func(RetryEvent) { retries++ }})
+	resvtest.AssertReply(t, r.Do("GET", "k"), "value")
+	if retries != 1 {
+		t.Errorf("retries = %d; want 1", retries)
+	}
+}
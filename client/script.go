@@ -0,0 +1,73 @@
+package client
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/nilium/fred"
+)
+
+// Script is a Lua script run with EVALSHA. Scripts are cached by the server, so a Script is only sent in full when the
+// server doesn't have it.
+type Script struct {
+	src  string
+	hash string
+}
+
+// NewScript returns a Script with the given Lua source.
+func NewScript(src string) *Script {
+	sum := sha1.Sum([]byte(src))
+	return &Script{src: src, hash: hex.EncodeToString(sum[:])}
+}
+
+// Hash returns the hex-encoded SHA1 of the script's source, which the server identifies it by.
+func (s *Script) Hash() string {
+	return s.hash
+}
+
+// Do runs the script with EVALSHA and returns its reply. If the server doesn't have the script cached, it is sent again
+// with EVAL, which also caches it. keys are passed to the script as KEYS and args as ARGV.
+func (s *Script) Do(d Doer, keys []string, args ...interface{}) fred.Resp {
+	evalArgs := make([]interface{}, 0, 2+len(keys)+len(args))
+	evalArgs = append(evalArgs, s.hash, len(keys))
+	evalArgs = append(evalArgs, strArgs(keys)...)
+	evalArgs = append(evalArgs, args...)
+
+	resp := d.Do("EVALSHA", evalArgs...)
+	if !isNoScript(resp.Err) {
+		return resp
+	}
+	evalArgs[0] = s.src
+	return d.Do("EVAL", evalArgs...)
+}
+
+// Load caches the script on the server with SCRIPT LOAD, so that later calls to Do don't need to send it. The cache is
+// shared by all connections to a server, so loading a script through a Pool loads it for all of its connections.
+func (s *Script) Load(d Doer) error {
+	hash, err := d.Do("SCRIPT", "LOAD", s.src).Str()
+	if err != nil {
+		return err
+	} else if !strings.EqualFold(hash, s.hash) {
+		return errors.New("client: SCRIPT LOAD returned hash " + hash + ", want " + s.hash)
+	}
+	return nil
+}
+
+// Exists reports whether the server has the script cached.
+func (s *Script) Exists(d Doer) (bool, error) {
+	var exists []bool
+	if err := d.Do("SCRIPT", "EXISTS", s.hash).Scan(&exists); err != nil {
+		return false, err
+	} else if len(exists) != 1 {
+		return false, fred.ErrWrongType
+	}
+	return exists[0], nil
+}
+
+// isNoScript reports whether err is the server's reply to EVALSHA for a script it doesn't have.
+func isNoScript(err error) bool {
+	e, ok := err.(fred.Error)
+	return ok && strings.HasPrefix(string(e), "NOSCRIPT")
+}
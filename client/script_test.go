@@ -0,0 +1,119 @@
+package client
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/resv"
+	"github.com/nilium/fred/resv/resvtest"
+)
+
+// scriptServer caches scripts like Redis does. Scripts aren't run: the reply to EVAL and EVALSHA is the script's KEYS
+// followed by its ARGV.
+type scriptServer struct {
+	mu       sync.Mutex
+	scripts  map[string]string
+	commands []string
+}
+
+func (s *scriptServer) ServeRESP(w resv.ResponseWriter, r fred.Resp) error {
+	args, err := r.StrList()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cmd := resv.CommandName(r)
+	s.commands = append(s.commands, cmd)
+
+	switch cmd {
+	case "SCRIPT":
+		switch strings.ToUpper(args[1]) {
+		case "LOAD":
+			return w.Write(s.load(args[2]))
+		case "EXISTS":
+			exists := make([]int, len(args)-2)
+			for i, hash := range args[2:] {
+				if _, ok := s.scripts[hash]; ok {
+					exists[i] = 1
+				}
+			}
+			return w.Write(exists)
+		case "FLUSH":
+			s.scripts = map[string]string{}
+			return w.Write(resv.SimpleString("OK"))
+		}
+
+	case "EVAL":
+		s.load(args[1])
+		return w.Write(args[3:])
+
+	case "EVALSHA":
+		if _, ok := s.scripts[strings.ToLower(args[1])]; !ok {
+			return w.Write(fred.Error("NOSCRIPT No matching script. Please use EVAL."))
+		}
+		return w.Write(args[3:])
+	}
+	return w.Write(fred.Error("ERR unsupported command"))
+}
+
+func (s *scriptServer) load(src string) string {
+	sum := sha1.Sum([]byte(src))
+	hash := hex.EncodeToString(sum[:])
+	s.scripts[hash] = src
+	return hash
+}
+
+// sent returns the commands received since the last call.
+func (s *scriptServer) sent() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cmds := strings.Join(s.commands, " ")
+	s.commands = nil
+	return cmds
+}
+
+func TestScript(t *testing.T) {
+	ss := &scriptServer{scripts: map[string]string{}}
+	srv := resvtest.NewServer(ss)
+	defer srv.Close()
+	pool := NewPool(func() (*Conn, error) { return Dial("tcp", srv.Addr) }, 2)
+	defer pool.Close()
+
+	script := NewScript("return {KEYS[1], ARGV[1], ARGV[2]}")
+	if got, want := NewScript("").Hash(), "da39a3ee5e6b4b0d3255bfef95601890afd80709"; got != want {
+		t.Errorf("Hash() of an empty script = %q; want %q", got, want)
+	}
+
+	// The first call falls back to EVAL, which caches the script.
+	resvtest.AssertReply(t, script.Do(pool, []string{"k"}, "a", 1), []string{"k", "a", "1"})
+	if got := ss.sent(); got != "EVALSHA EVAL" {
+		t.Errorf("commands = %q; want EVALSHA EVAL", got)
+	}
+	resvtest.AssertReply(t, script.Do(pool, []string{"k"}, "b", 2), []string{"k", "b", "2"})
+	if got := ss.sent(); got != "EVALSHA" {
+		t.Errorf("commands = %q; want EVALSHA", got)
+	}
+
+	// Preloading avoids the fallback.
+	resvtest.AssertReply(t, pool.Do("SCRIPT", "FLUSH"), "OK")
+	if exists, err := script.Exists(pool); exists || err != nil {
+		t.Errorf("Exists() after SCRIPT FLUSH = %v, %v; want false, nil", exists, err)
+	}
+	if err := script.Load(pool); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := script.Exists(pool); !exists || err != nil {
+		t.Errorf("Exists() after Load = %v, %v; want true, nil", exists, err)
+	}
+	ss.sent()
+	resvtest.AssertReply(t, script.Do(pool, nil, "c"), []string{"c"})
+	if got := ss.sent(); got != "EVALSHA" {
+		t.Errorf("commands = %q; want EVALSHA", got)
+	}
+}
@@ -0,0 +1,262 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nilium/fred"
+)
+
+// ErrNoMaster is returned when none of a Sentinel's sentinels know the address of its master.
+var ErrNoMaster = errors.New("client: no sentinel knows the master's address")
+
+// switchMaster is the channel sentinels publish failovers on.
+const switchMaster = "+switch-master"
+
+// SentinelOptions configures a Sentinel.
+type SentinelOptions struct {
+	// Timeout limits the time to connect to a sentinel or the master and to read a sentinel's reply. Defaults to 5
+	// seconds.
+	Timeout time.Duration
+	// MaxIdle is the number of idle connections to the master kept by the pool. Defaults to 10.
+	MaxIdle int
+	// PubSub configures the subscription to the sentinels' +switch-master channel. Its Timeout defaults to Timeout.
+	PubSub PubSubOptions
+	// OnSwitch, if not nil, is called with the master's new address whenever it changes.
+	OnSwitch func(addr string)
+}
+
+func (o *SentinelOptions) setDefaults() {
+	if o.Timeout <= 0 {
+		o.Timeout = 5 * time.Second
+	}
+	if o.MaxIdle == 0 {
+		o.MaxIdle = 10
+	}
+	if o.PubSub.Timeout <= 0 {
+		o.PubSub.Timeout = o.Timeout
+	}
+}
+
+// Sentinel is a client for a master monitored by Redis Sentinel. It asks the sentinels for the master's address and
+// keeps a Pool of connections to it.
+//
+// When a sentinel announces a failover on +switch-master, the pool is reset so that new commands go to the new master.
+// The master's address is also looked up again whenever connecting to it fails and whenever the subscription to the
+// sentinels is reconnected, in case a failover was missed.
+type Sentinel struct {
+	name string
+	opts SentinelOptions
+	pool *Pool
+	ps   *PubSub
+	done chan struct{}
+
+	mu        sync.Mutex
+	addr      string
+	sentinels []string // the sentinel that last replied is moved to the front
+	dials     int      // number of PubSub connections
+}
+
+// NewSentinel looks up the address of the master named name using the sentinels at the given addresses, and subscribes
+// to failover announcements. It returns ErrNoMaster if no sentinel knows the master.
+func NewSentinel(name string, sentinels []string, opts SentinelOptions) (*Sentinel, error) {
+	opts.setDefaults()
+	s := &Sentinel{
+		name:      name,
+		opts:      opts,
+		done:      make(chan struct{}),
+		sentinels: append([]string(nil), sentinels...),
+	}
+
+	addr, err := s.lookup()
+	if err != nil {
+		return nil, err
+	}
+	s.addr = addr
+
+	s.pool = NewPool(s.dialMaster, opts.MaxIdle)
+	s.ps = NewPubSub(s.dialSentinel, opts.PubSub)
+	go s.watch()
+
+	// If the subscription isn't confirmed in time, the PubSub keeps retrying it.
+	if err := s.ps.Subscribe(switchMaster); err != nil && err != ErrTimeout {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Addr returns the master's current address.
+func (s *Sentinel) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addr
+}
+
+// Pool returns the pool of connections to the master.
+func (s *Sentinel) Pool() *Pool {
+	return s.pool
+}
+
+// Do sends a command to the master and returns its reply.
+func (s *Sentinel) Do(cmd string, args ...interface{}) fred.Resp {
+	return s.pool.Do(cmd, args...)
+}
+
+// Discover asks the sentinels for the master's address and switches to it if it has changed.
+func (s *Sentinel) Discover() (string, error) {
+	addr, err := s.lookup()
+	if err != nil {
+		return "", err
+	}
+	s.setAddr(addr)
+	return addr, nil
+}
+
+// Close closes the subscription to the sentinels and the pool.
+func (s *Sentinel) Close() error {
+	s.ps.Close()
+	<-s.done
+	return s.pool.Close()
+}
+
+// lookup asks each sentinel for the master's address until one knows it.
+func (s *Sentinel) lookup() (string, error) {
+	s.mu.Lock()
+	sentinels := append([]string(nil), s.sentinels...)
+	s.mu.Unlock()
+
+	err := ErrNoMaster
+	for _, sentinel := range sentinels {
+		addr, qerr := s.query(sentinel)
+		if qerr != nil {
+			if _, ok := qerr.(fred.Error); !ok {
+				err = qerr
+			}
+			continue
+		}
+		if addr == "" {
+			continue
+		}
+
+		s.prefer(sentinel)
+		return addr, nil
+	}
+	return "", err
+}
+
+// query asks the sentinel at addr for the master's address. It returns an empty address if the sentinel doesn't know
+// the master.
+func (s *Sentinel) query(addr string) (string, error) {
+	nc, err := net.DialTimeout("tcp", addr, s.opts.Timeout)
+	if err != nil {
+		return "", err
+	}
+	c := NewConn(nc)
+	c.ReadTimeout, c.WriteTimeout = s.opts.Timeout, s.opts.Timeout
+	defer c.Close()
+
+	resp := c.Do("SENTINEL", "get-master-addr-by-name", s.name)
+	if resp.Err != nil {
+		return "", resp.Err
+	} else if resp.IsType(fred.Nil) {
+		return "", nil
+	}
+
+	hostPort, err := resp.StrList()
+	if err != nil || len(hostPort) != 2 {
+		return "", errors.New("client: malformed reply to SENTINEL get-master-addr-by-name")
+	}
+	return net.JoinHostPort(hostPort[0], hostPort[1]), nil
+}
+
+// prefer moves sentinel to the front of the list of sentinels.
+func (s *Sentinel) prefer(sentinel string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, addr := range s.sentinels {
+		if addr == sentinel {
+			copy(s.sentinels[1:i+1], s.sentinels[:i])
+			s.sentinels[0] = sentinel
+			return
+		}
+	}
+}
+
+// setAddr switches to the master at addr, resetting the pool if the address changed.
+func (s *Sentinel) setAddr(addr string) {
+	s.mu.Lock()
+	if addr == s.addr {
+		s.mu.Unlock()
+		return
+	}
+	s.addr = addr
+	s.mu.Unlock()
+
+	s.pool.Reset()
+	if s.opts.OnSwitch != nil {
+		s.opts.OnSwitch(addr)
+	}
+}
+
+// dialMaster connects to the master. If that fails, the master's address is looked up again in case it has moved.
+func (s *Sentinel) dialMaster() (*Conn, error) {
+	addr := s.Addr()
+	nc, err := net.DialTimeout("tcp", addr, s.opts.Timeout)
+	if err != nil {
+		naddr, derr := s.Discover()
+		if derr != nil || naddr == addr {
+			return nil, err
+		}
+		if nc, err = net.DialTimeout("tcp", naddr, s.opts.Timeout); err != nil {
+			return nil, err
+		}
+	}
+	return NewConn(nc), nil
+}
+
+// dialSentinel connects the PubSub to the first reachable sentinel. Once it has connected before, reconnecting also
+// looks up the master, since a failover may have been announced while it was disconnected.
+func (s *Sentinel) dialSentinel() (net.Conn, error) {
+	s.mu.Lock()
+	sentinels := append([]string(nil), s.sentinels...)
+	s.mu.Unlock()
+
+	var err error
+	for _, addr := range sentinels {
+		var nc net.Conn
+		if nc, err = net.DialTimeout("tcp", addr, s.opts.Timeout); err != nil {
+			continue
+		}
+
+		s.mu.Lock()
+		s.dials++
+		reconnect := s.dials > 1
+		s.mu.Unlock()
+		if reconnect {
+			go s.Discover()
+		}
+		return nc, nil
+	}
+	return nil, err
+}
+
+// watch switches masters when a sentinel announces a failover.
+func (s *Sentinel) watch() {
+	defer close(s.done)
+	for m := range s.ps.Messages() {
+		if m.Channel != switchMaster {
+			continue
+		}
+
+		// <master name> <old ip> <old port> <new ip> <new port>
+		fields := strings.Fields(string(m.Data))
+		if len(fields) != 5 || fields[0] != s.name {
+			continue
+		}
+		s.setAddr(net.JoinHostPort(fields[3], fields[4]))
+	}
+}
@@ -0,0 +1,134 @@
+package client
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/resv"
+	"github.com/nilium/fred/resv/memstore"
+	"github.com/nilium/fred/resv/resvtest"
+)
+
+// fakeSentinel answers SENTINEL get-master-addr-by-name from a map of master names to addresses.
+type fakeSentinel struct {
+	mu      sync.Mutex
+	masters map[string]string
+}
+
+func (f *fakeSentinel) setMaster(name, addr string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.masters[name] = addr
+}
+
+func (f *fakeSentinel) ServeRESP(w resv.ResponseWriter, r fred.Resp) error {
+	args, err := r.StrList()
+	if err != nil {
+		return err
+	}
+	if len(args) != 3 || !strings.EqualFold(args[0], "SENTINEL") || !strings.EqualFold(args[1], "get-master-addr-by-name") {
+		return w.Write(fred.Error("ERR unsupported command"))
+	}
+
+	f.mu.Lock()
+	addr, ok := f.masters[args[2]]
+	f.mu.Unlock()
+	if !ok {
+		return w.Write(resv.NullArray(w))
+	}
+	host, port, _ := net.SplitHostPort(addr)
+	return w.Write([]string{host, port})
+}
+
+// startSentinel starts a fake sentinel that knows of a single master.
+func startSentinel(t *testing.T, name, addr string) (*pubsubServer, *fakeSentinel) {
+	f := &fakeSentinel{masters: map[string]string{name: addr}}
+	srv := startPubSubServer(t)
+	srv.handler = f
+	return srv, f
+}
+
+// startMaster starts a memstore server whose "who" key is set to id.
+func startMaster(t *testing.T, id string) *resvtest.Server {
+	srv := resvtest.NewServer(memstore.New())
+	t.Cleanup(srv.Close)
+	conn := srv.Conn(t)
+	defer conn.Close()
+	conn.Expect(t, "OK", "SET", "who", id)
+	return srv
+}
+
+// deadAddr returns the address of a closed listener.
+func deadAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	return l.Addr().String()
+}
+
+func TestSentinel(t *testing.T) {
+	m1, m2 := startMaster(t, "m1"), startMaster(t, "m2")
+	srv, f := startSentinel(t, "mymaster", m1.Addr)
+
+	switched := make(chan string, 1)
+	s, err := NewSentinel("mymaster", []string{deadAddr(t), srv.l.Addr().String()}, SentinelOptions{
+		OnSwitch: func(addr string) { switched <- addr },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if s.Addr() != m1.Addr {
+		t.Errorf("Addr() = %s; want %s", s.Addr(), m1.Addr)
+	}
+	resvtest.AssertReply(t, s.Do("GET", "who"), "m1")
+
+	f.setMaster("mymaster", m2.Addr)
+	host1, port1, _ := net.SplitHostPort(m1.Addr)
+	host2, port2, _ := net.SplitHostPort(m2.Addr)
+	srv.publish(switchMaster, "othermaster "+host2+" "+port2+" "+host1+" "+port1)
+	srv.publish(switchMaster, "mymaster "+host1+" "+port1+" "+host2+" "+port2)
+
+	select {
+	case addr := <-switched:
+		if addr != m2.Addr {
+			t.Fatalf("switched to %s; want %s", addr, m2.Addr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the switch")
+	}
+	resvtest.AssertReply(t, s.Do("GET", "who"), "m2")
+}
+
+func TestSentinelMasterDown(t *testing.T) {
+	m1, m2 := startMaster(t, "m1"), startMaster(t, "m2")
+	srv, f := startSentinel(t, "mymaster", m1.Addr)
+
+	s, err := NewSentinel("mymaster", []string{srv.l.Addr().String()}, SentinelOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// The failover isn't announced, so the new master is found when dialing the old one fails.
+	f.setMaster("mymaster", m2.Addr)
+	m1.Close()
+	resvtest.AssertReply(t, s.Do("GET", "who"), "m2")
+	if s.Addr() != m2.Addr {
+		t.Errorf("Addr() = %s; want %s", s.Addr(), m2.Addr)
+	}
+}
+
+func TestSentinelNoMaster(t *testing.T) {
+	srv, _ := startSentinel(t, "mymaster", "127.0.0.1:1")
+	if _, err := NewSentinel("other", []string{srv.l.Addr().String()}, SentinelOptions{}); err != ErrNoMaster {
+		t.Errorf("NewSentinel(other) error = %v; want %v", err, ErrNoMaster)
+	}
+}
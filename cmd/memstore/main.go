@@ -0,0 +1,68 @@
+// Command memstore runs an in-memory, Redis-compatible server backed by the resv/memstore package.
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/resv"
+	"github.com/nilium/fred/resv/memstore"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:6379", "the address to listen on")
+	databases := flag.Int("databases", memstore.DefaultDatabases, "the number of databases")
+	maxConns := flag.Int("max-clients", 0, "the maximum number of clients; 0 for no limit")
+	flag.Parse()
+
+	if *databases < 1 {
+		log.Fatal("-databases must be at least 1")
+	}
+
+	store := memstore.NewDatabases(*databases)
+	srv := resv.NewServer(nil)
+	srv.MaxConns = *maxConns
+
+	info := srv.InfoHandler(store.Info)
+	client := srv.ClientHandler()
+	hello := srv.HelloHandler(resv.HelloConfig{Server: "memstore"})
+	srv.Handler = resv.Recover(nil)(resv.HandlerFunc(func(w resv.ResponseWriter, r fred.Resp) error {
+		switch resv.CommandName(r) {
+		case "INFO":
+			return info.ServeRESP(w, r)
+		case "CLIENT":
+			return client.ServeRESP(w, r)
+		case "HELLO":
+			return hello.ServeRESP(w, r)
+		}
+		return store.ServeRESP(w, r)
+	}))
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Listening on %v", l.Addr())
+
+	stopped := make(chan struct{})
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		log.Print("Shutting down")
+		close(stopped)
+		srv.Close()
+	}()
+
+	if err := srv.Serve(l); err != nil {
+		select {
+		case <-stopped:
+		default:
+			log.Fatal(err)
+		}
+	}
+}
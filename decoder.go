@@ -0,0 +1,182 @@
+package fred
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Default limits of a Decoder.
+const (
+	// DefaultMaxBulkLen is the default maximum length of a bulk string, the same as Redis's proto-max-bulk-len.
+	DefaultMaxBulkLen = 512 << 20
+	// DefaultMaxAggregateLen is the default maximum number of elements in an array, set, or push, or of pairs in a map.
+	DefaultMaxAggregateLen = 1 << 24
+	// DefaultMaxDepth is the default maximum nesting of arrays, sets, pushes, and maps.
+	DefaultMaxDepth = 64
+)
+
+var (
+	ErrBulkTooLong      = errors.New("bulk string exceeds the decoder's limit")
+	ErrAggregateTooLong = errors.New("aggregate exceeds the decoder's limit")
+	ErrTooDeep          = errors.New("aggregate nesting exceeds the decoder's limit")
+)
+
+// DecodeError is returned by a Decoder that failed to read a value, such as due to malformed input or an exceeded
+// limit. Error replies are values, so they're returned as an Error instead.
+type DecodeError struct {
+	// Offset is the offset of the start of the value in the input.
+	Offset int64
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("fred: decoding value at offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Decoder reads values from an input stream. It reads ahead, so it may read more from its input than the values
+// decoded.
+//
+// A Decoder may be used to read many values without reading each with a new bufio.Reader, as Read and Scan require.
+type Decoder struct {
+	// MaxBulkLen is the maximum length of a bulk string, verbatim string, or blob error. MaxAggregateLen is the maximum
+	// number of elements in an array, set, or push, or of pairs in a map. MaxDepth is the maximum nesting of these.
+	// They're set to their defaults by NewDecoder. If zero or negative, there is no limit.
+	MaxBulkLen      int64
+	MaxAggregateLen int64
+	MaxDepth        int
+
+	s decodeScanner
+	// err is the error that stopped the decoder. Once set, Decode returns it.
+	err error
+}
+
+// NewDecoder returns a Decoder that reads from r with the default limits.
+func NewDecoder(r io.Reader) *Decoder {
+	d := &Decoder{
+		MaxBulkLen:      DefaultMaxBulkLen,
+		MaxAggregateLen: DefaultMaxAggregateLen,
+		MaxDepth:        DefaultMaxDepth,
+	}
+	d.s = decodeScanner{d: d, r: bufio.NewReader(r)}
+	return d
+}
+
+// Decode reads the next value and stores it in dst, as Scan does. If dst is a *Resp, the value is stored as read,
+// including error replies.
+//
+// Decode returns io.EOF if the input ends before the next value. If the input ends within a value or is malformed,
+// Decode returns a *DecodeError, and so does every later call. If the value is an error reply, it is returned as an
+// Error, and the Decoder can read the next value.
+func (d *Decoder) Decode(dst interface{}) error {
+	if d.err != nil {
+		return d.err
+	}
+	if !d.More() {
+		return d.err
+	}
+
+	start := d.s.off
+	d.s.depth = 0
+	resp := Read(&d.s)
+	if resp.Err != nil && !resp.IsType(Err) {
+		d.err = &DecodeError{Offset: start, Err: resp.Err}
+		return d.err
+	}
+
+	if p, ok := dst.(*Resp); ok {
+		*p = resp
+		return nil
+	}
+	return resp.Scan(dst)
+}
+
+// More reports whether there's another value to decode, waiting for input if necessary. It returns false once the
+// input has ended or the Decoder has failed.
+func (d *Decoder) More() bool {
+	if d.err != nil {
+		return false
+	}
+	if _, err := d.s.r.Peek(1); err != nil {
+		d.err = err
+		return false
+	}
+	return true
+}
+
+// InputOffset returns the offset in the input of the end of the last value decoded.
+func (d *Decoder) InputOffset() int64 {
+	return d.s.off
+}
+
+// decodeScanner is the ByteScanner a Decoder passes to Read. It counts the bytes read and enforces the Decoder's
+// limits.
+type decodeScanner struct {
+	d     *Decoder
+	r     *bufio.Reader
+	off   int64
+	depth int
+}
+
+func (s *decodeScanner) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.off += int64(n)
+	return n, err
+}
+
+func (s *decodeScanner) ReadByte() (byte, error) {
+	b, err := s.r.ReadByte()
+	if err == nil {
+		s.off++
+	}
+	return b, err
+}
+
+func (s *decodeScanner) UnreadByte() error {
+	err := s.r.UnreadByte()
+	if err == nil {
+		s.off--
+	}
+	return err
+}
+
+func (s *decodeScanner) ReadBytes(delim byte) ([]byte, error) {
+	line, err := s.r.ReadBytes(delim)
+	s.off += int64(len(line))
+	return line, err
+}
+
+// limiter is implemented by ByteScanners that limit the size of values read from them.
+type limiter interface {
+	limitBulk(size int64) error
+	// enter is called before reading the elements of an aggregate of size elements, and leave after.
+	enter(size int64) error
+	leave()
+}
+
+func (s *decodeScanner) limitBulk(size int64) error {
+	if max := s.d.MaxBulkLen; max > 0 && size > max {
+		return ErrBulkTooLong
+	}
+	return nil
+}
+
+func (s *decodeScanner) enter(size int64) error {
+	if max := s.d.MaxAggregateLen; max > 0 && size > max {
+		return ErrAggregateTooLong
+	}
+	if max := s.d.MaxDepth; max > 0 && s.depth >= max {
+		return ErrTooDeep
+	}
+	s.depth++
+	return nil
+}
+
+func (s *decodeScanner) leave() {
+	s.depth--
+}
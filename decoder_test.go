@@ -0,0 +1,106 @@
+package fred
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecoder(t *testing.T) {
+	d := NewDecoder(strings.NewReader("+OK\r\n:42\r\n-ERR failed\r\n*2\r\n$1\r\na\r\n$1\r\nb\r\n"))
+
+	var s string
+	if err := d.Decode(&s); err != nil || s != "OK" {
+		t.Errorf("Decode() = %q, %v; want OK", s, err)
+	}
+	if off := d.InputOffset(); off != 5 {
+		t.Errorf("InputOffset() = %d; want 5", off)
+	}
+
+	var resp Resp
+	if err := d.Decode(&resp); err != nil || !resp.IsType(Int) {
+		t.Errorf("Decode() into a Resp = %#v, %v; want an integer", resp, err)
+	}
+
+	// An error reply is returned, and decoding goes on.
+	if err := d.Decode(&s); err != Error("ERR failed") {
+		t.Errorf("Decode() of an error reply = %v; want ERR failed", err)
+	}
+
+	var list []string
+	if !d.More() {
+		t.Fatal("More() = false; want true")
+	}
+	if err := d.Decode(&list); err != nil || !reflect.DeepEqual(list, []string{"a", "b"}) {
+		t.Errorf("Decode() = %q, %v; want [a b]", list, err)
+	}
+
+	if d.More() {
+		t.Error("More() at the end of the input = true; want false")
+	}
+	if err := d.Decode(&s); err != io.EOF {
+		t.Errorf("Decode() at the end of the input = %v; want %v", err, io.EOF)
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	d := NewDecoder(strings.NewReader(":1\r\n*2\r\n:2\r\n"))
+	var n int64
+	if err := d.Decode(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	// A value cut short is an error at its offset, and the error sticks.
+	err := d.Decode(&n)
+	var de *DecodeError
+	if !errors.As(err, &de) || de.Offset != 4 || de.Err != io.ErrUnexpectedEOF {
+		t.Fatalf("Decode() of a truncated value = %v; want a DecodeError at offset 4", err)
+	}
+	if again := d.Decode(&n); again != err {
+		t.Errorf("second Decode() = %v; want %v", again, err)
+	}
+	if d.More() {
+		t.Error("More() after an error = true; want false")
+	}
+}
+
+func TestDecoderLimits(t *testing.T) {
+	cases := []struct {
+		msg   string
+		limit func(*Decoder)
+		err   error
+	}{
+		{"$5\r\nhello\r\n", func(d *Decoder) { d.MaxBulkLen = 4 }, ErrBulkTooLong},
+		{"!5\r\nERR x\r\n", func(d *Decoder) { d.MaxBulkLen = 4 }, ErrBulkTooLong},
+		{"*3\r\n:1\r\n:2\r\n:3\r\n", func(d *Decoder) { d.MaxAggregateLen = 2 }, ErrAggregateTooLong},
+		{"%2\r\n+a\r\n:1\r\n+b\r\n:2\r\n", func(d *Decoder) { d.MaxAggregateLen = 1 }, ErrAggregateTooLong},
+		{"*1\r\n*1\r\n*1\r\n:1\r\n", func(d *Decoder) { d.MaxDepth = 2 }, ErrTooDeep},
+	}
+	for _, c := range cases {
+		d := NewDecoder(strings.NewReader(c.msg))
+		c.limit(d)
+		var resp Resp
+		if err := d.Decode(&resp); !errors.Is(err, c.err) {
+			t.Errorf("Decode(%q) = %v; want %v", c.msg, err, c.err)
+		}
+
+		// Without limits, the same value is decoded.
+		d = NewDecoder(strings.NewReader(c.msg))
+		d.MaxBulkLen, d.MaxAggregateLen, d.MaxDepth = 0, 0, 0
+		if err := d.Decode(&resp); err != nil && !resp.IsType(Err) {
+			t.Errorf("Decode(%q) without limits = %v", c.msg, err)
+		}
+	}
+
+	// The depth is counted per value.
+	d := NewDecoder(strings.NewReader("*1\r\n*1\r\n:1\r\n*1\r\n*1\r\n:2\r\n"))
+	d.MaxDepth = 2
+	for i := 0; i < 2; i++ {
+		var resp Resp
+		if err := d.Decode(&resp); err != nil {
+			t.Errorf("Decode() of value %d = %v", i, err)
+		}
+	}
+}
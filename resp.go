@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"strconv"
 )
 
@@ -24,11 +25,26 @@ const (
 	Int
 	Array
 	Nil
+
+	// RESP3 types. Doubles and big numbers hold their text, so they can be read with Str, Bytes, or Scan. Maps hold
+	// their keys and values as a flat array of alternating keys and values. Maps, sets, and pushes can be read with
+	// Array.
+	Double
+	Bool
+	BigNum
+	Map
+	Set
+	Push
 )
 
 const (
 	Str     = SimpleStr | BulkStr
-	Invalid = ^(Str | Err | Int | Array | Nil)
+	Invalid = ^(Str | Err | Int | Array | Nil | Double | Bool | BigNum | Map | Set | Push)
+
+	// aggregate is the set of types read as arrays.
+	aggregate = Array | Map | Set | Push
+	// text is the set of types read as strings.
+	text = Str | Double | BigNum
 )
 
 func (t Type) String() string {
@@ -45,6 +61,18 @@ func (t Type) String() string {
 		return "Array"
 	case Nil:
 		return "Nil"
+	case Double:
+		return "Double"
+	case Bool:
+		return "Bool"
+	case BigNum:
+		return "BigNum"
+	case Map:
+		return "Map"
+	case Set:
+		return "Set"
+	case Push:
+		return "Push"
 	}
 	return "Invalid"
 }
@@ -65,7 +93,7 @@ func (r Resp) Bytes() ([]byte, error) {
 		return nil, nil
 	}
 
-	if b, ok := r.value.([]byte); ok && r.IsType(Str) {
+	if b, ok := r.value.([]byte); ok && r.IsType(text) {
 		bc := make([]byte, len(b))
 		copy(bc, b)
 		return bc, nil
@@ -89,6 +117,14 @@ func (r Resp) BytesList() ([][]byte, error) {
 	return result, nil
 }
 
+// Type returns the type of r, or Invalid if r was not read successfully.
+func (r Resp) Type() Type {
+	if r.typ == 0 {
+		return Invalid
+	}
+	return r.typ
+}
+
 func (r Resp) IsType(t Type) bool {
 	if r.typ == 0 && t == Invalid {
 		return true
@@ -103,7 +139,7 @@ func (r Resp) Str() (string, error) {
 		return "", nil
 	}
 
-	if bs, ok := r.value.([]byte); ok && r.IsType(Str) {
+	if bs, ok := r.value.([]byte); ok && r.IsType(text) {
 		return string(bs), nil
 	}
 	return "", ErrWrongType
@@ -130,7 +166,7 @@ func (r Resp) Array() ([]Resp, error) {
 		return nil, r.Err
 	}
 
-	if ary, ok := r.value.([]Resp); ok && r.IsType(Array) {
+	if ary, ok := r.value.([]Resp); ok && r.IsType(aggregate) {
 		return ary, nil
 	}
 	// As a special case, if r is not an array, it will return itself in an array.
@@ -150,9 +186,14 @@ func (r Resp) Int() (int64, error) {
 
 func (r Resp) Value() (interface{}, error) {
 	switch r.typ {
-	case SimpleStr, BulkStr:
+	case SimpleStr, BulkStr, BigNum:
 		s, err := r.Str()
 		return s, err
+	case Double:
+		return toFloat(r.value, 64)
+	case Bool:
+		i, _ := r.value.(int64)
+		return i != 0, nil
 	case Int:
 		i, err := r.Int()
 		return i, err
@@ -160,7 +201,7 @@ func (r Resp) Value() (interface{}, error) {
 		return r.Err, nil
 	case Nil:
 		return nil, nil
-	case Array:
+	case Array, Map, Set, Push:
 		var ary []interface{}
 		actual, err := r.Array()
 		if err != nil {
@@ -353,6 +394,11 @@ func readBulkString(size int64, r ByteScanner) ([]byte, error) {
 	if size < 0 {
 		return nil, ErrBadSize
 	}
+	if l, ok := r.(limiter); ok {
+		if err := l.limitBulk(size); err != nil {
+			return nil, err
+		}
+	}
 
 	var buf []byte
 	if size > 0 {
@@ -373,29 +419,63 @@ func readBulkString(size int64, r ByteScanner) ([]byte, error) {
 	return buf, nil
 }
 
+// errNullArray is returned by readArray when it reads a null array, which is read as a Nil Resp.
+var errNullArray = errors.New("null array")
+
 func readArray(r ByteScanner) ([]Resp, error) {
+	return readAggregate(r, 1)
+}
+
+// readAggregate reads an array, map, set, push, or attribute of size elements, each made up of per values: 1 for
+// arrays, sets, and pushes, and 2 for the keys and values of maps and attributes.
+func readAggregate(r ByteScanner, per int64) ([]Resp, error) {
 	// NOTE: Rewrite this so it's not recursive? Though the chance of that being an issue is slim.
 	size, err := readInteger(r)
 	if err != nil {
 		return nil, err
 	}
 
-	if size == 0 {
+	if size == -1 {
+		return nil, errNullArray
+	} else if size < 0 {
+		return nil, ErrBadSize
+	} else if size == 0 {
 		return nil, nil
 	}
 
-	ary := make([]Resp, size)
+	if l, ok := r.(limiter); ok {
+		if err := l.enter(size); err != nil {
+			return nil, err
+		}
+		defer l.leave()
+	}
+
+	if size > math.MaxInt64/per {
+		return nil, ErrBadSize
+	}
+	size *= per
 
-	for i := 0; size > 0; i, size = i+1, size-1 {
-		ary[i] = Read(r)
-		if ary[i].Err != nil {
-			return nil, ary[i].Err
+	// The declared size is not trusted to preallocate, so that a bogus size fails when the input ends instead of
+	// exhausting memory.
+	prealloc := size
+	if prealloc > maxAggregatePrealloc {
+		prealloc = maxAggregatePrealloc
+	}
+	ary := make([]Resp, 0, prealloc)
+	for ; size > 0; size-- {
+		elem := Read(r)
+		if elem.Err != nil {
+			return nil, elem.Err
 		}
+		ary = append(ary, elem)
 	}
 
 	return ary, nil
 }
 
+// maxAggregatePrealloc is the maximum number of elements readAggregate allocates before reading them.
+const maxAggregatePrealloc = 1024
+
 func Read(r ByteScanner) (resp Resp) {
 	defer func() {
 		if resp.Err != nil && resp.typ != Err {
@@ -439,8 +519,14 @@ func Read(r ByteScanner) (resp Resp) {
 
 	case '*':
 		ary, err := readArray(r)
+		if err == errNullArray {
+			return Resp{Nil, nil, nil}
+		}
 		return Resp{Array, ary, err}
 
+	case '_', '#', ',', '(', '!', '=', '%', '~', '>', '|':
+		return readRESP3(b, r)
+
 	default:
 		err = BadTypeError(b)
 	}
@@ -0,0 +1,118 @@
+package fred
+
+import (
+	"errors"
+	"strconv"
+)
+
+var (
+	ErrMalformedNull   = errors.New("null response is malformed")
+	ErrMalformedBool   = errors.New("boolean response is malformed")
+	ErrMalformedDouble = errors.New("double response is malformed")
+	ErrMalformedBigNum = errors.New("big number response is malformed")
+)
+
+// readRESP3 reads a value of one of the types added by RESP3, whose type byte b has already been read.
+//
+// Verbatim strings are read as bulk strings without their format prefix, and blob errors are read as errors.
+// Attributes are skipped, so the value they describe is returned in their place.
+func readRESP3(b byte, r ByteScanner) Resp {
+	switch b {
+	case '_':
+		line, err := readSimpleString(r)
+		if err == nil && len(line) != 0 {
+			err = ErrMalformedNull
+		}
+		return Resp{Nil, nil, err}
+
+	case '#':
+		line, err := readSimpleString(r)
+		if err != nil {
+			return Resp{Invalid, nil, err}
+		}
+		switch string(line) {
+		case "t":
+			return Resp{Bool, int64(1), nil}
+		case "f":
+			return Resp{Bool, int64(0), nil}
+		}
+		return Resp{Invalid, nil, ErrMalformedBool}
+
+	case ',':
+		line, err := readSimpleString(r)
+		if err == nil {
+			if _, perr := strconv.ParseFloat(string(line), 64); perr != nil {
+				err = ErrMalformedDouble
+			}
+		}
+		return Resp{Double, line, err}
+
+	case '(':
+		line, err := readSimpleString(r)
+		if err == nil && !isBigNum(line) {
+			err = ErrMalformedBigNum
+		}
+		if err != nil {
+			return Resp{Invalid, nil, err}
+		}
+		return Resp{BigNum, line, nil}
+
+	case '!', '=':
+		size, err := readInteger(r)
+		if err != nil {
+			return Resp{Invalid, nil, err}
+		}
+		s, err := readBulkString(size, r)
+		if err != nil {
+			return Resp{Invalid, nil, err}
+		}
+		if b == '!' {
+			return Resp{Err, Error(s), Error(s)}
+		}
+		// Verbatim strings are prefixed with a three-character format, such as "txt:".
+		if len(s) >= 4 && s[3] == ':' {
+			s = s[4:]
+		}
+		return Resp{BulkStr, s, nil}
+	}
+
+	typ, per := Array, int64(1)
+	switch b {
+	case '%':
+		typ, per = Map, 2
+	case '~':
+		typ = Set
+	case '>':
+		typ = Push
+	case '|':
+		per = 2
+	}
+
+	ary, err := readAggregate(r, per)
+	if err == errNullArray {
+		err = ErrBadSize
+	}
+	if err != nil {
+		return Resp{Invalid, nil, err}
+	}
+	if b == '|' {
+		return Read(r)
+	}
+	return Resp{typ, ary, nil}
+}
+
+// isBigNum reports whether b is a decimal integer with an optional sign.
+func isBigNum(b []byte) bool {
+	if len(b) > 0 && (b[0] == '-' || b[0] == '+') {
+		b = b[1:]
+	}
+	if len(b) == 0 {
+		return false
+	}
+	for _, c := range b {
+		if c < '0' || '9' < c {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package fred
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestRESP3Read(t *testing.T) {
+	cases := []struct {
+		msg   string
+		typ   Type
+		value interface{}
+	}{
+		{"_\r\n", Nil, nil},
+		{"#t\r\n", Bool, true},
+		{"#f\r\n", Bool, false},
+		{",3.25\r\n", Double, 3.25},
+		{",-inf\r\n", Double, nil},
+		{"(3492890328409238509324850943850943825024385\r\n", BigNum, "3492890328409238509324850943850943825024385"},
+		{"=15\r\ntxt:Some string\r\n", BulkStr, "Some string"},
+		{"%2\r\n+a\r\n:1\r\n+b\r\n:2\r\n", Map, []interface{}{"a", int64(1), "b", int64(2)}},
+		{"~2\r\n+a\r\n+b\r\n", Set, []interface{}{"a", "b"}},
+		{">2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nkey\r\n", Push, []interface{}{"invalidate", []interface{}{"key"}}},
+		{"|1\r\n+ttl\r\n:3600\r\n:42\r\n", Int, int64(42)},
+	}
+	for _, c := range cases {
+		resp := Read(bytes.NewBufferString(c.msg))
+		if resp.Err != nil {
+			t.Errorf("Read(%q) failed: %v", c.msg, resp.Err)
+			continue
+		}
+		if !resp.IsType(c.typ) {
+			t.Errorf("Read(%q) type = %v; want %v", c.msg, resp.typ, c.typ)
+		}
+		if c.value == nil {
+			continue
+		}
+		if v, err := resp.Value(); err != nil || !reflect.DeepEqual(v, c.value) {
+			t.Errorf("Read(%q).Value() = %#v, %v; want %#v", c.msg, v, err, c.value)
+		}
+	}
+}
+
+func TestRESP3ReadErrors(t *testing.T) {
+	resp := Read(bytes.NewBufferString("!21\r\nSYNTAX invalid syntax\r\n"))
+	if resp.Err != Error("SYNTAX invalid syntax") || !resp.IsType(Err) {
+		t.Errorf("Read() of a blob error = %#v; want SYNTAX error", resp)
+	}
+
+	for _, msg := range []string{"#x\r\n", ",abc\r\n", "(12a\r\n", "(\r\n", "_x\r\n", "%-1\r\n"} {
+		if resp := Read(bytes.NewBufferString(msg)); resp.Err == nil {
+			t.Errorf("Read(%q) = %#v; want an error", msg, resp)
+		}
+	}
+}
+
+func TestRESP3MapScan(t *testing.T) {
+	var data map[string]int64
+	if err := Scan(bytes.NewBufferString("%2\r\n+a\r\n:1\r\n+b\r\n,2\r\n"), &data); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int64{"a": 1, "b": 2}; !reflect.DeepEqual(data, want) {
+		t.Errorf("data = %v; want %v", data, want)
+	}
+
+	var ok bool
+	if err := Scan(bytes.NewBufferString("#t\r\n"), &ok); err != nil || !ok {
+		t.Errorf("Scan() of #t = %v, %v; want true, nil", ok, err)
+	}
+}
+
+func TestReadAggregateSize(t *testing.T) {
+	// Sizes that overflow once multiplied for a map's keys and values, or that are larger than the input, fail
+	// instead of panicking or allocating the declared size.
+	for _, msg := range []string{"%4611686018427387904\r\n", "*9223372036854775807\r\n:1\r\n"} {
+		if resp := Read(bytes.NewBufferString(msg)); resp.Err == nil {
+			t.Errorf("Read(%q) = %#v; want an error", msg, resp)
+		}
+	}
+}
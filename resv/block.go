@@ -0,0 +1,157 @@
+package resv
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nilium/fred"
+)
+
+var (
+	// ErrBlockTimeout is returned by Blocked.Wait when the timeout elapses or the client is unblocked by CLIENT
+	// UNBLOCK with TIMEOUT. A null reply has already been written.
+	ErrBlockTimeout = errors.New("resv: blocking command timed out")
+	// ErrUnblocked is returned by Blocked.Wait when the client is unblocked by CLIENT UNBLOCK with ERROR. An
+	// UNBLOCKED error reply has already been written.
+	ErrUnblocked = errors.New("resv: client unblocked")
+	// ErrClientClosed is returned by Blocked.Wait when the client disconnects or is killed while blocked. No reply is
+	// written.
+	ErrClientClosed = errors.New("resv: client closed while blocked")
+	// ErrNotBlockable is returned by Blocked.Wait if the ResponseWriter passed to Notifier.Block has no Client.
+	ErrNotBlockable = errors.New("resv: response writer cannot block")
+)
+
+var errUnblockedReply = fred.Error("UNBLOCKED client unblocked via CLIENT UNBLOCK")
+
+// Notifier wakes clients blocked on keys, for implementing blocking commands such as BLPOP. The zero value is ready to
+// use.
+//
+// A blocking command registers interest in its keys with Block before checking for data, so that a Signal between the
+// check and the wait isn't missed:
+//
+//	b := notifier.Block(w, timeout, keys...)
+//	defer b.Stop()
+//	for {
+//		if v, ok := pop(keys); ok {
+//			return w.Write(v)
+//		}
+//		if _, err := b.Wait(); err != nil {
+//			return nil // Wait has already replied, if a reply is needed.
+//		}
+//	}
+type Notifier struct {
+	mu      sync.Mutex
+	waiters map[string]map[*Blocked]struct{}
+}
+
+// Blocked is a client blocked on keys of a Notifier.
+type Blocked struct {
+	n        *Notifier
+	w        ResponseWriter
+	client   *Client
+	keys     []string
+	deadline time.Time
+
+	signal  chan string
+	unblock chan bool // true if the client should be unblocked with an error
+}
+
+// Block registers the client that w writes to as waiting on keys. If timeout is greater than zero, Wait returns
+// ErrBlockTimeout once it elapses. The returned Blocked must be stopped with Stop once the command completes.
+func (n *Notifier) Block(w ResponseWriter, timeout time.Duration, keys ...string) *Blocked {
+	b := &Blocked{
+		n:       n,
+		w:       w,
+		client:  ClientOf(w),
+		keys:    keys,
+		signal:  make(chan string, 1),
+		unblock: make(chan bool, 1),
+	}
+	if timeout > 0 {
+		b.deadline = time.Now().Add(timeout)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.waiters == nil {
+		n.waiters = make(map[string]map[*Blocked]struct{})
+	}
+	for _, key := range keys {
+		set := n.waiters[key]
+		if set == nil {
+			set = make(map[*Blocked]struct{})
+			n.waiters[key] = set
+		}
+		set[b] = struct{}{}
+	}
+
+	return b
+}
+
+// Signal wakes all clients blocked on key. Clients that are not currently in Wait are woken immediately by their
+// next call to Wait.
+func (n *Notifier) Signal(key string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for b := range n.waiters[key] {
+		select {
+		case b.signal <- key:
+		default:
+		}
+	}
+}
+
+// Stop unregisters b from its Notifier.
+func (b *Blocked) Stop() {
+	n := b.n
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, key := range b.keys {
+		set := n.waiters[key]
+		delete(set, b)
+		if len(set) == 0 {
+			delete(n.waiters, key)
+		}
+	}
+}
+
+// Wait blocks until one of b's keys is signaled and returns that key. If the timeout elapses, the client is unblocked
+// by CLIENT UNBLOCK, or the client disconnects, Wait returns an error. In that case, Wait has already written any reply
+// needed and the handler should return nil.
+func (b *Blocked) Wait() (key string, err error) {
+	if b.client == nil {
+		return "", ErrNotBlockable
+	}
+
+	var timeout <-chan time.Time
+	if !b.deadline.IsZero() {
+		timer := time.NewTimer(time.Until(b.deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	closed, stop := b.client.block(b)
+	defer stop()
+
+	select {
+	case key = <-b.signal:
+		return key, nil
+	case <-timeout:
+		err = ErrBlockTimeout
+	case withErr := <-b.unblock:
+		err = ErrBlockTimeout
+		if withErr {
+			err = ErrUnblocked
+		}
+	case <-closed:
+		return "", ErrClientClosed
+	}
+
+	if err == ErrUnblocked {
+		b.w.Write(errUnblockedReply)
+	} else {
+		b.w.Write(NullArray(b.w))
+	}
+	return "", err
+}
@@ -0,0 +1,137 @@
+package resv
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nilium/fred"
+)
+
+// listStore implements LPUSH and a BLPOP that takes a timeout in milliseconds.
+type listStore struct {
+	mu     sync.Mutex
+	lists  map[string][]string
+	notify Notifier
+	srv    *Server
+	exited chan error
+}
+
+func (s *listStore) pop(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.lists[key]
+	if len(l) == 0 {
+		return "", false
+	}
+	s.lists[key] = l[1:]
+	return l[0], true
+}
+
+func (s *listStore) ServeRESP(w ResponseWriter, r fred.Resp) error {
+	args, err := r.StrList()
+	if err != nil {
+		return err
+	}
+
+	switch CommandName(r) {
+	case "CLIENT":
+		return s.srv.ClientHandler().ServeRESP(w, r)
+
+	case "LPUSH":
+		s.mu.Lock()
+		s.lists[args[1]] = append(s.lists[args[1]], args[2])
+		s.mu.Unlock()
+		s.notify.Signal(args[1])
+		return w.Write(1)
+
+	case "BLPOP":
+		var ms int
+		fmt.Sscan(args[2], &ms)
+
+		b := s.notify.Block(w, time.Duration(ms)*time.Millisecond, args[1])
+		defer b.Stop()
+		for {
+			if v, ok := s.pop(args[1]); ok {
+				return w.Write([]string{args[1], v})
+			}
+			if _, err := b.Wait(); err != nil {
+				s.exited <- err
+				return nil
+			}
+		}
+	}
+	return w.Write(fred.Error("ERR unknown command"))
+}
+
+func TestBlocking(t *testing.T) {
+	store := &listStore{lists: map[string][]string{}, exited: make(chan error, 1)}
+	srv := NewServer(store)
+	store.srv = srv
+	addr := startServer(t, srv)
+
+	conn, r := dialServer(t, addr)
+	other, or := dialServer(t, addr)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	other.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// Timeout
+	io.WriteString(conn, "*3\r\n$5\r\nBLPOP\r\n$1\r\nq\r\n$2\r\n10\r\n")
+	expectRaw(t, r, "*-1\r\n")
+	if err := <-store.exited; err != ErrBlockTimeout {
+		t.Errorf("Wait() = %v; want %v", err, ErrBlockTimeout)
+	}
+
+	// Woken by a push from another client.
+	io.WriteString(conn, "*3\r\n$5\r\nBLPOP\r\n$1\r\nq\r\n$1\r\n0\r\n")
+	waitForState(t, srv.Client(1), StateBlocked)
+	io.WriteString(other, "*3\r\n$5\r\nLPUSH\r\n$1\r\nq\r\n$1\r\nv\r\n")
+	expectRaw(t, or, ":1\r\n")
+	expectRaw(t, r, "*2\r\n$1\r\nq\r\n$1\r\nv\r\n")
+
+	// Unblocked with an error.
+	io.WriteString(conn, "*3\r\n$5\r\nBLPOP\r\n$1\r\nq\r\n$1\r\n0\r\n")
+	waitForState(t, srv.Client(1), StateBlocked)
+	io.WriteString(other, "*4\r\n$6\r\nCLIENT\r\n$7\r\nUNBLOCK\r\n$1\r\n1\r\n$5\r\nERROR\r\n")
+	expectRaw(t, or, ":1\r\n")
+	expectRaw(t, r, "-UNBLOCKED client unblocked via CLIENT UNBLOCK\r\n")
+	if err := <-store.exited; err != ErrUnblocked {
+		t.Errorf("Wait() = %v; want %v", err, ErrUnblocked)
+	}
+
+	// Commands sent while blocked run after the block ends.
+	io.WriteString(conn, "*3\r\n$5\r\nBLPOP\r\n$1\r\nq\r\n$1\r\n0\r\n")
+	waitForState(t, srv.Client(1), StateBlocked)
+	io.WriteString(conn, "*3\r\n$5\r\nLPUSH\r\n$1\r\np\r\n$1\r\nx\r\n")
+	io.WriteString(other, "*3\r\n$5\r\nLPUSH\r\n$1\r\nq\r\n$1\r\nw\r\n")
+	expectRaw(t, or, ":1\r\n")
+	expectRaw(t, r, "*2\r\n$1\r\nq\r\n$1\r\nw\r\n:1\r\n")
+
+	// Disconnect while blocked, after sending another command.
+	io.WriteString(conn, "*3\r\n$5\r\nBLPOP\r\n$1\r\nq\r\n$1\r\n0\r\n")
+	waitForState(t, srv.Client(1), StateBlocked)
+	io.WriteString(conn, "*3\r\n$5\r\nLPUSH\r\n$1\r\np\r\n$1\r\ny\r\n")
+	time.Sleep(10 * time.Millisecond)
+	conn.Close()
+	select {
+	case err := <-store.exited:
+		if err != ErrClientClosed {
+			t.Errorf("Wait() = %v; want %v", err, ErrClientClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked command was not released on disconnect")
+	}
+}
+
+func waitForState(t *testing.T, c *Client, state ClientState) {
+	t.Helper()
+	for i := 0; i < 500; i++ {
+		if c.State() == state {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("client state = %v; want %v", c.State(), state)
+}
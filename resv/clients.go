@@ -0,0 +1,491 @@
+package resv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nilium/fred"
+)
+
+// ClientState describes what a client connection is currently doing.
+type ClientState string
+
+const (
+	// StateIdle is the state of a client waiting for its next command.
+	StateIdle ClientState = "idle"
+	// StateReading is the state of a client whose command is being read.
+	StateReading ClientState = "read"
+	// StateExecuting is the state of a client whose command is being handled.
+	StateExecuting ClientState = "exec"
+	// StateBlocked is the state of a client waiting in Blocked.Wait.
+	StateBlocked ClientState = "blocked"
+)
+
+// Client is a connection to a Server. A Client is safe for concurrent use.
+type Client struct {
+	id      uint64
+	addr    net.Addr
+	conn    net.Conn
+	created time.Time
+
+	// r is the connection's reader. It is only used by the connection's goroutine, or while that goroutine is blocked
+	// in Blocked.Wait.
+	r *scanner
+
+	killed   chan struct{}
+	killOnce sync.Once
+
+	mu      sync.Mutex
+	name    string
+	active  time.Time
+	lastCmd string
+	state   ClientState
+	proto   int
+	values  map[interface{}]interface{}
+	blocked *Blocked
+}
+
+func newClient(id uint64, conn net.Conn) *Client {
+	now := time.Now()
+	return &Client{
+		id:      id,
+		addr:    conn.RemoteAddr(),
+		conn:    conn,
+		created: now,
+		killed:  make(chan struct{}),
+		active:  now,
+		state:   StateIdle,
+		proto:   2,
+	}
+}
+
+// ID returns the client's unique ID. IDs are assigned in increasing order, starting at 1.
+func (c *Client) ID() uint64 {
+	return c.id
+}
+
+// Addr returns the client's remote address.
+func (c *Client) Addr() net.Addr {
+	return c.addr
+}
+
+// Name returns the name set by CLIENT SETNAME or SetName.
+func (c *Client) Name() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.name
+}
+
+// SetName sets the client's name.
+func (c *Client) SetName(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.name = name
+}
+
+// Age returns the time since the client connected.
+func (c *Client) Age() time.Duration {
+	return time.Since(c.created)
+}
+
+// Idle returns the time since the client last sent a command.
+func (c *Client) Idle() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Since(c.active)
+}
+
+// LastCommand returns the upper-cased name of the client's last command.
+func (c *Client) LastCommand() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastCmd
+}
+
+// State returns the client's current state.
+func (c *Client) State() ClientState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// Value returns the value associated with key on the client, or nil if there is none. Values allow handlers to keep
+// per-connection state.
+func (c *Client) Value(key interface{}) interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+// SetValue associates value with key on the client. If value is nil, the key is removed.
+func (c *Client) SetValue(key, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if value == nil {
+		delete(c.values, key)
+		return
+	}
+	if c.values == nil {
+		c.values = make(map[interface{}]interface{})
+	}
+	c.values[key] = value
+}
+
+// Protocol returns the RESP protocol version negotiated by the client: 2 or 3.
+func (c *Client) Protocol() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.proto
+}
+
+func (c *Client) setProtocol(proto int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.proto = proto
+}
+
+func (c *Client) setState(state ClientState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state = state
+	if state == StateReading {
+		c.active = time.Now()
+	}
+}
+
+func (c *Client) setCommand(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastCmd = name
+	c.state = StateExecuting
+}
+
+// Kill closes the client's connection. If the client is executing a command, the connection is closed once its reply
+// is written.
+func (c *Client) Kill() {
+	c.killOnce.Do(func() {
+		close(c.killed)
+		// Interrupt any idle read.
+		c.conn.SetReadDeadline(time.Now())
+	})
+}
+
+// Unblock wakes the client if it is blocked in Blocked.Wait, causing Wait to return ErrUnblocked if withError is true
+// and ErrBlockTimeout otherwise. It returns true if the client was blocked.
+func (c *Client) Unblock(withError bool) bool {
+	c.mu.Lock()
+	b := c.blocked
+	c.mu.Unlock()
+
+	if b == nil {
+		return false
+	}
+
+	select {
+	case b.unblock <- withError:
+	default:
+	}
+	return true
+}
+
+// block marks the client as blocked on b until stop is called. The returned channel is closed if the client
+// disconnects or is killed before then.
+func (c *Client) block(b *Blocked) (closed <-chan struct{}, stop func()) {
+	c.mu.Lock()
+	c.state = StateBlocked
+	c.blocked = b
+	c.mu.Unlock()
+
+	gone := make(chan struct{})
+	var goneOnce sync.Once
+	markGone := func() { goneOnce.Do(func() { close(gone) }) }
+
+	halt := make(chan struct{})
+	killWatched := make(chan struct{})
+	go func() {
+		defer close(killWatched)
+		select {
+		case <-c.killed:
+			markGone()
+		case <-halt:
+		}
+	}()
+
+	// Read from the connection to notice if the client disconnects. If the client sends more commands instead, they're
+	// buffered for after the block, up to maxReadAhead bytes. A client that sends more than that while blocked is no
+	// longer watched, so its disconnecting goes unnoticed until the block ends.
+	readDone := make(chan struct{})
+	if c.r == nil {
+		close(readDone)
+	} else {
+		c.conn.SetReadDeadline(time.Time{})
+		go func() {
+			defer close(readDone)
+			for {
+				err := c.r.readAhead()
+				if err == io.ErrShortBuffer {
+					return
+				} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					return
+				} else if err != nil {
+					markGone()
+					return
+				}
+			}
+		}()
+	}
+
+	return gone, func() {
+		close(halt)
+		c.conn.SetReadDeadline(time.Now())
+		<-readDone
+		<-killWatched
+
+		c.mu.Lock()
+		c.state = StateExecuting
+		c.blocked = nil
+		c.mu.Unlock()
+	}
+}
+
+// Killed returns a channel that is closed when the client is killed or its connection is closed.
+func (c *Client) Killed() <-chan struct{} {
+	return c.killed
+}
+
+func (c *Client) isKilled() bool {
+	select {
+	case <-c.killed:
+		return true
+	default:
+		return false
+	}
+}
+
+// validClientName returns true if name contains only printable, non-space ASCII characters.
+func validClientName(name string) bool {
+	return strings.IndexFunc(name, func(r rune) bool { return r <= ' ' || r > '~' }) == -1
+}
+
+// String returns the client formatted as a line of CLIENT LIST output, without a trailing newline.
+func (c *Client) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	return fmt.Sprintf("id=%d addr=%v name=%s age=%d idle=%d state=%s cmd=%s resp=%d",
+		c.id, c.addr, c.name,
+		int64(now.Sub(c.created)/time.Second),
+		int64(now.Sub(c.active)/time.Second),
+		c.state,
+		strings.ToLower(c.lastCmd),
+		c.proto,
+	)
+}
+
+// ClientOf returns the Client that w writes to. If w was not created by a Server, ClientOf returns nil. ResponseWriters
+// that wrap another ResponseWriter may implement an Unwrap() ResponseWriter method to allow ClientOf to find the
+// Client of the wrapped ResponseWriter.
+func ClientOf(w ResponseWriter) *Client {
+	for w != nil {
+		switch cw := w.(type) {
+		case *bufferResponder:
+			return cw.client
+		case interface{ Unwrap() ResponseWriter }:
+			w = cw.Unwrap()
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// Client registry
+
+type clientRegistry struct {
+	mu      sync.Mutex
+	lastID  uint64
+	clients map[uint64]*Client
+}
+
+func (r *clientRegistry) add(conn net.Conn) *Client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.clients == nil {
+		r.clients = make(map[uint64]*Client)
+	}
+
+	r.lastID++
+	c := newClient(r.lastID, conn)
+	r.clients[c.id] = c
+	return c
+}
+
+func (r *clientRegistry) remove(c *Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.clients, c.id)
+}
+
+func (r *clientRegistry) get(id uint64) *Client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.clients[id]
+}
+
+func (r *clientRegistry) list() []*Client {
+	r.mu.Lock()
+	clients := make([]*Client, 0, len(r.clients))
+	for _, c := range r.clients {
+		clients = append(clients, c)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(clients, func(i, j int) bool { return clients[i].id < clients[j].id })
+	return clients
+}
+
+// Clients returns the Server's connected clients, ordered by ID.
+func (s *Server) Clients() []*Client {
+	return s.clients.list()
+}
+
+// Client returns the connected client with the given ID. If there is no such client, it returns nil.
+func (s *Server) Client(id uint64) *Client {
+	return s.clients.get(id)
+}
+
+// CLIENT command
+
+var errNoSuchClient = fred.Error("ERR No such client")
+
+// ClientHandler returns a Handler that replies to CLIENT commands using the Server's clients. It supports the
+// following subcommands:
+//
+//	CLIENT ID
+//	CLIENT GETNAME
+//	CLIENT SETNAME name
+//	CLIENT LIST
+//	CLIENT KILL addr
+//	CLIENT KILL [ID id] [ADDR addr]
+//	CLIENT UNBLOCK id [TIMEOUT|ERROR]
+func (s *Server) ClientHandler() Handler {
+	return HandlerFunc(func(w ResponseWriter, r fred.Resp) error {
+		args, err := r.StrList()
+		if err != nil || len(args) < 2 {
+			return w.Write(fred.Error("ERR wrong number of arguments for 'client' command"))
+		}
+
+		self := ClientOf(w)
+		sub, args := strings.ToUpper(args[1]), args[2:]
+		switch {
+		case sub == "ID" && len(args) == 0:
+			if self == nil {
+				return w.Write(errNoSuchClient)
+			}
+			return w.Write(self.ID())
+
+		case sub == "GETNAME" && len(args) == 0:
+			if self == nil {
+				return w.Write(errNoSuchClient)
+			}
+			if name := self.Name(); name != "" {
+				return w.Write(name)
+			}
+			return w.Write(nil)
+
+		case sub == "SETNAME" && len(args) == 1:
+			if self == nil {
+				return w.Write(errNoSuchClient)
+			}
+			if !validClientName(args[0]) {
+				return w.Write(fred.Error("ERR Client names cannot contain spaces, newlines or special characters."))
+			}
+			self.SetName(args[0])
+			return w.Write("OK")
+
+		case sub == "LIST" && len(args) == 0:
+			var buf bytes.Buffer
+			for _, c := range s.Clients() {
+				buf.WriteString(c.String())
+				buf.WriteByte('\n')
+			}
+			return w.Write(buf.Bytes())
+
+		case sub == "KILL" && len(args) == 1:
+			for _, c := range s.Clients() {
+				if c.Addr().String() == args[0] {
+					c.Kill()
+					return w.Write("OK")
+				}
+			}
+			return w.Write(errNoSuchClient)
+
+		case sub == "KILL" && len(args) > 0 && len(args)%2 == 0:
+			return s.killFilter(w, args)
+
+		case sub == "UNBLOCK" && (len(args) == 1 || len(args) == 2):
+			id, err := strconv.ParseUint(args[0], 10, 64)
+			if err != nil {
+				return w.Write(fred.Error("ERR value is not an integer or out of range"))
+			}
+
+			withErr := false
+			if len(args) == 2 {
+				switch strings.ToUpper(args[1]) {
+				case "TIMEOUT":
+				case "ERROR":
+					withErr = true
+				default:
+					return w.Write(fred.Error("ERR CLIENT UNBLOCK reason should be TIMEOUT or ERROR"))
+				}
+			}
+
+			if c := s.Client(id); c != nil && c.Unblock(withErr) {
+				return w.Write(1)
+			}
+			return w.Write(0)
+		}
+
+		return w.Write(fred.Error(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'", sub)))
+	})
+}
+
+// killFilter handles CLIENT KILL with filters. Clients matching all filters are killed.
+func (s *Server) killFilter(w ResponseWriter, args []string) error {
+	var (
+		id   uint64
+		addr string
+	)
+
+	for i := 0; i < len(args); i += 2 {
+		switch val := args[i+1]; strings.ToUpper(args[i]) {
+		case "ID":
+			n, err := strconv.ParseUint(val, 10, 64)
+			if err != nil || n == 0 {
+				return w.Write(fred.Error("ERR client-id should be greater than 0"))
+			}
+			id = n
+		case "ADDR":
+			addr = val
+		default:
+			return w.Write(fred.Error("ERR syntax error"))
+		}
+	}
+
+	killed := 0
+	for _, c := range s.Clients() {
+		if (id != 0 && c.ID() != id) || (addr != "" && c.Addr().String() != addr) {
+			continue
+		}
+		c.Kill()
+		killed++
+	}
+	return w.Write(killed)
+}
@@ -0,0 +1,82 @@
+package resv
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/nilium/fred"
+)
+
+func TestClientCommands(t *testing.T) {
+	var srv *Server
+	srv = NewServer(HandlerFunc(func(w ResponseWriter, r fred.Resp) error {
+		if CommandName(r) == "CLIENT" {
+			return srv.ClientHandler().ServeRESP(w, r)
+		}
+		return w.Write("OK")
+	}))
+	addr := startServer(t, srv)
+
+	conn1, r1 := dialServer(t, addr)
+	conn2, r2 := dialServer(t, addr)
+
+	var id1, id2 int64
+	io.WriteString(conn1, "*2\r\n$6\r\nCLIENT\r\n$2\r\nID\r\n")
+	io.WriteString(conn2, "*2\r\n$6\r\nclient\r\n$2\r\nid\r\n")
+	if err := fred.Scan(r1, &id1); err != nil {
+		t.Fatal(err)
+	}
+	if err := fred.Scan(r2, &id2); err != nil {
+		t.Fatal(err)
+	}
+	if id1 == id2 || id1 <= 0 || id2 <= 0 {
+		t.Fatalf("bad client IDs: %d, %d", id1, id2)
+	}
+
+	var reply, name string
+	io.WriteString(conn1, "*3\r\n$6\r\nCLIENT\r\n$7\r\nSETNAME\r\n$5\r\nfirst\r\n*2\r\n$6\r\nCLIENT\r\n$7\r\nGETNAME\r\n")
+	if err := fred.Scan(r1, &reply, &name); err != nil {
+		t.Fatal(err)
+	}
+	if name != "first" {
+		t.Errorf("GETNAME = %q; want %q", name, "first")
+	}
+
+	io.WriteString(conn1, "*3\r\n$6\r\nCLIENT\r\n$7\r\nSETNAME\r\n$3\r\na b\r\n")
+	if resp := fred.Read(r1); !resp.IsType(fred.Err) {
+		t.Errorf("expected error setting name with a space; got %#v", resp)
+	}
+
+	var list string
+	io.WriteString(conn2, "*2\r\n$6\r\nCLIENT\r\n$4\r\nLIST\r\n")
+	if err := fred.Scan(r2, &list); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%s", list)
+
+	lines := strings.Split(strings.TrimSuffix(list, "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("CLIENT LIST returned %d lines; want 2", len(lines))
+	}
+	if want := fmt.Sprintf("id=%d addr=%v name=first ", id1, conn1.LocalAddr()); !strings.HasPrefix(lines[0], want) {
+		t.Errorf("line 0 = %q; want prefix %q", lines[0], want)
+	}
+	if !strings.HasSuffix(lines[1], " state=exec cmd=client resp=2") {
+		t.Errorf("line 1 = %q; want executing client", lines[1])
+	}
+
+	var killed int64
+	io.WriteString(conn2, fmt.Sprintf("*4\r\n$6\r\nCLIENT\r\n$4\r\nKILL\r\n$2\r\nID\r\n$%d\r\n%d\r\n", len(fmt.Sprint(id1)), id1))
+	if err := fred.Scan(r2, &killed); err != nil {
+		t.Fatal(err)
+	}
+	if killed != 1 {
+		t.Errorf("CLIENT KILL ID = %d; want 1", killed)
+	}
+
+	if resp := fred.Read(r1); resp.Err != io.ErrUnexpectedEOF {
+		t.Errorf("expected killed connection to be closed; got %#v", resp)
+	}
+}
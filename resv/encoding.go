@@ -1,11 +1,13 @@
 package resv
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"reflect"
 	"runtime"
 	"strconv"
@@ -29,19 +31,147 @@ func MarshalRESP(v interface{}) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// SimpleString is a string encoded as a RESP simple string, such as OK or QUEUED, instead of a bulk string. It must not
+// contain CR or LF.
+type SimpleString string
+
+// rawRESP is an already-encoded RESP value. It is written as-is.
+type rawRESP []byte
+
+// Encoder writes RESP values to an output stream. Its output is buffered, so Flush must be called once values are
+// written. Once a write fails, the Encoder returns the error from every later call.
+//
+// An Encoder may be reused for many values. Commands and values of the basic types are encoded without allocating.
 type Encoder struct {
+	bw    *bufio.Writer
 	state encoderState
 }
 
+// NewEncoder returns an Encoder that writes to w. If w is a *bufio.Writer, the Encoder writes to it directly, and
+// flushing either flushes both.
 func NewEncoder(w io.Writer) *Encoder {
+	bw := bufio.NewWriter(w)
 	return &Encoder{
-		state: encoderState{w: w},
+		bw:    bw,
+		state: encoderState{w: bw},
+	}
+}
+
+// Encode writes v to the Encoder's writer as RESP2.
+func (e *Encoder) Encode(v interface{}) error {
+	return e.state.write(v)
+}
+
+// EncodeCommand writes a command as an array of bulk strings, the form servers accept requests in.
+func (e *Encoder) EncodeCommand(name string, args ...string) error {
+	s := &e.state
+	if s.err != nil {
+		return s.err
+	}
+	err := s.writeHeader('*', int64(len(args)+1))
+	if err == nil {
+		err = s.writeBulk(name)
+	}
+	for i := 0; i < len(args) && err == nil; i++ {
+		err = s.writeBulk(args[i])
+	}
+	if err != nil {
+		s.err = err
 	}
+	return err
+}
+
+// Flush writes any buffered values to the underlying writer.
+func (e *Encoder) Flush() error {
+	if e.state.err != nil {
+		return e.state.err
+	}
+	if err := e.bw.Flush(); err != nil {
+		e.state.err = err
+		return err
+	}
+	return nil
+}
+
+// Buffered returns the number of bytes written to the Encoder but not yet flushed.
+func (e *Encoder) Buffered() int {
+	return e.bw.Buffered()
+}
+
+// Err returns the error that stopped the Encoder, if any.
+func (e *Encoder) Err() error {
+	return e.state.err
 }
 
 type encoderState struct {
 	w   io.Writer
 	err error
+
+	// proto is the RESP protocol version to encode values as. If less than 3, values are encoded as RESP2.
+	proto int
+
+	// num holds a header line while it's written, and buf a formatted value.
+	num [24]byte
+	buf []byte
+}
+
+// writeHeader writes a line of prefix followed by n, such as the length of an aggregate or bulk string, or an integer.
+func (e *encoderState) writeHeader(prefix byte, n int64) error {
+	b := append(e.num[:0], prefix)
+	b = strconv.AppendInt(b, n, 10)
+	_, err := e.w.Write(append(b, '\r', '\n'))
+	return err
+}
+
+// writeUint writes n as an integer.
+func (e *encoderState) writeUint(n uint64) error {
+	b := append(e.num[:0], ':')
+	b = strconv.AppendUint(b, n, 10)
+	_, err := e.w.Write(append(b, '\r', '\n'))
+	return err
+}
+
+// writeLine writes a line of prefix followed by s, such as a simple string or error.
+func (e *encoderState) writeLine(prefix byte, s string) error {
+	if strings.IndexAny(s, "\r\n") != -1 {
+		return fred.ErrMalformedSimpleString
+	}
+	e.buf = append(append(append(e.buf[:0], prefix), s...), '\r', '\n')
+	_, err := e.w.Write(e.buf)
+	return err
+}
+
+func (e *encoderState) writeBulk(s string) error {
+	if err := e.writeHeader('$', int64(len(s))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(e.w, s); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.w, "\r\n")
+	return err
+}
+
+func (e *encoderState) writeBulkBytes(p []byte) error {
+	if err := e.writeHeader('$', int64(len(p))); err != nil {
+		return err
+	}
+	if _, err := e.w.Write(p); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.w, "\r\n")
+	return err
+}
+
+// writeFloat writes f as a RESP3 double, or as a bulk string in RESP2.
+func (e *encoderState) writeFloat(f float64) error {
+	if e.proto >= 3 {
+		e.buf = append(appendDouble(append(e.buf[:0], ','), f), '\r', '\n')
+		_, err := e.w.Write(e.buf)
+		return err
+	}
+	e.buf = appendDouble(e.buf[:0], f)
+	return e.writeBulkBytes(e.buf)
 }
 
 func (e *encoderState) write(v interface{}) (err error) {
@@ -81,234 +211,185 @@ func (e *encoderState) write(v interface{}) (err error) {
 	}
 
 	if v == nil {
-		_, err = io.WriteString(e.w, "$-1\r\n")
+		if e.proto >= 3 {
+			_, err = io.WriteString(e.w, "_\r\n")
+		} else {
+			_, err = io.WriteString(e.w, "$-1\r\n")
+		}
 		return err
 	}
 
 	switch v := v.(type) {
-	case error:
-		msg := v.Error()
-		if strings.IndexAny(msg, "\r\n") != -1 {
-			return fred.ErrMalformedSimpleString
-		}
-		_, err = io.WriteString(e.w, "-"+msg+"\r\n")
+	case rawRESP:
+		_, err = e.w.Write(v)
 		return err
+	case SimpleString:
+		return e.writeLine('+', string(v))
+	case error:
+		return e.writeLine('-', v.Error())
 	case string:
-		_, err = fmt.Fprintf(e.w, "$%d\r\n%s\r\n", len(v), v)
-		return err
+		return e.writeBulk(v)
 	case []string:
-		_, err = fmt.Fprintf(e.w, "*%d", len(v))
-		if err != nil {
-			return err
-		}
-		for _, s := range v {
-			if err = e.write(s); err != nil {
-				return err
-			}
+		err = e.writeHeader('*', int64(len(v)))
+		for i := 0; i < len(v) && err == nil; i++ {
+			err = e.writeBulk(v[i])
 		}
 		return err
 
 	case []uint8:
-		_, err = fmt.Fprintf(e.w, "$%d\r\n%s\r\n", len(v), v)
-		return err
+		return e.writeBulkBytes(v)
 	case [][]uint8:
-		_, err = fmt.Fprintf(e.w, "*%d\r\n", len(v))
-		if err != nil {
-			return err
-		}
-		for _, s := range v {
-			if err = e.write(s); err != nil {
-				return err
-			}
+		err = e.writeHeader('*', int64(len(v)))
+		for i := 0; i < len(v) && err == nil; i++ {
+			err = e.writeBulkBytes(v[i])
 		}
 		return err
 
 	case int:
-		if _, err = fmt.Fprintf(e.w, ":%d\r\n", v); err != nil {
-			return err
-		}
-		return err
+		return e.writeHeader(':', int64(v))
 	case []int:
-		_, err = fmt.Fprintf(e.w, "*%d\r\n", len(v))
-		if err != nil {
-			return err
-		}
-		for _, i := range v {
-			if err = e.write(i); err != nil {
-				return err
-			}
+		err = e.writeHeader('*', int64(len(v)))
+		for i := 0; i < len(v) && err == nil; i++ {
+			err = e.writeHeader(':', int64(v[i]))
 		}
 		return err
 
 	case int64:
-		if _, err = fmt.Fprintf(e.w, ":%d\r\n", v); err != nil {
-			return err
-		}
-		return err
+		return e.writeHeader(':', v)
 	case []int64:
-		_, err = fmt.Fprintf(e.w, "*%d\r\n", len(v))
-		if err != nil {
-			return err
-		}
-		for _, i := range v {
-			if err = e.write(i); err != nil {
-				return err
-			}
+		err = e.writeHeader('*', int64(len(v)))
+		for i := 0; i < len(v) && err == nil; i++ {
+			err = e.writeHeader(':', v[i])
 		}
 		return err
 
 	case int32:
-		if _, err = fmt.Fprintf(e.w, ":%d\r\n", v); err != nil {
-			return err
-		}
-		return err
+		return e.writeHeader(':', int64(v))
 	case []int32:
-		_, err = fmt.Fprintf(e.w, "*%d\r\n", len(v))
-		if err != nil {
-			return err
-		}
-		for _, i := range v {
-			if err = e.write(i); err != nil {
-				return err
-			}
+		err = e.writeHeader('*', int64(len(v)))
+		for i := 0; i < len(v) && err == nil; i++ {
+			err = e.writeHeader(':', int64(v[i]))
 		}
 		return err
 
 	case int16:
-		if _, err = fmt.Fprintf(e.w, ":%d\r\n", v); err != nil {
-			return err
-		}
-		return err
+		return e.writeHeader(':', int64(v))
 	case []int16:
-		_, err = fmt.Fprintf(e.w, "*%d\r\n", len(v))
-		if err != nil {
-			return err
-		}
-		for _, i := range v {
-			if err = e.write(i); err != nil {
-				return err
-			}
+		err = e.writeHeader('*', int64(len(v)))
+		for i := 0; i < len(v) && err == nil; i++ {
+			err = e.writeHeader(':', int64(v[i]))
 		}
 		return err
 
 	case int8:
-		if _, err = fmt.Fprintf(e.w, ":%d\r\n", v); err != nil {
-			return err
-		}
-		return err
+		return e.writeHeader(':', int64(v))
 	case []int8:
-		_, err = fmt.Fprintf(e.w, "*%d\r\n", len(v))
-		if err != nil {
-			return err
-		}
-		for _, i := range v {
-			if err = e.write(i); err != nil {
-				return err
-			}
+		err = e.writeHeader('*', int64(len(v)))
+		for i := 0; i < len(v) && err == nil; i++ {
+			err = e.writeHeader(':', int64(v[i]))
 		}
 		return err
 
 	case uint:
-		if _, err = fmt.Fprintf(e.w, ":%d\r\n", v); err != nil {
-			return err
-		}
-		return err
+		return e.writeUint(uint64(v))
 	case []uint:
-		_, err = fmt.Fprintf(e.w, "*%d\r\n", len(v))
-		if err != nil {
-			return err
-		}
-		for _, i := range v {
-			if err = e.write(i); err != nil {
-				return err
-			}
+		err = e.writeHeader('*', int64(len(v)))
+		for i := 0; i < len(v) && err == nil; i++ {
+			err = e.writeUint(uint64(v[i]))
 		}
 		return err
 
 	case uint64:
-		if _, err = fmt.Fprintf(e.w, ":%d\r\n", v); err != nil {
-			return err
-		}
-		return err
+		return e.writeUint(v)
 	case []uint64:
-		_, err = fmt.Fprintf(e.w, "*%d\r\n", len(v))
-		if err != nil {
-			return err
-		}
-		for _, i := range v {
-			if err = e.write(i); err != nil {
-				return err
-			}
+		err = e.writeHeader('*', int64(len(v)))
+		for i := 0; i < len(v) && err == nil; i++ {
+			err = e.writeUint(v[i])
 		}
 		return err
 
 	case uint32:
-		if _, err = fmt.Fprintf(e.w, ":%d\r\n", v); err != nil {
-			return err
+		return e.writeHeader(':', int64(v))
+	case []uint32:
+		err = e.writeHeader('*', int64(len(v)))
+		for i := 0; i < len(v) && err == nil; i++ {
+			err = e.writeHeader(':', int64(v[i]))
 		}
 		return err
-	case []uint32:
-		_, err = fmt.Fprintf(e.w, "*%d\r\n", len(v))
-		if err != nil {
-			return err
+
+	case uint16:
+		return e.writeHeader(':', int64(v))
+	case []uint16:
+		err = e.writeHeader('*', int64(len(v)))
+		for i := 0; i < len(v) && err == nil; i++ {
+			err = e.writeHeader(':', int64(v[i]))
 		}
-		for _, i := range v {
-			if err = e.write(i); err != nil {
-				return err
+		return err
+
+	case bool:
+		if e.proto >= 3 {
+			if v {
+				_, err = io.WriteString(e.w, "#t\r\n")
+			} else {
+				_, err = io.WriteString(e.w, "#f\r\n")
 			}
+		} else if v {
+			_, err = io.WriteString(e.w, ":1\r\n")
+		} else {
+			_, err = io.WriteString(e.w, ":0\r\n")
 		}
 		return err
 
-	case uint16:
-		if _, err = fmt.Fprintf(e.w, ":%d\r\n", v); err != nil {
-			return err
+	case Map:
+		if e.proto >= 3 {
+			err = e.writeHeader('%', int64(len(v)))
+		} else {
+			err = e.writeHeader('*', int64(len(v)*2))
 		}
-		return err
-	case []uint16:
-		_, err = fmt.Fprintf(e.w, "*%d\r\n", len(v))
 		if err != nil {
 			return err
 		}
-		for _, i := range v {
-			if err = e.write(i); err != nil {
+		for _, kv := range v {
+			if err = e.write(kv.Key); err != nil {
+				return err
+			}
+			if err = e.write(kv.Value); err != nil {
 				return err
 			}
 		}
 		return err
 
-	case float64:
-		fls := strings.TrimRight(strconv.FormatFloat(v, 'f', 16, 64), "0.")
-		if _, err = fmt.Fprintf(e.w, "$%d\r\n%s\r\n", len(fls), fls); err != nil {
-			return err
+	case Set:
+		if e.proto >= 3 {
+			err = e.writeHeader('~', int64(len(v)))
+		} else {
+			err = e.writeHeader('*', int64(len(v)))
 		}
-		return err
-	case []float64:
-		_, err = fmt.Fprintf(e.w, "*%d\r\n", len(v))
 		if err != nil {
 			return err
 		}
-		for _, f := range v {
-			if err = e.write(f); err != nil {
+		for _, elem := range v {
+			if err = e.write(elem); err != nil {
 				return err
 			}
 		}
 		return err
 
-	case float32:
-		fls := strings.TrimRight(strconv.FormatFloat(float64(v), 'f', 16, 64), "0.")
-		if _, err = fmt.Fprintf(e.w, "$%d\r\n%s\r\n", len(fls), fls); err != nil {
-			return err
+	case float64:
+		return e.writeFloat(v)
+	case []float64:
+		err = e.writeHeader('*', int64(len(v)))
+		for i := 0; i < len(v) && err == nil; i++ {
+			err = e.writeFloat(v[i])
 		}
 		return err
+
+	case float32:
+		return e.writeFloat(float64(v))
 	case []float32:
-		_, err = fmt.Fprintf(e.w, "*%d\r\n", len(v))
-		if err != nil {
-			return err
-		}
-		for _, f := range v {
-			if err = e.write(f); err != nil {
-				return err
-			}
+		err = e.writeHeader('*', int64(len(v)))
+		for i := 0; i < len(v) && err == nil; i++ {
+			err = e.writeFloat(float64(v[i]))
 		}
 		return err
 
@@ -320,22 +401,23 @@ func (e *encoderState) write(v interface{}) (err error) {
 		return e.write(value)
 
 	case time.Duration:
-		ds := v.String()
-		_, err = fmt.Fprintf(e.w, "$%d\r\n%s\r\n", len(ds), ds)
-		return err
-
-	case fmt.Stringer:
-		s := v.String()
-		_, err = fmt.Fprintf(e.w, "$%d\r\n%s\r\n", len(s), s)
-		return err
+		return e.writeBulk(v.String())
 
 	case time.Time:
 		sec := v.Unix()
 		nsec := v.UnixNano() - sec*int64(time.Second)
-		fmt.Fprintf(e.w, "*2\r\n:%d\r\n:%d\r\n", sec, nsec)
+		if err = e.writeHeader('*', 2); err == nil {
+			if err = e.writeHeader(':', sec); err == nil {
+				err = e.writeHeader(':', nsec)
+			}
+		}
+		return err
+
+	case fmt.Stringer:
+		return e.writeBulk(v.String())
 
 	case []interface{}:
-		_, err = fmt.Fprintf(e.w, "*%d\r\n", len(v))
+		err = e.writeHeader('*', int64(len(v)))
 		if err != nil {
 			return err
 		}
@@ -364,6 +446,18 @@ func (e *encoderState) write(v interface{}) (err error) {
 
 	case reflect.Map:
 		keys := rv.MapKeys()
+		if e.proto >= 3 {
+			m := make(Map, 0, len(keys))
+			for _, key := range keys {
+				elem := rv.MapIndex(key)
+				if !key.CanInterface() || !elem.CanInterface() {
+					return fmt.Errorf("cannot marshal map %s", rv.Type())
+				}
+				m = append(m, MapEntry{key.Interface(), elem.Interface()})
+			}
+			return e.write(m)
+		}
+
 		vals := make([]interface{}, 0, len(keys))
 		for _, key := range keys {
 			if !key.CanInterface() {
@@ -382,8 +476,21 @@ func (e *encoderState) write(v interface{}) (err error) {
 		e.write(vals)
 
 	default:
-		fmt.Errorf("cannot marshal %T to RESP type")
+		err = fmt.Errorf("cannot marshal %T to RESP type", v)
 	}
 
 	return err
 }
+
+// appendDouble appends f, formatted as a RESP3 double, to b.
+func appendDouble(b []byte, f float64) []byte {
+	switch {
+	case math.IsInf(f, 1):
+		return append(b, "inf"...)
+	case math.IsInf(f, -1):
+		return append(b, "-inf"...)
+	case math.IsNaN(f):
+		return append(b, "nan"...)
+	}
+	return strconv.AppendFloat(b, f, 'g', -1, 64)
+}
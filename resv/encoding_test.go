@@ -0,0 +1,91 @@
+package resv
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.EncodeCommand("SET", "key", "value")
+	enc.Encode(int64(-42))
+	enc.Encode([]string{"a", "b"})
+	enc.Encode(SimpleString("OK"))
+	enc.Encode(nil)
+	enc.Encode(10.0)
+	enc.Encode(0.0)
+	enc.Encode(-0.5)
+	enc.Encode(time.Unix(5, 7))
+
+	if buf.Len() != 0 {
+		t.Errorf("%d bytes written before Flush; want 0", buf.Len())
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n" +
+		":-42\r\n" +
+		"*2\r\n$1\r\na\r\n$1\r\nb\r\n" +
+		"+OK\r\n" +
+		"$-1\r\n" +
+		"$2\r\n10\r\n" +
+		"$1\r\n0\r\n" +
+		"$4\r\n-0.5\r\n" +
+		"*2\r\n:5\r\n:7\r\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Encoder wrote %q; want %q", got, want)
+	}
+}
+
+type failWriter struct{ err error }
+
+func (w failWriter) Write(p []byte) (int, error) {
+	return 0, w.err
+}
+
+func TestEncoderErrors(t *testing.T) {
+	// A value that can't be encoded stops the Encoder.
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	if err := enc.Encode(SimpleString("bad\r\n")); err == nil {
+		t.Fatal("Encode() of a malformed simple string succeeded")
+	}
+	if err := enc.EncodeCommand("PING"); err == nil || err != enc.Err() {
+		t.Errorf("EncodeCommand() after an error = %v; want %v", err, enc.Err())
+	}
+
+	// So does a failed write.
+	werr := errors.New("write failed")
+	enc = NewEncoder(failWriter{werr})
+	if err := enc.EncodeCommand("PING"); err != nil {
+		t.Errorf("EncodeCommand() = %v; want nil until flushed", err)
+	}
+	if err := enc.Flush(); err != werr {
+		t.Errorf("Flush() = %v; want %v", err, werr)
+	}
+	if err := enc.Encode("x"); err != werr {
+		t.Errorf("Encode() after a failed Flush = %v; want %v", err, werr)
+	}
+}
+
+func TestEncoderAllocs(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	args := []string{"key", "value", "EX", "60"}
+	// Boxing a slice in an interface allocates, so it's done once.
+	var values, ints interface{} = []string{"a", "b", "c"}, []int64{1, 1 << 40}
+	allocs := testing.AllocsPerRun(100, func() {
+		buf.Reset()
+		enc.EncodeCommand("SET", args...)
+		enc.Encode(values)
+		enc.Encode(ints)
+		enc.Encode(3.25)
+		enc.Flush()
+	})
+	if allocs != 0 {
+		t.Errorf("Encoder allocated %v times per run; want 0", allocs)
+	}
+}
@@ -0,0 +1,83 @@
+package resv_test
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/client"
+	"github.com/nilium/fred/resv"
+	"github.com/nilium/fred/resv/resvtest"
+)
+
+// Span is a stand-in for a tracing library's span, such as OpenTelemetry's trace.Span.
+type Span struct {
+	name  string
+	attrs []string
+}
+
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.attrs = append(s.attrs, fmt.Sprintf("%s=%v", key, value))
+}
+
+func (s *Span) End() {
+	fmt.Println(s.name, strings.Join(s.attrs, " "))
+}
+
+// Tracer starts spans.
+type Tracer struct {
+	Service string
+}
+
+func (t Tracer) Start(name string) *Span {
+	return &Span{name: t.Service + " " + name}
+}
+
+// TracingHook adapts a Tracer to resv.Hook. It starts a span in BeforeCommand, keeps it in the CommandInfo's Value,
+// and ends it in AfterCommand. A real adapter would also record info.Duration or use its own clock.
+type TracingHook struct {
+	Tracer Tracer
+}
+
+func (h TracingHook) BeforeCommand(info *resv.CommandInfo) {
+	span := h.Tracer.Start(info.Name)
+	span.SetAttribute("db.statement", strings.Join(append([]string{info.Name}, info.Args...), " "))
+	info.Value = span
+}
+
+func (h TracingHook) AfterCommand(info *resv.CommandInfo) {
+	span := info.Value.(*Span)
+	span.SetAttribute("reply", info.ReplyType)
+	if info.Err != nil {
+		span.SetAttribute("error", info.Err)
+	}
+	span.End()
+}
+
+// This example traces commands on both a server and a client with the same Hook.
+func ExampleHook() {
+	srv := resvtest.NewUnstartedServer(resv.HandlerFunc(func(w resv.ResponseWriter, r fred.Resp) error {
+		if resv.CommandName(r) == "GET" {
+			return w.Write("value")
+		}
+		return w.Write(fred.Error("ERR unknown command"))
+	}))
+	srv.Server.Hook = TracingHook{Tracer{"server"}}
+	srv.Start()
+	defer srv.Close()
+
+	conn, err := client.Dial("tcp", srv.Addr)
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+	conn.Hook = TracingHook{Tracer{"client"}}
+
+	conn.Do("GET", "key")
+	conn.Do("DEL", "key")
+	// Output:
+	// server GET db.statement=GET key reply=BulkStr
+	// client GET db.statement=GET key reply=BulkStr
+	// server DEL db.statement=DEL key reply=Err error=ERR unknown command
+	// client DEL db.statement=DEL key reply=Err error=ERR unknown command
+}
@@ -0,0 +1,84 @@
+package resv
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/nilium/fred"
+)
+
+// CommandInfo describes a command passed to a Hook.
+type CommandInfo struct {
+	// Name is the upper-cased command name and Args are its arguments, not including the name.
+	Name string
+	Args []string
+	// Start is when the command was received by a server or sent by a client.
+	Start time.Time
+
+	// Duration, ReplyType, and Err are set before AfterCommand is called. ReplyType is Invalid if there was no reply.
+	// Err is the error reply, if any, or the error that prevented a reply.
+	Duration  time.Duration
+	ReplyType fred.Type
+	Err       error
+
+	// Value is for the Hook's use, such as to hold a span started by BeforeCommand so that AfterCommand can end it.
+	Value interface{}
+}
+
+// Hook is called around each command handled by a Server or sent by a client.Conn, such as to trace commands without
+// depending on a tracing library. BeforeCommand and AfterCommand are passed the same *CommandInfo for a command, and
+// may be called concurrently for different commands.
+type Hook interface {
+	BeforeCommand(*CommandInfo)
+	AfterCommand(*CommandInfo)
+}
+
+// newCommandInfo returns the CommandInfo for a command received by a server.
+func newCommandInfo(r fred.Resp, start time.Time) *CommandInfo {
+	info := &CommandInfo{Name: CommandName(r), Start: start}
+	if args, err := r.StrList(); err == nil && len(args) > 0 {
+		info.Args = args[1:]
+	}
+	return info
+}
+
+// replyTypes maps the first byte of a reply to its type.
+var replyTypes = map[byte]fred.Type{
+	'+': fred.SimpleStr,
+	'$': fred.BulkStr,
+	'=': fred.BulkStr,
+	'-': fred.Err,
+	'!': fred.Err,
+	':': fred.Int,
+	'*': fred.Array,
+	'_': fred.Nil,
+	',': fred.Double,
+	'#': fred.Bool,
+	'(': fred.BigNum,
+	'%': fred.Map,
+	'~': fred.Set,
+	'>': fred.Push,
+}
+
+// finish sets the result of a command from the reply in msg and the error returned by the Handler. Only the start of
+// the reply is read, unless it's an error.
+func (info *CommandInfo) finish(msg []byte, err error) {
+	info.Duration = time.Since(info.Start)
+	info.ReplyType, info.Err = fred.Invalid, err
+	if len(msg) == 0 {
+		return
+	}
+
+	typ, ok := replyTypes[msg[0]]
+	switch {
+	case !ok:
+		return
+	case typ == fred.Err:
+		if reply := fred.Read(bytes.NewBuffer(msg)); info.Err == nil {
+			info.Err = reply.Err
+		}
+	case (msg[0] == '$' || msg[0] == '*') && bytes.HasPrefix(msg[1:], []byte("-1\r\n")):
+		typ = fred.Nil
+	}
+	info.ReplyType = typ
+}
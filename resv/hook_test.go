@@ -0,0 +1,77 @@
+package resv
+
+import (
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/nilium/fred"
+)
+
+// recordingHook records the CommandInfo passed to AfterCommand for each command.
+type recordingHook struct {
+	mu     sync.Mutex
+	before int
+	after  []CommandInfo
+}
+
+func (h *recordingHook) BeforeCommand(info *CommandInfo) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.before++
+	info.Value = h.before
+}
+
+func (h *recordingHook) AfterCommand(info *CommandInfo) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.after = append(h.after, *info)
+}
+
+func TestServerHook(t *testing.T) {
+	srv := NewServer(HandlerFunc(func(w ResponseWriter, r fred.Resp) error {
+		switch CommandName(r) {
+		case "GET":
+			return w.Write(NullArray(w))
+		case "PANIC":
+			panic("boom")
+		}
+		return w.Write(fred.Error("ERR unknown command"))
+	}))
+	srv.ErrorLog = NullLogger
+	hook := &recordingHook{}
+	srv.Hook = hook
+	addr := startServer(t, srv)
+
+	conn, r := dialServer(t, addr)
+	if _, err := io.WriteString(conn, "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n*1\r\n$4\r\nNOPE\r\n*1\r\n$5\r\nPANIC\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		fred.Read(r)
+	}
+
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+	if hook.before != 3 || len(hook.after) != 3 {
+		t.Fatalf("hook called %d times before and %d times after; want 3", hook.before, len(hook.after))
+	}
+	cases := []struct {
+		name  string
+		args  int
+		reply fred.Type
+		err   error
+	}{
+		{"GET", 1, fred.Nil, nil},
+		{"NOPE", 0, fred.Err, fred.Error("ERR unknown command")},
+		{"PANIC", 0, fred.Invalid, errHandlerPanic},
+	}
+	for i, c := range cases {
+		info := hook.after[i]
+		if info.Name != c.name || len(info.Args) != c.args || info.ReplyType != c.reply || info.Err != c.err ||
+			info.Value != i+1 || info.Duration <= 0 {
+			t.Errorf("AfterCommand(%+v); want %s with %d args, reply %v, and error %v", info, c.name, c.args, c.reply,
+				c.err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package memstore
+
+// command is an entry in the command table. Arity follows Redis's convention: it counts the command name, and a
+// negative arity is a minimum.
+type command struct {
+	arity int
+	fn    func(s *Store, cx *call) interface{}
+
+	// blocking, if set, is called instead of fn and writes its own reply. It is used by commands that may release the
+	// Store's lock while waiting.
+	blocking func(s *Store, cx *call) error
+}
+
+var commands = map[string]command{
+	// Generic
+	"PING":      {arity: -1, fn: cmdPing},
+	"ECHO":      {arity: 2, fn: cmdEcho},
+	"SELECT":    {arity: 2, fn: cmdSelect},
+	"DEL":       {arity: -2, fn: cmdDel},
+	"UNLINK":    {arity: -2, fn: cmdDel},
+	"EXISTS":    {arity: -2, fn: cmdExists},
+	"TYPE":      {arity: 2, fn: cmdType},
+	"EXPIRE":    {arity: 3, fn: cmdExpire},
+	"PEXPIRE":   {arity: 3, fn: cmdExpire},
+	"EXPIREAT":  {arity: 3, fn: cmdExpire},
+	"PEXPIREAT": {arity: 3, fn: cmdExpire},
+	"TTL":       {arity: 2, fn: cmdTTL},
+	"PTTL":      {arity: 2, fn: cmdTTL},
+	"PERSIST":   {arity: 2, fn: cmdPersist},
+	"KEYS":      {arity: 2, fn: cmdKeys},
+	"SCAN":      {arity: -2, fn: cmdScan},
+	"RENAME":    {arity: 3, fn: cmdRename},
+	"RENAMENX":  {arity: 3, fn: cmdRename},
+	"DBSIZE":    {arity: 1, fn: cmdDBSize},
+	"FLUSHDB":   {arity: -1, fn: cmdFlushDB},
+	"FLUSHALL":  {arity: -1, fn: cmdFlushAll},
+
+	// Strings
+	"GET":         {arity: 2, fn: cmdGet},
+	"GETDEL":      {arity: 2, fn: cmdGet},
+	"SET":         {arity: -3, fn: cmdSet},
+	"SETNX":       {arity: 3, fn: cmdSetNX},
+	"SETEX":       {arity: 4, fn: cmdSetEx},
+	"PSETEX":      {arity: 4, fn: cmdSetEx},
+	"GETSET":      {arity: 3, fn: cmdGetSet},
+	"MGET":        {arity: -2, fn: cmdMGet},
+	"MSET":        {arity: -3, fn: cmdMSet},
+	"MSETNX":      {arity: -3, fn: cmdMSet},
+	"INCR":        {arity: 2, fn: cmdIncr},
+	"DECR":        {arity: 2, fn: cmdIncr},
+	"INCRBY":      {arity: 3, fn: cmdIncr},
+	"DECRBY":      {arity: 3, fn: cmdIncr},
+	"INCRBYFLOAT": {arity: 3, fn: cmdIncrByFloat},
+	"APPEND":      {arity: 3, fn: cmdAppend},
+	"STRLEN":      {arity: 2, fn: cmdStrlen},
+
+	// Lists
+	"LPUSH":  {arity: -3, fn: cmdPush},
+	"RPUSH":  {arity: -3, fn: cmdPush},
+	"LPUSHX": {arity: -3, fn: cmdPush},
+	"RPUSHX": {arity: -3, fn: cmdPush},
+	"LPOP":   {arity: -2, fn: cmdPop},
+	"RPOP":   {arity: -2, fn: cmdPop},
+	"BLPOP":  {arity: -3, blocking: cmdBlockingPop},
+	"BRPOP":  {arity: -3, blocking: cmdBlockingPop},
+	"LLEN":   {arity: 2, fn: cmdLLen},
+	"LRANGE": {arity: 4, fn: cmdLRange},
+	"LINDEX": {arity: 3, fn: cmdLIndex},
+	"LSET":   {arity: 4, fn: cmdLSet},
+	"LREM":   {arity: 4, fn: cmdLRem},
+	"LTRIM":  {arity: 4, fn: cmdLTrim},
+
+	// Hashes
+	"HSET":    {arity: -4, fn: cmdHSet},
+	"HMSET":   {arity: -4, fn: cmdHSet},
+	"HSETNX":  {arity: 4, fn: cmdHSetNX},
+	"HGET":    {arity: 3, fn: cmdHGet},
+	"HMGET":   {arity: -3, fn: cmdHMGet},
+	"HGETALL": {arity: 2, fn: cmdHGetAll},
+	"HDEL":    {arity: -3, fn: cmdHDel},
+	"HEXISTS": {arity: 3, fn: cmdHExists},
+	"HLEN":    {arity: 2, fn: cmdHLen},
+	"HKEYS":   {arity: 2, fn: cmdHKeys},
+	"HVALS":   {arity: 2, fn: cmdHKeys},
+	"HINCRBY": {arity: 4, fn: cmdHIncrBy},
+
+	// Sets
+	"SADD":      {arity: -3, fn: cmdSAdd},
+	"SREM":      {arity: -3, fn: cmdSRem},
+	"SMEMBERS":  {arity: 2, fn: cmdSMembers},
+	"SISMEMBER": {arity: 3, fn: cmdSIsMember},
+	"SCARD":     {arity: 2, fn: cmdSCard},
+	"SINTER":    {arity: -2, fn: cmdSetOp},
+	"SUNION":    {arity: -2, fn: cmdSetOp},
+	"SDIFF":     {arity: -2, fn: cmdSetOp},
+
+	// Sorted sets
+	"ZADD":             {arity: -4, fn: cmdZAdd},
+	"ZINCRBY":          {arity: 4, fn: cmdZIncrBy},
+	"ZREM":             {arity: -3, fn: cmdZRem},
+	"ZSCORE":           {arity: 3, fn: cmdZScore},
+	"ZCARD":            {arity: 2, fn: cmdZCard},
+	"ZRANK":            {arity: 3, fn: cmdZRank},
+	"ZREVRANK":         {arity: 3, fn: cmdZRank},
+	"ZRANGE":           {arity: -4, fn: cmdZRange},
+	"ZREVRANGE":        {arity: -4, fn: cmdZRange},
+	"ZRANGEBYSCORE":    {arity: -4, fn: cmdZRangeByScore},
+	"ZREVRANGEBYSCORE": {arity: -4, fn: cmdZRangeByScore},
+	"ZCOUNT":           {arity: 4, fn: cmdZCount},
+}
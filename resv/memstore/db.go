@@ -0,0 +1,239 @@
+package memstore
+
+import (
+	"sort"
+	"time"
+)
+
+// Value types held by entries.
+type (
+	list []string
+	hash map[string]string
+	set  map[string]struct{}
+	zset map[string]float64
+)
+
+// entry is a single key's value.
+type entry struct {
+	value   interface{} // string, list, hash, set, or zset
+	expires time.Time   // zero if the key doesn't expire
+	version uint64      // Store.seq at the entry's last modification
+}
+
+func (e *entry) typeName() string {
+	switch e.value.(type) {
+	case string:
+		return "string"
+	case list:
+		return "list"
+	case hash:
+		return "hash"
+	case set:
+		return "set"
+	case zset:
+		return "zset"
+	}
+	return "none"
+}
+
+// empty returns true if e holds an empty aggregate. Redis deletes keys whose aggregates become empty.
+func (e *entry) empty() bool {
+	switch v := e.value.(type) {
+	case list:
+		return len(v) == 0
+	case hash:
+		return len(v) == 0
+	case set:
+		return len(v) == 0
+	case zset:
+		return len(v) == 0
+	}
+	return false
+}
+
+// db is a single numbered database of a Store.
+type db struct {
+	s     *Store
+	index int
+	keys  map[string]*entry
+}
+
+func newDB(s *Store, index int) *db {
+	return &db{s: s, index: index, keys: make(map[string]*entry)}
+}
+
+// get returns the entry for key, or nil if it doesn't exist or has expired.
+func (d *db) get(key string) *entry {
+	e := d.keys[key]
+	if e == nil {
+		return nil
+	}
+	if !e.expires.IsZero() && !e.expires.After(d.s.now()) {
+		d.del(key)
+		return nil
+	}
+	return e
+}
+
+// lookup returns the entry for key if it holds a value of the same type as want. If the key doesn't exist, it returns
+// nil. If the key holds a value of another type, it returns a WRONGTYPE error.
+func (d *db) lookup(key string, want interface{}) (*entry, error) {
+	e := d.get(key)
+	if e == nil {
+		return nil, nil
+	}
+
+	var ok bool
+	switch want.(type) {
+	case string:
+		_, ok = e.value.(string)
+	case list:
+		_, ok = e.value.(list)
+	case hash:
+		_, ok = e.value.(hash)
+	case set:
+		_, ok = e.value.(set)
+	case zset:
+		_, ok = e.value.(zset)
+	}
+	if !ok {
+		return nil, errWrongType
+	}
+	return e, nil
+}
+
+// set replaces key's value, clearing any expiry.
+func (d *db) set(key string, value interface{}) *entry {
+	e := &entry{value: value, version: d.s.touch()}
+	d.keys[key] = e
+	return e
+}
+
+// modified records a modification of key's entry e. If e is now an empty aggregate, the key is deleted.
+func (d *db) modified(key string, e *entry) {
+	if e.empty() {
+		d.del(key)
+		return
+	}
+	e.version = d.s.touch()
+}
+
+// del deletes key, returning true if it existed.
+func (d *db) del(key string) bool {
+	if _, ok := d.keys[key]; !ok {
+		return false
+	}
+	delete(d.keys, key)
+	d.s.delSeq = d.s.touch()
+	return true
+}
+
+// expireAll deletes all expired keys.
+func (d *db) expireAll() {
+	for key := range d.keys {
+		d.get(key)
+	}
+}
+
+func (d *db) flush() {
+	if len(d.keys) > 0 {
+		d.keys = make(map[string]*entry)
+		d.s.delSeq = d.s.touch()
+	}
+}
+
+func (d *db) sortedKeys() []string {
+	d.expireAll()
+	keys := make([]string, 0, len(d.keys))
+	for key := range d.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// globMatch reports whether str matches the Redis glob pattern. Patterns support *, ?, [abc], [^abc], [a-z], and \
+// to escape the next character.
+func globMatch(pattern, str string) bool {
+	// On a mismatch, matching resumes after the last *, which takes one more byte of str. Earlier stars never need to
+	// be retried, so matching takes at most len(pattern)*len(str) steps.
+	var (
+		star        bool
+		starPattern string
+		starStr     string
+	)
+	for len(pattern) > 0 || len(str) > 0 {
+		if len(pattern) > 0 {
+			switch pattern[0] {
+			case '*':
+				pattern = pattern[1:]
+				star, starPattern, starStr = true, pattern, str
+				continue
+
+			case '?':
+				if len(str) > 0 {
+					pattern, str = pattern[1:], str[1:]
+					continue
+				}
+
+			case '[':
+				if len(str) > 0 {
+					if matched, rest := matchClass(pattern[1:], str[0]); matched {
+						pattern, str = rest, str[1:]
+						continue
+					}
+				}
+
+			default:
+				c, n := pattern[0], 1
+				if c == '\\' && len(pattern) >= 2 {
+					c, n = pattern[1], 2
+				}
+				if len(str) > 0 && str[0] == c {
+					pattern, str = pattern[n:], str[1:]
+					continue
+				}
+			}
+		}
+
+		if !star || len(starStr) == 0 {
+			return false
+		}
+		starStr = starStr[1:]
+		pattern, str = starPattern, starStr
+	}
+	return true
+}
+
+// matchClass matches c against the character class at the start of pattern, which follows the opening '['. It
+// returns whether c matched and the pattern following the class.
+func matchClass(pattern string, c byte) (bool, string) {
+	not := len(pattern) > 0 && pattern[0] == '^'
+	if not {
+		pattern = pattern[1:]
+	}
+
+	matched := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) >= 2:
+			matched = matched || pattern[1] == c
+			pattern = pattern[2:]
+		case len(pattern) >= 3 && pattern[1] == '-' && pattern[2] != ']':
+			lo, hi := pattern[0], pattern[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (lo <= c && c <= hi)
+			pattern = pattern[3:]
+		default:
+			matched = matched || pattern[0] == c
+			pattern = pattern[1:]
+		}
+	}
+
+	if len(pattern) > 0 {
+		pattern = pattern[1:] // ']'
+	}
+	return matched != not, pattern
+}
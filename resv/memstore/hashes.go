@@ -0,0 +1,213 @@
+package memstore
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/nilium/fred/resv"
+)
+
+// hashFor returns the hash held by key, creating it if create is true and the key doesn't exist.
+func hashFor(cx *call, key string, create bool) (*entry, error) {
+	e, err := cx.db.lookup(key, hash(nil))
+	if err != nil {
+		return nil, err
+	} else if e == nil && create {
+		e = cx.db.set(key, hash{})
+	}
+	return e, nil
+}
+
+// cmdHSet implements HSET and HMSET.
+func cmdHSet(s *Store, cx *call) interface{} {
+	if len(cx.args)%2 != 1 {
+		return errArity(cx.name)
+	}
+
+	e, err := hashFor(cx, cx.args[0], true)
+	if err != nil {
+		return err
+	}
+
+	h, added := e.value.(hash), 0
+	for i := 1; i < len(cx.args); i += 2 {
+		if _, ok := h[cx.args[i]]; !ok {
+			added++
+		}
+		h[cx.args[i]] = cx.args[i+1]
+	}
+	cx.db.modified(cx.args[0], e)
+
+	if cx.name == "HMSET" {
+		return replyOK
+	}
+	return added
+}
+
+func cmdHSetNX(s *Store, cx *call) interface{} {
+	e, err := hashFor(cx, cx.args[0], true)
+	if err != nil {
+		return err
+	}
+
+	h := e.value.(hash)
+	if _, ok := h[cx.args[1]]; ok {
+		return 0
+	}
+	h[cx.args[1]] = cx.args[2]
+	cx.db.modified(cx.args[0], e)
+	return 1
+}
+
+func cmdHGet(s *Store, cx *call) interface{} {
+	e, err := hashFor(cx, cx.args[0], false)
+	if err != nil {
+		return err
+	} else if e == nil {
+		return nil
+	}
+
+	if v, ok := e.value.(hash)[cx.args[1]]; ok {
+		return v
+	}
+	return nil
+}
+
+func cmdHMGet(s *Store, cx *call) interface{} {
+	e, err := hashFor(cx, cx.args[0], false)
+	if err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(cx.args)-1)
+	if e == nil {
+		return values
+	}
+
+	h := e.value.(hash)
+	for i, field := range cx.args[1:] {
+		if v, ok := h[field]; ok {
+			values[i] = v
+		}
+	}
+	return values
+}
+
+// cmdHGetAll replies with a map of fields to values, sorted by field.
+func cmdHGetAll(s *Store, cx *call) interface{} {
+	e, err := hashFor(cx, cx.args[0], false)
+	if err != nil {
+		return err
+	} else if e == nil {
+		return resv.Map{}
+	}
+
+	h := e.value.(hash)
+	m := make(resv.Map, 0, len(h))
+	for _, field := range sortedFields(h) {
+		m = append(m, resv.MapEntry{Key: field, Value: h[field]})
+	}
+	return m
+}
+
+func cmdHDel(s *Store, cx *call) interface{} {
+	e, err := hashFor(cx, cx.args[0], false)
+	if err != nil {
+		return err
+	} else if e == nil {
+		return 0
+	}
+
+	h, n := e.value.(hash), 0
+	for _, field := range cx.args[1:] {
+		if _, ok := h[field]; ok {
+			delete(h, field)
+			n++
+		}
+	}
+	if n > 0 {
+		cx.db.modified(cx.args[0], e)
+	}
+	return n
+}
+
+func cmdHExists(s *Store, cx *call) interface{} {
+	e, err := hashFor(cx, cx.args[0], false)
+	if err != nil {
+		return err
+	} else if e == nil {
+		return 0
+	}
+
+	if _, ok := e.value.(hash)[cx.args[1]]; ok {
+		return 1
+	}
+	return 0
+}
+
+func cmdHLen(s *Store, cx *call) interface{} {
+	e, err := hashFor(cx, cx.args[0], false)
+	if err != nil {
+		return err
+	} else if e == nil {
+		return 0
+	}
+	return len(e.value.(hash))
+}
+
+// cmdHKeys implements HKEYS and HVALS. Both are sorted by field.
+func cmdHKeys(s *Store, cx *call) interface{} {
+	e, err := hashFor(cx, cx.args[0], false)
+	if err != nil {
+		return err
+	} else if e == nil {
+		return []string{}
+	}
+
+	h := e.value.(hash)
+	fields := sortedFields(h)
+	if cx.name == "HVALS" {
+		for i, field := range fields {
+			fields[i] = h[field]
+		}
+	}
+	return fields
+}
+
+func cmdHIncrBy(s *Store, cx *call) interface{} {
+	delta, ok := parseInt(cx.args[2])
+	if !ok {
+		return errNotInteger
+	}
+
+	e, err := hashFor(cx, cx.args[0], true)
+	if err != nil {
+		return err
+	}
+
+	h, cur := e.value.(hash), int64(0)
+	if v, exists := h[cx.args[1]]; exists {
+		if cur, ok = parseInt(v); !ok {
+			return errNotInteger
+		}
+	}
+
+	if (delta > 0 && cur > math.MaxInt64-delta) || (delta < 0 && cur < math.MinInt64-delta) {
+		return errOverflow
+	}
+	cur += delta
+
+	h[cx.args[1]] = strconv.FormatInt(cur, 10)
+	cx.db.modified(cx.args[0], e)
+	return cur
+}
+
+func sortedFields(h hash) []string {
+	fields := make([]string, 0, len(h))
+	for field := range h {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
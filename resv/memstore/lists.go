@@ -0,0 +1,323 @@
+package memstore
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/resv"
+)
+
+// notifyKey returns the Notifier key for key in d.
+func (d *db) notifyKey(key string) string {
+	return strconv.Itoa(d.index) + ":" + key
+}
+
+// cmdPush implements LPUSH, RPUSH, LPUSHX, and RPUSHX.
+func cmdPush(s *Store, cx *call) interface{} {
+	key := cx.args[0]
+	e, err := cx.db.lookup(key, list(nil))
+	if err != nil {
+		return err
+	} else if e == nil {
+		if strings.HasSuffix(cx.name, "X") {
+			return 0
+		}
+		e = cx.db.set(key, list(nil))
+	}
+
+	l, values := e.value.(list), cx.args[1:]
+	if cx.name[0] == 'L' {
+		// Each value is pushed onto the head in turn, so they end up in reverse order.
+		pushed := make(list, len(values), len(values)+len(l))
+		for i, v := range values {
+			pushed[len(values)-1-i] = v
+		}
+		l = append(pushed, l...)
+	} else {
+		l = append(l, values...)
+	}
+	e.value = l
+	cx.db.modified(key, e)
+	s.notify.Signal(cx.db.notifyKey(key))
+	return len(l)
+}
+
+// cmdPop implements LPOP and RPOP, with an optional count.
+func cmdPop(s *Store, cx *call) interface{} {
+	count := 1
+	if len(cx.args) == 2 {
+		n, err := strconv.Atoi(cx.args[1])
+		if err != nil || n < 0 {
+			return fred.Error("ERR value is out of range, must be positive")
+		}
+		count = n
+	} else if len(cx.args) > 2 {
+		return errSyntax
+	}
+
+	e, err := cx.db.lookup(cx.args[0], list(nil))
+	if err != nil {
+		return err
+	} else if e == nil {
+		if len(cx.args) == 2 {
+			return resv.NullArray(cx.w)
+		}
+		return nil
+	}
+
+	popped := popList(cx.db, cx.args[0], e, cx.name[0] == 'L', count)
+	if len(cx.args) == 2 {
+		return popped
+	}
+	return popped[0]
+}
+
+// popList pops up to count elements from the left or right of the list in e.
+func popList(d *db, key string, e *entry, left bool, count int) []string {
+	l := e.value.(list)
+	if count > len(l) {
+		count = len(l)
+	}
+
+	popped := make([]string, count)
+	if left {
+		copy(popped, l[:count])
+		l = l[count:]
+	} else {
+		for i := range popped {
+			popped[i] = l[len(l)-1-i]
+		}
+		l = l[:len(l)-count]
+	}
+
+	e.value = l
+	d.modified(key, e)
+	return popped
+}
+
+// cmdBlockingPop implements BLPOP and BRPOP.
+func cmdBlockingPop(s *Store, cx *call) error {
+	timeout, ok := parseFloat(cx.args[len(cx.args)-1])
+	if !ok {
+		return cx.w.Write(errTimeout)
+	} else if timeout < 0 {
+		return cx.w.Write(errNegTimeout)
+	}
+
+	keys := cx.args[:len(cx.args)-1]
+	left := cx.name == "BLPOP"
+
+	nkeys := make([]string, len(keys))
+	for i, key := range keys {
+		nkeys[i] = cx.db.notifyKey(key)
+	}
+
+	var b *resv.Blocked
+	for {
+		for _, key := range keys {
+			e, err := cx.db.lookup(key, list(nil))
+			if err != nil {
+				return cx.w.Write(err)
+			} else if e != nil {
+				return cx.w.Write([]string{key, popList(cx.db, key, e, left, 1)[0]})
+			}
+		}
+
+		// Commands in a transaction never block.
+		if resv.InTransaction(cx.w) {
+			return cx.w.Write(resv.NullArray(cx.w))
+		}
+
+		if b == nil {
+			b = s.notify.Block(cx.w, time.Duration(timeout*float64(time.Second)), nkeys...)
+			defer b.Stop()
+		}
+
+		// The Store's lock is held by the transaction middleware, so release it while waiting.
+		s.mu.Unlock()
+		_, err := b.Wait()
+		s.mu.Lock()
+		if err != nil {
+			if err == resv.ErrNotBlockable {
+				return cx.w.Write(resv.NullArray(cx.w))
+			}
+			return nil
+		}
+	}
+}
+
+func cmdLLen(s *Store, cx *call) interface{} {
+	e, err := cx.db.lookup(cx.args[0], list(nil))
+	if err != nil {
+		return err
+	} else if e == nil {
+		return 0
+	}
+	return len(e.value.(list))
+}
+
+// listRange converts Redis start and stop indices, which may be negative, to a slice range of a list of length n. If
+// the range is empty, it returns start >= end.
+func listRange(start, stop int64, n int) (int, int) {
+	if start < 0 {
+		start += int64(n)
+	}
+	if stop < 0 {
+		stop += int64(n)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= int64(n) {
+		stop = int64(n) - 1
+	}
+	if start > stop {
+		return 0, 0
+	}
+	return int(start), int(stop) + 1
+}
+
+func cmdLRange(s *Store, cx *call) interface{} {
+	start, ok1 := parseInt(cx.args[1])
+	stop, ok2 := parseInt(cx.args[2])
+	if !ok1 || !ok2 {
+		return errNotInteger
+	}
+
+	e, err := cx.db.lookup(cx.args[0], list(nil))
+	if err != nil {
+		return err
+	} else if e == nil {
+		return []string{}
+	}
+
+	l := e.value.(list)
+	i, j := listRange(start, stop, len(l))
+	return append([]string{}, l[i:j]...)
+}
+
+func cmdLIndex(s *Store, cx *call) interface{} {
+	i, ok := parseInt(cx.args[1])
+	if !ok {
+		return errNotInteger
+	}
+
+	e, err := cx.db.lookup(cx.args[0], list(nil))
+	if err != nil {
+		return err
+	} else if e == nil {
+		return nil
+	}
+
+	l := e.value.(list)
+	if i < 0 {
+		i += int64(len(l))
+	}
+	if i < 0 || i >= int64(len(l)) {
+		return nil
+	}
+	return l[i]
+}
+
+func cmdLSet(s *Store, cx *call) interface{} {
+	i, ok := parseInt(cx.args[1])
+	if !ok {
+		return errNotInteger
+	}
+
+	e, err := cx.db.lookup(cx.args[0], list(nil))
+	if err != nil {
+		return err
+	} else if e == nil {
+		return errNoSuchKey
+	}
+
+	l := e.value.(list)
+	if i < 0 {
+		i += int64(len(l))
+	}
+	if i < 0 || i >= int64(len(l)) {
+		return errOutOfRange
+	}
+	l[i] = cx.args[2]
+	cx.db.modified(cx.args[0], e)
+	return replyOK
+}
+
+func cmdLRem(s *Store, cx *call) interface{} {
+	count, ok := parseInt(cx.args[1])
+	if !ok {
+		return errNotInteger
+	}
+
+	e, err := cx.db.lookup(cx.args[0], list(nil))
+	if err != nil {
+		return err
+	} else if e == nil {
+		return 0
+	}
+
+	l, v := e.value.(list), cx.args[2]
+	removed := 0
+	keep := func(i int) bool {
+		if l[i] != v || (count != 0 && int64(removed) >= abs(count)) {
+			return true
+		}
+		removed++
+		return false
+	}
+
+	result := make(list, 0, len(l))
+	if count >= 0 {
+		for i := range l {
+			if keep(i) {
+				result = append(result, l[i])
+			}
+		}
+	} else {
+		for i := len(l) - 1; i >= 0; i-- {
+			if keep(i) {
+				result = append(result, l[i])
+			}
+		}
+		for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+			result[i], result[j] = result[j], result[i]
+		}
+	}
+
+	if removed > 0 {
+		e.value = result
+		cx.db.modified(cx.args[0], e)
+	}
+	return removed
+}
+
+func abs(i int64) int64 {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+func cmdLTrim(s *Store, cx *call) interface{} {
+	start, ok1 := parseInt(cx.args[1])
+	stop, ok2 := parseInt(cx.args[2])
+	if !ok1 || !ok2 {
+		return errNotInteger
+	}
+
+	e, err := cx.db.lookup(cx.args[0], list(nil))
+	if err != nil {
+		return err
+	} else if e == nil {
+		return replyOK
+	}
+
+	l := e.value.(list)
+	i, j := listRange(start, stop, len(l))
+	e.value = append(list(nil), l[i:j]...)
+	cx.db.modified(cx.args[0], e)
+	return replyOK
+}
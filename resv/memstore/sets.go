@@ -0,0 +1,141 @@
+package memstore
+
+import (
+	"sort"
+
+	"github.com/nilium/fred/resv"
+)
+
+func cmdSAdd(s *Store, cx *call) interface{} {
+	e, err := cx.db.lookup(cx.args[0], set(nil))
+	if err != nil {
+		return err
+	} else if e == nil {
+		e = cx.db.set(cx.args[0], set{})
+	}
+
+	m, added := e.value.(set), 0
+	for _, member := range cx.args[1:] {
+		if _, ok := m[member]; !ok {
+			m[member] = struct{}{}
+			added++
+		}
+	}
+	if added > 0 {
+		cx.db.modified(cx.args[0], e)
+	}
+	return added
+}
+
+func cmdSRem(s *Store, cx *call) interface{} {
+	e, err := cx.db.lookup(cx.args[0], set(nil))
+	if err != nil {
+		return err
+	} else if e == nil {
+		return 0
+	}
+
+	m, removed := e.value.(set), 0
+	for _, member := range cx.args[1:] {
+		if _, ok := m[member]; ok {
+			delete(m, member)
+			removed++
+		}
+	}
+	if removed > 0 {
+		cx.db.modified(cx.args[0], e)
+	}
+	return removed
+}
+
+func cmdSMembers(s *Store, cx *call) interface{} {
+	e, err := cx.db.lookup(cx.args[0], set(nil))
+	if err != nil {
+		return err
+	} else if e == nil {
+		return resv.Set{}
+	}
+	return sortedMembers(e.value.(set))
+}
+
+func cmdSIsMember(s *Store, cx *call) interface{} {
+	e, err := cx.db.lookup(cx.args[0], set(nil))
+	if err != nil {
+		return err
+	} else if e == nil {
+		return 0
+	}
+
+	if _, ok := e.value.(set)[cx.args[1]]; ok {
+		return 1
+	}
+	return 0
+}
+
+func cmdSCard(s *Store, cx *call) interface{} {
+	e, err := cx.db.lookup(cx.args[0], set(nil))
+	if err != nil {
+		return err
+	} else if e == nil {
+		return 0
+	}
+	return len(e.value.(set))
+}
+
+// cmdSetOp implements SINTER, SUNION, and SDIFF.
+func cmdSetOp(s *Store, cx *call) interface{} {
+	sets := make([]set, len(cx.args))
+	for i, key := range cx.args {
+		e, err := cx.db.lookup(key, set(nil))
+		if err != nil {
+			return err
+		} else if e != nil {
+			sets[i] = e.value.(set)
+		}
+	}
+
+	result := set{}
+	switch cx.name {
+	case "SINTER":
+	members:
+		for member := range sets[0] {
+			for _, other := range sets[1:] {
+				if _, ok := other[member]; !ok {
+					continue members
+				}
+			}
+			result[member] = struct{}{}
+		}
+	case "SUNION":
+		for _, m := range sets {
+			for member := range m {
+				result[member] = struct{}{}
+			}
+		}
+	case "SDIFF":
+		for member := range sets[0] {
+			result[member] = struct{}{}
+		}
+		for _, other := range sets[1:] {
+			for member := range other {
+				delete(result, member)
+			}
+		}
+	}
+	return sortedMembers(result)
+}
+
+// sortedMembers returns the members of m as a resv.Set, sorted so that replies are deterministic.
+func sortedMembers(m set) resv.Set {
+	members := make([]string, 0, len(m))
+	for member := range m {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+
+	reply := make(resv.Set, len(members))
+	for i, member := range members {
+		reply[i] = member
+	}
+	return reply
+}
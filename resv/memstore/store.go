@@ -0,0 +1,433 @@
+// Package memstore implements an in-memory, Redis-compatible keyspace as a resv.Handler. It is intended for tests and
+// development, where an in-process server can stand in for a real Redis.
+//
+// A Store supports strings, lists, hashes, sets, and sorted sets, key expiry, multiple databases, KEYS and SCAN,
+// blocking list pops, and MULTI/EXEC/WATCH transactions. Replies use Redis's standard error messages.
+package memstore
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/resv"
+)
+
+// DefaultDatabases is the number of databases in a Store created by New.
+const DefaultDatabases = 16
+
+// Standard error replies.
+var (
+	errWrongType   = fred.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errNotInteger  = fred.Error("ERR value is not an integer or out of range")
+	errNotFloat    = fred.Error("ERR value is not a valid float")
+	errSyntax      = fred.Error("ERR syntax error")
+	errNoSuchKey   = fred.Error("ERR no such key")
+	errDBIndex     = fred.Error("ERR DB index is out of range")
+	errOverflow    = fred.Error("ERR increment or decrement would overflow")
+	errOutOfRange  = fred.Error("ERR index out of range")
+	errExpireTime  = fred.Error("ERR invalid expire time in 'set' command")
+	errTimeout     = fred.Error("ERR timeout is not a float or out of range")
+	errNegTimeout  = fred.Error("ERR timeout is negative")
+	errMinMaxFloat = fred.Error("ERR min or max is not a float")
+
+	replyOK   = resv.SimpleString("OK")
+	replyPong = resv.SimpleString("PONG")
+)
+
+func errArity(name string) fred.Error {
+	return fred.Error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+}
+
+// Store is an in-memory keyspace. It implements resv.Handler. A Store is safe for concurrent use.
+type Store struct {
+	handler resv.Handler
+	notify  resv.Notifier
+	now     func() time.Time
+
+	// mu guards all fields below. It is held by the transaction middleware while each command runs.
+	mu  sync.Mutex
+	dbs []*db
+	// seq is incremented on every modification and is used for key versions. delSeq is the seq of the last deletion.
+	seq    uint64
+	delSeq uint64
+}
+
+var _ = resv.Handler((*Store)(nil))
+
+// New returns a Store with DefaultDatabases databases.
+func New() *Store {
+	return NewDatabases(DefaultDatabases)
+}
+
+// NewDatabases returns a Store with n databases. n must be at least 1.
+func NewDatabases(n int) *Store {
+	if n < 1 {
+		panic("memstore: a Store must have at least one database")
+	}
+
+	s := &Store{
+		now: time.Now,
+		dbs: make([]*db, n),
+	}
+	for i := range s.dbs {
+		s.dbs[i] = newDB(s, i)
+	}
+
+	s.handler = resv.Transactions(resv.TxConfig{
+		Lock:     &s.mu,
+		Versions: keyVersions{s},
+	})(resv.HandlerFunc(s.serve))
+	return s
+}
+
+// ServeRESP handles a single command.
+func (s *Store) ServeRESP(w resv.ResponseWriter, r fred.Resp) error {
+	return s.handler.ServeRESP(w, r)
+}
+
+// Info adds the keyspace section to info. It can be passed to resv.Server.InfoHandler.
+func (s *Store) Info(info *resv.Info, section string) {
+	if section != "" && section != "keyspace" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info.Section("Keyspace")
+	for i, d := range s.dbs {
+		d.expireAll()
+		if len(d.keys) == 0 {
+			continue
+		}
+
+		expires := 0
+		for _, e := range d.keys {
+			if !e.expires.IsZero() {
+				expires++
+			}
+		}
+		info.Add("db"+strconv.Itoa(i), fmt.Sprintf("keys=%d,expires=%d,avg_ttl=0", len(d.keys), expires))
+	}
+}
+
+// dbKey is the resv.Client value key for a client's selected database.
+type dbKey struct{}
+
+// call is a single command being handled.
+type call struct {
+	w    resv.ResponseWriter
+	db   *db
+	name string
+	args []string // arguments, not including the command name
+}
+
+func (s *Store) serve(w resv.ResponseWriter, r fred.Resp) error {
+	args, err := r.StrList()
+	if err != nil || len(args) == 0 || !r.IsType(fred.Array) {
+		return w.Write(fred.Error("ERR Protocol error: expected an array of bulk strings"))
+	}
+
+	name := strings.ToUpper(args[0])
+	cmd, ok := commands[name]
+	if !ok {
+		return w.Write(fred.Error(fmt.Sprintf("ERR unknown command '%s'", args[0])))
+	}
+
+	if n := len(args); (cmd.arity > 0 && n != cmd.arity) || (cmd.arity < 0 && n < -cmd.arity) {
+		return w.Write(errArity(name))
+	}
+
+	dbIndex := 0
+	if c := resv.ClientOf(w); c != nil {
+		dbIndex, _ = c.Value(dbKey{}).(int)
+	}
+
+	cx := &call{w: w, db: s.dbs[dbIndex], name: name, args: args[1:]}
+	if cmd.blocking != nil {
+		return cmd.blocking(s, cx)
+	}
+	return w.Write(cmd.fn(s, cx))
+}
+
+// touch records a modification, returning the new seq.
+func (s *Store) touch() uint64 {
+	s.seq++
+	return s.seq
+}
+
+// keyVersions implements resv.KeyVersioner for a Store. It is only called by the transaction middleware, which holds
+// the Store's lock.
+type keyVersions struct {
+	s *Store
+}
+
+// KeyVersion returns the version of key across all databases. Any modification of the key in any database, or any
+// deletion in the Store, changes the version. This is more conservative than Redis, but never misses a change.
+func (k keyVersions) KeyVersion(key string) uint64 {
+	s := k.s
+	version := uint64(0)
+	for _, d := range s.dbs {
+		if e := d.get(key); e != nil && e.version > version {
+			version = e.version
+		}
+	}
+	if s.delSeq > version {
+		version = s.delSeq
+	}
+	return version
+}
+
+// Generic commands
+
+func cmdPing(s *Store, cx *call) interface{} {
+	switch len(cx.args) {
+	case 0:
+		return replyPong
+	case 1:
+		return cx.args[0]
+	}
+	return errArity(cx.name)
+}
+
+func cmdEcho(s *Store, cx *call) interface{} {
+	return cx.args[0]
+}
+
+func cmdSelect(s *Store, cx *call) interface{} {
+	i, err := strconv.Atoi(cx.args[0])
+	if err != nil {
+		return errNotInteger
+	} else if i < 0 || i >= len(s.dbs) {
+		return errDBIndex
+	}
+
+	c := resv.ClientOf(cx.w)
+	if c == nil {
+		return fred.Error("ERR SELECT requires a client connection")
+	}
+	c.SetValue(dbKey{}, i)
+	return replyOK
+}
+
+func cmdDel(s *Store, cx *call) interface{} {
+	n := 0
+	for _, key := range cx.args {
+		if cx.db.del(key) {
+			n++
+		}
+	}
+	return n
+}
+
+func cmdExists(s *Store, cx *call) interface{} {
+	n := 0
+	for _, key := range cx.args {
+		if cx.db.get(key) != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func cmdType(s *Store, cx *call) interface{} {
+	e := cx.db.get(cx.args[0])
+	if e == nil {
+		return resv.SimpleString("none")
+	}
+	return resv.SimpleString(e.typeName())
+}
+
+func cmdExpire(s *Store, cx *call) interface{} {
+	n, err := strconv.ParseInt(cx.args[1], 10, 64)
+	if err != nil {
+		return errNotInteger
+	}
+
+	var at time.Time
+	now := s.now()
+	switch cx.name {
+	case "EXPIRE":
+		at = now.Add(time.Duration(n) * time.Second)
+	case "PEXPIRE":
+		at = now.Add(time.Duration(n) * time.Millisecond)
+	case "EXPIREAT":
+		at = time.Unix(n, 0)
+	case "PEXPIREAT":
+		at = time.Unix(0, n*int64(time.Millisecond))
+	}
+
+	e := cx.db.get(cx.args[0])
+	if e == nil {
+		return 0
+	}
+
+	if !at.After(now) {
+		cx.db.del(cx.args[0])
+		return 1
+	}
+	e.expires = at
+	e.version = s.touch()
+	return 1
+}
+
+func cmdTTL(s *Store, cx *call) interface{} {
+	e := cx.db.get(cx.args[0])
+	if e == nil {
+		return -2
+	} else if e.expires.IsZero() {
+		return -1
+	}
+
+	ttl := e.expires.Sub(s.now())
+	if cx.name == "PTTL" {
+		return int64((ttl + time.Millisecond/2) / time.Millisecond)
+	}
+	return int64((ttl + time.Second/2) / time.Second)
+}
+
+func cmdPersist(s *Store, cx *call) interface{} {
+	e := cx.db.get(cx.args[0])
+	if e == nil || e.expires.IsZero() {
+		return 0
+	}
+	e.expires = time.Time{}
+	e.version = s.touch()
+	return 1
+}
+
+func cmdKeys(s *Store, cx *call) interface{} {
+	keys := []string{}
+	for _, key := range cx.db.sortedKeys() {
+		if globMatch(cx.args[0], key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// cmdScan implements SCAN. The cursor is an offset into the sorted list of keys, so keys added or removed during a
+// scan may cause other keys to be skipped or returned twice.
+func cmdScan(s *Store, cx *call) interface{} {
+	cursor, err := strconv.Atoi(cx.args[0])
+	if err != nil || cursor < 0 {
+		return fred.Error("ERR invalid cursor")
+	}
+
+	pattern, count, typ := "*", 10, ""
+	for opts := cx.args[1:]; len(opts) > 0; opts = opts[2:] {
+		if len(opts) < 2 {
+			return errSyntax
+		}
+		switch strings.ToUpper(opts[0]) {
+		case "MATCH":
+			pattern = opts[1]
+		case "COUNT":
+			if count, err = strconv.Atoi(opts[1]); err != nil {
+				return errNotInteger
+			} else if count < 1 {
+				return errSyntax
+			}
+		case "TYPE":
+			typ = strings.ToLower(opts[1])
+		default:
+			return errSyntax
+		}
+	}
+
+	keys := cx.db.sortedKeys()
+	end := cursor + count
+	if end >= len(keys) {
+		end = len(keys)
+	}
+
+	found := []string{}
+	for i := cursor; i < end; i++ {
+		if !globMatch(pattern, keys[i]) {
+			continue
+		}
+		if typ != "" && cx.db.keys[keys[i]].typeName() != typ {
+			continue
+		}
+		found = append(found, keys[i])
+	}
+
+	next := end
+	if next >= len(keys) {
+		next = 0
+	}
+	return []interface{}{strconv.Itoa(next), found}
+}
+
+func cmdRename(s *Store, cx *call) interface{} {
+	src, dst := cx.args[0], cx.args[1]
+	e := cx.db.get(src)
+	if e == nil {
+		return errNoSuchKey
+	}
+
+	if cx.name == "RENAMENX" && cx.db.get(dst) != nil {
+		return 0
+	}
+
+	cx.db.del(src)
+	cx.db.del(dst)
+	e.version = s.touch()
+	cx.db.keys[dst] = e
+
+	if cx.name == "RENAMENX" {
+		return 1
+	}
+	return replyOK
+}
+
+func cmdDBSize(s *Store, cx *call) interface{} {
+	cx.db.expireAll()
+	return len(cx.db.keys)
+}
+
+func cmdFlushDB(s *Store, cx *call) interface{} {
+	cx.db.flush()
+	return replyOK
+}
+
+func cmdFlushAll(s *Store, cx *call) interface{} {
+	for _, d := range s.dbs {
+		d.flush()
+	}
+	return replyOK
+}
+
+// parseInt parses an integer argument.
+func parseInt(s string) (int64, bool) {
+	i, err := strconv.ParseInt(s, 10, 64)
+	return i, err == nil
+}
+
+// parseFloat parses a float argument, accepting Redis's spellings of infinity.
+func parseFloat(s string) (float64, bool) {
+	switch strings.ToLower(s) {
+	case "inf", "+inf":
+		s = "+Inf"
+	case "-inf":
+		s = "-Inf"
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil && !math.IsNaN(f)
+}
+
+// formatFloat formats a float the way Redis replies with scores and INCRBYFLOAT results.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
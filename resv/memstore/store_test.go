@@ -0,0 +1,314 @@
+package memstore
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/resv"
+	"github.com/nilium/fred/resv/resvtest"
+)
+
+type testConn struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// startStore serves s on a loopback listener and returns the server and a function to connect to it.
+func startStore(t *testing.T, s *Store) (*resv.Server, func() *testConn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := resv.NewServer(s)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.Serve(l)
+	}()
+	t.Cleanup(func() {
+		srv.Close()
+		<-done
+	})
+
+	return srv, func() *testConn {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		t.Cleanup(func() { conn.Close() })
+		return &testConn{t: t, conn: conn, r: bufio.NewReader(conn)}
+	}
+}
+
+func (c *testConn) send(args ...string) {
+	c.t.Helper()
+	p, err := resv.MarshalRESP(args)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := c.conn.Write(p); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testConn) expect(want string) {
+	c.t.Helper()
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(c.r, got); err != nil {
+		c.t.Fatalf("error reading %q: %v (got %q)", want, err, got)
+	}
+	if string(got) != want {
+		c.t.Fatalf("read %q; want %q", got, want)
+	}
+}
+
+// do sends a command and checks its raw reply.
+func (c *testConn) do(want string, args ...string) {
+	c.t.Helper()
+	c.send(args...)
+	c.expect(want)
+}
+
+func TestStrings(t *testing.T) {
+	_, dial := startStore(t, New())
+	c := dial()
+
+	c.do("$-1\r\n", "GET", "k")
+	c.do("+OK\r\n", "SET", "k", "v")
+	c.do("$1\r\nv\r\n", "GET", "k")
+	c.do("$-1\r\n", "SET", "k", "w", "NX")
+	c.do("$1\r\nv\r\n", "SET", "k", "w", "XX", "GET")
+	c.do("$1\r\nw\r\n", "GET", "k")
+	c.do(":2\r\n", "APPEND", "k", "x")
+	c.do("-ERR value is not an integer or out of range\r\n", "INCR", "k")
+	c.do(":5\r\n", "INCRBY", "n", "5")
+	c.do(":4\r\n", "DECR", "n")
+	c.do("$3\r\n5.5\r\n", "INCRBYFLOAT", "n", "1.5")
+	c.do("*3\r\n$2\r\nwx\r\n$-1\r\n$3\r\n5.5\r\n", "MGET", "k", "missing", "n")
+	c.do("-ERR syntax error\r\n", "SET", "k", "v", "NX", "XX")
+	c.do("-ERR wrong number of arguments for 'get' command\r\n", "GET")
+	c.do("-ERR unknown command 'NOPE'\r\n", "NOPE")
+}
+
+func TestServeWithoutClient(t *testing.T) {
+	s := New()
+	serve := func(want interface{}, args ...string) {
+		t.Helper()
+		rec, err := resvtest.Serve(s, args...)
+		if err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		resvtest.AssertReply(t, rec.Result(), want)
+	}
+
+	// Commands use database 0 and don't need a connection, except those that keep per-client state.
+	serve("OK", "SET", "k", "v")
+	serve("v", "GET", "k")
+	serve(2, "LPUSH", "l", "2", "3")
+	serve([]string{"3", "2"}, "LRANGE", "l", "0", "-1")
+	serve(fred.Error("ERR SELECT requires a client connection"), "SELECT", "1")
+	serve(fred.Error("ERR transactions require a client connection"), "MULTI")
+}
+
+func TestWrongType(t *testing.T) {
+	_, dial := startStore(t, New())
+	c := dial()
+
+	c.do(":1\r\n", "LPUSH", "l", "a")
+	c.do("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n", "GET", "l")
+	c.do("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n", "SADD", "l", "a")
+	c.do("+list\r\n", "TYPE", "l")
+}
+
+func TestExpiry(t *testing.T) {
+	s := New()
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+	advance := func(d time.Duration) {
+		s.mu.Lock()
+		now = now.Add(d)
+		s.mu.Unlock()
+	}
+	_, dial := startStore(t, s)
+	c := dial()
+
+	c.do("+OK\r\n", "SET", "k", "v", "EX", "10")
+	c.do(":10\r\n", "TTL", "k")
+	c.do(":10000\r\n", "PTTL", "k")
+	c.do(":-2\r\n", "TTL", "missing")
+
+	advance(9 * time.Second)
+	c.do("$1\r\nv\r\n", "GET", "k")
+	c.do(":1\r\n", "TTL", "k")
+
+	advance(time.Second)
+	c.do("$-1\r\n", "GET", "k")
+	c.do(":0\r\n", "EXISTS", "k")
+
+	c.do("+OK\r\n", "SET", "k", "v")
+	c.do(":1\r\n", "EXPIRE", "k", "5")
+	c.do(":1\r\n", "PERSIST", "k")
+	c.do(":-1\r\n", "TTL", "k")
+	advance(time.Minute)
+	c.do(":1\r\n", "EXISTS", "k")
+}
+
+func TestDatabases(t *testing.T) {
+	_, dial := startStore(t, NewDatabases(2))
+	c, other := dial(), dial()
+
+	c.do("+OK\r\n", "SET", "k", "0")
+	c.do("+OK\r\n", "SELECT", "1")
+	c.do("$-1\r\n", "GET", "k")
+	c.do("+OK\r\n", "SET", "k", "1")
+	c.do("-ERR DB index is out of range\r\n", "SELECT", "2")
+
+	// Each client has its own selected database.
+	other.do("$1\r\n0\r\n", "GET", "k")
+	other.do(":1\r\n", "DBSIZE")
+
+	c.do("+OK\r\n", "FLUSHDB")
+	other.do(":1\r\n", "DBSIZE")
+}
+
+func TestKeysAndScan(t *testing.T) {
+	_, dial := startStore(t, New())
+	c := dial()
+
+	c.do("+OK\r\n", "MSET", "user:1", "a", "user:2", "b", "item:1", "c")
+	c.do(":1\r\n", "SADD", "user:set", "x")
+	c.do("*3\r\n$6\r\nuser:1\r\n$6\r\nuser:2\r\n$8\r\nuser:set\r\n", "KEYS", "user:*")
+	c.do("*1\r\n$6\r\nitem:1\r\n", "KEYS", "[i]tem:?")
+
+	c.do("*2\r\n$1\r\n2\r\n*1\r\n$6\r\nitem:1\r\n", "SCAN", "0", "COUNT", "2", "MATCH", "item:*")
+	c.do("*2\r\n$1\r\n0\r\n*1\r\n$8\r\nuser:set\r\n", "SCAN", "2", "COUNT", "2", "TYPE", "set")
+}
+
+func TestGlobMatch(t *testing.T) {
+	long := strings.Repeat("a", 200)
+	cases := []struct {
+		pattern, str string
+		want         bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"*", "", true},
+		{"a*", "abc", true},
+		{"*c", "abc", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"a**c", "ac", true},
+		{"?b?", "abc", true},
+		{"?", "", false},
+		{"[^a]*", "abc", false},
+		{"[a-c]x", "bx", true},
+		{`a\*`, "a*", true},
+		{`a\*`, "ab", false},
+		{"*ab", "aab", true},
+		// Patterns like these take exponential time if every * tries every split of the string.
+		{"*a*a*a*a*a*a*b", long, false},
+		{"*a*a*a*a*a*a*a", long, true},
+	}
+	for _, c := range cases {
+		if got := globMatch(c.pattern, c.str); got != c.want {
+			t.Errorf("globMatch(%q, %.10q) = %v; want %v", c.pattern, c.str, got, c.want)
+		}
+	}
+}
+
+func TestLists(t *testing.T) {
+	_, dial := startStore(t, New())
+	c := dial()
+
+	c.do(":3\r\n", "RPUSH", "l", "a", "b", "c")
+	c.do(":4\r\n", "LPUSH", "l", "z")
+	c.do("*4\r\n$1\r\nz\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n", "LRANGE", "l", "0", "-1")
+	c.do("*2\r\n$1\r\nb\r\n$1\r\nc\r\n", "LRANGE", "l", "-2", "100")
+	c.do("$1\r\nc\r\n", "LINDEX", "l", "-1")
+	c.do("$1\r\nz\r\n", "LPOP", "l")
+	c.do("*2\r\n$1\r\nc\r\n$1\r\nb\r\n", "RPOP", "l", "2")
+	c.do("$1\r\na\r\n", "LPOP", "l")
+	c.do(":0\r\n", "EXISTS", "l")
+	c.do(":0\r\n", "LPUSHX", "l", "a")
+	c.do(":3\r\n", "LPUSH", "l", "a", "b", "c")
+	c.do("*3\r\n$1\r\nc\r\n$1\r\nb\r\n$1\r\na\r\n", "LRANGE", "l", "0", "-1")
+}
+
+func TestBlockingPop(t *testing.T) {
+	srv, dial := startStore(t, New())
+	c, other := dial(), dial()
+
+	c.do("*-1\r\n", "BLPOP", "q", "0.01")
+
+	c.send("BRPOP", "q", "0")
+	for i := 0; srv.Client(1).State() != resv.StateBlocked; i++ {
+		if i == 500 {
+			t.Fatal("client did not block")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	other.do(":2\r\n", "RPUSH", "q", "a", "b")
+	c.expect("*2\r\n$1\r\nq\r\n$1\r\nb\r\n")
+	other.do(":1\r\n", "LLEN", "q")
+
+	// Blocking commands don't block in a transaction.
+	c.do("+OK\r\n", "MULTI")
+	c.do("+QUEUED\r\n", "BLPOP", "empty", "0")
+	c.do("*1\r\n*-1\r\n", "EXEC")
+}
+
+func TestHashes(t *testing.T) {
+	_, dial := startStore(t, New())
+	c := dial()
+
+	c.do(":2\r\n", "HSET", "h", "b", "2", "a", "1")
+	c.do(":0\r\n", "HSETNX", "h", "a", "x")
+	c.do("$1\r\n1\r\n", "HGET", "h", "a")
+	c.do("*4\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n", "HGETALL", "h")
+	c.do(":12\r\n", "HINCRBY", "h", "b", "10")
+	c.do("*2\r\n$1\r\n1\r\n$2\r\n12\r\n", "HVALS", "h")
+	c.do(":2\r\n", "HDEL", "h", "a", "b", "c")
+	c.do(":0\r\n", "EXISTS", "h")
+}
+
+func TestSets(t *testing.T) {
+	_, dial := startStore(t, New())
+	c := dial()
+
+	c.do(":3\r\n", "SADD", "a", "x", "y", "z")
+	c.do(":2\r\n", "SADD", "b", "y", "w")
+	c.do(":1\r\n", "SISMEMBER", "a", "x")
+	c.do(":3\r\n", "SCARD", "a")
+	c.do("*1\r\n$1\r\ny\r\n", "SINTER", "a", "b")
+	c.do("*2\r\n$1\r\nx\r\n$1\r\nz\r\n", "SDIFF", "a", "b")
+	c.do("*4\r\n$1\r\nw\r\n$1\r\nx\r\n$1\r\ny\r\n$1\r\nz\r\n", "SUNION", "a", "b", "missing")
+	c.do(":1\r\n", "SREM", "b", "w")
+	c.do("*1\r\n$1\r\ny\r\n", "SMEMBERS", "b")
+}
+
+func TestSortedSets(t *testing.T) {
+	_, dial := startStore(t, New())
+	c := dial()
+
+	c.do(":3\r\n", "ZADD", "z", "1", "a", "2", "b", "3", "c")
+	c.do(":2\r\n", "ZADD", "z", "CH", "2.5", "a", "4", "d")
+	c.do(":1\r\n", "ZADD", "z", "CH", "NX", "0", "a", "0", "e")
+	c.do("$3\r\n2.5\r\n", "ZSCORE", "z", "a")
+	c.do("*6\r\n$1\r\ne\r\n$1\r\n0\r\n$1\r\nb\r\n$1\r\n2\r\n$1\r\na\r\n$3\r\n2.5\r\n", "ZRANGE", "z", "0", "2", "WITHSCORES")
+	c.do("*2\r\n$1\r\nd\r\n$1\r\nc\r\n", "ZREVRANGE", "z", "0", "1")
+	c.do("*2\r\n$1\r\na\r\n$1\r\nc\r\n", "ZRANGEBYSCORE", "z", "(2", "3")
+	c.do("*1\r\n$1\r\nc\r\n", "ZRANGEBYSCORE", "z", "-inf", "+inf", "LIMIT", "3", "1")
+	c.do(":3\r\n", "ZCOUNT", "z", "2", "(4")
+	c.do(":4\r\n", "ZRANK", "z", "d")
+	c.do("$1\r\n5\r\n", "ZINCRBY", "z", "1", "d")
+	c.do("-ERR value is not a valid float\r\n", "ZADD", "z", "x", "a")
+	c.do(":5\r\n", "ZCARD", "z")
+}
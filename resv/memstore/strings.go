@@ -0,0 +1,273 @@
+package memstore
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nilium/fred"
+)
+
+func cmdGet(s *Store, cx *call) interface{} {
+	e, err := cx.db.lookup(cx.args[0], "")
+	if err != nil {
+		return err
+	} else if e == nil {
+		return nil
+	}
+
+	if cx.name == "GETDEL" {
+		cx.db.del(cx.args[0])
+	}
+	return e.value
+}
+
+// cmdSet implements SET key value [NX|XX] [GET] [EX seconds|PX milliseconds|EXAT timestamp|PXAT timestamp|KEEPTTL].
+func cmdSet(s *Store, cx *call) interface{} {
+	key, value := cx.args[0], cx.args[1]
+
+	var (
+		nx, xx, get, keepTTL bool
+		expires              time.Time
+	)
+	for opts := cx.args[2:]; len(opts) > 0; opts = opts[1:] {
+		switch opt := strings.ToUpper(opts[0]); opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GET":
+			get = true
+		case "KEEPTTL":
+			keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if len(opts) < 2 || !expires.IsZero() {
+				return errSyntax
+			}
+			n, ok := parseInt(opts[1])
+			if !ok {
+				return errNotInteger
+			} else if n <= 0 {
+				return errExpireTime
+			}
+			opts = opts[1:]
+
+			switch opt {
+			case "EX":
+				expires = s.now().Add(time.Duration(n) * time.Second)
+			case "PX":
+				expires = s.now().Add(time.Duration(n) * time.Millisecond)
+			case "EXAT":
+				expires = time.Unix(n, 0)
+			case "PXAT":
+				expires = time.Unix(0, n*int64(time.Millisecond))
+			}
+		default:
+			return errSyntax
+		}
+	}
+
+	if (nx && xx) || (keepTTL && !expires.IsZero()) {
+		return errSyntax
+	}
+
+	old := cx.db.get(key)
+	var reply interface{} = replyOK
+	if get {
+		if old == nil {
+			reply = nil
+		} else if v, ok := old.value.(string); ok {
+			reply = v
+		} else {
+			return errWrongType
+		}
+	}
+
+	if (nx && old != nil) || (xx && old == nil) {
+		if get {
+			return reply
+		}
+		return nil
+	}
+
+	e := cx.db.set(key, value)
+	if keepTTL && old != nil {
+		e.expires = old.expires
+	} else {
+		e.expires = expires
+	}
+	return reply
+}
+
+func cmdSetNX(s *Store, cx *call) interface{} {
+	if cx.db.get(cx.args[0]) != nil {
+		return 0
+	}
+	cx.db.set(cx.args[0], cx.args[1])
+	return 1
+}
+
+// cmdSetEx implements SETEX and PSETEX.
+func cmdSetEx(s *Store, cx *call) interface{} {
+	n, ok := parseInt(cx.args[1])
+	if !ok {
+		return errNotInteger
+	} else if n <= 0 {
+		return errExpireTime
+	}
+
+	unit := time.Second
+	if cx.name == "PSETEX" {
+		unit = time.Millisecond
+	}
+	cx.db.set(cx.args[0], cx.args[2]).expires = s.now().Add(time.Duration(n) * unit)
+	return replyOK
+}
+
+func cmdGetSet(s *Store, cx *call) interface{} {
+	e, err := cx.db.lookup(cx.args[0], "")
+	if err != nil {
+		return err
+	}
+	cx.db.set(cx.args[0], cx.args[1])
+	if e == nil {
+		return nil
+	}
+	return e.value
+}
+
+func cmdMGet(s *Store, cx *call) interface{} {
+	values := make([]interface{}, len(cx.args))
+	for i, key := range cx.args {
+		if e := cx.db.get(key); e != nil {
+			if v, ok := e.value.(string); ok {
+				values[i] = v
+			}
+		}
+	}
+	return values
+}
+
+func cmdMSet(s *Store, cx *call) interface{} {
+	if len(cx.args)%2 != 0 {
+		return errArity(cx.name)
+	}
+
+	if cx.name == "MSETNX" {
+		for i := 0; i < len(cx.args); i += 2 {
+			if cx.db.get(cx.args[i]) != nil {
+				return 0
+			}
+		}
+	}
+
+	for i := 0; i < len(cx.args); i += 2 {
+		cx.db.set(cx.args[i], cx.args[i+1])
+	}
+
+	if cx.name == "MSETNX" {
+		return 1
+	}
+	return replyOK
+}
+
+// cmdIncr implements INCR, DECR, INCRBY, and DECRBY.
+func cmdIncr(s *Store, cx *call) interface{} {
+	delta := int64(1)
+	if len(cx.args) == 2 {
+		var ok bool
+		if delta, ok = parseInt(cx.args[1]); !ok {
+			return errNotInteger
+		}
+	}
+	if cx.name == "DECR" || cx.name == "DECRBY" {
+		if delta == math.MinInt64 {
+			return errOverflow
+		}
+		delta = -delta
+	}
+
+	e, err := cx.db.lookup(cx.args[0], "")
+	if err != nil {
+		return err
+	}
+
+	cur := int64(0)
+	if e != nil {
+		var ok bool
+		if cur, ok = parseInt(e.value.(string)); !ok {
+			return errNotInteger
+		}
+	}
+
+	if (delta > 0 && cur > math.MaxInt64-delta) || (delta < 0 && cur < math.MinInt64-delta) {
+		return errOverflow
+	}
+	cur += delta
+
+	setString(cx, e, strconv.FormatInt(cur, 10))
+	return cur
+}
+
+func cmdIncrByFloat(s *Store, cx *call) interface{} {
+	delta, ok := parseFloat(cx.args[1])
+	if !ok {
+		return errNotFloat
+	}
+
+	e, err := cx.db.lookup(cx.args[0], "")
+	if err != nil {
+		return err
+	}
+
+	cur := 0.0
+	if e != nil {
+		if cur, ok = parseFloat(e.value.(string)); !ok {
+			return errNotFloat
+		}
+	}
+
+	cur += delta
+	if math.IsInf(cur, 0) || math.IsNaN(cur) {
+		return fred.Error("ERR increment would produce NaN or Infinity")
+	}
+
+	v := formatFloat(cur)
+	setString(cx, e, v)
+	return v
+}
+
+func cmdAppend(s *Store, cx *call) interface{} {
+	e, err := cx.db.lookup(cx.args[0], "")
+	if err != nil {
+		return err
+	}
+
+	v := cx.args[1]
+	if e != nil {
+		v = e.value.(string) + v
+	}
+	setString(cx, e, v)
+	return len(v)
+}
+
+func cmdStrlen(s *Store, cx *call) interface{} {
+	e, err := cx.db.lookup(cx.args[0], "")
+	if err != nil {
+		return err
+	} else if e == nil {
+		return 0
+	}
+	return len(e.value.(string))
+}
+
+// setString sets the string value of cx's key, keeping the expiry of its existing entry e, if any.
+func setString(cx *call, e *entry, v string) {
+	if e == nil {
+		cx.db.set(cx.args[0], v)
+		return
+	}
+	e.value = v
+	cx.db.modified(cx.args[0], e)
+}
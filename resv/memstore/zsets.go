@@ -0,0 +1,402 @@
+package memstore
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/resv"
+)
+
+// scored is a sorted set member and its score.
+type scored struct {
+	member string
+	score  float64
+}
+
+// sortedZSet returns the members of z ordered by score, then member.
+func sortedZSet(z zset) []scored {
+	members := make([]scored, 0, len(z))
+	for member, score := range z {
+		members = append(members, scored{member, score})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		a, b := members[i], members[j]
+		if a.score != b.score {
+			return a.score < b.score
+		}
+		return a.member < b.member
+	})
+	return members
+}
+
+// score returns a score reply: a double for RESP3 clients and a bulk string otherwise.
+func score(w resv.ResponseWriter, f float64) interface{} {
+	if resv.Protocol(w) >= 3 {
+		return f
+	}
+	return formatFloat(f)
+}
+
+// scoredReply returns the members of ms, followed by their scores if withScores is true.
+func scoredReply(w resv.ResponseWriter, ms []scored, withScores bool) interface{} {
+	if !withScores {
+		reply := make([]string, len(ms))
+		for i, m := range ms {
+			reply[i] = m.member
+		}
+		return reply
+	}
+
+	if resv.Protocol(w) >= 3 {
+		reply := make([]interface{}, len(ms))
+		for i, m := range ms {
+			reply[i] = []interface{}{m.member, m.score}
+		}
+		return reply
+	}
+
+	reply := make([]string, 0, len(ms)*2)
+	for _, m := range ms {
+		reply = append(reply, m.member, formatFloat(m.score))
+	}
+	return reply
+}
+
+// cmdZAdd implements ZADD key [NX|XX] [CH] [INCR] score member [score member ...].
+func cmdZAdd(s *Store, cx *call) interface{} {
+	var nx, xx, ch, incr bool
+	args := cx.args[1:]
+flags:
+	for len(args) > 0 {
+		switch strings.ToUpper(args[0]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "CH":
+			ch = true
+		case "INCR":
+			incr = true
+		default:
+			break flags
+		}
+		args = args[1:]
+	}
+
+	if len(args) == 0 || len(args)%2 != 0 {
+		return errSyntax
+	} else if nx && xx {
+		return fred.Error("ERR XX and NX options at the same time are not compatible")
+	} else if incr && len(args) != 2 {
+		return fred.Error("ERR INCR option supports a single increment-element pair")
+	}
+
+	scores := make([]float64, len(args)/2)
+	for i := range scores {
+		f, ok := parseFloat(args[i*2])
+		if !ok {
+			return errNotFloat
+		}
+		scores[i] = f
+	}
+
+	e, err := cx.db.lookup(cx.args[0], zset(nil))
+	if err != nil {
+		return err
+	} else if e == nil {
+		if xx {
+			if incr {
+				return nil
+			}
+			return 0
+		}
+		e = cx.db.set(cx.args[0], zset{})
+	}
+
+	z, added, changed := e.value.(zset), 0, 0
+	for i, f := range scores {
+		member := args[i*2+1]
+		old, exists := z[member]
+		if (nx && exists) || (xx && !exists) {
+			if incr {
+				cx.db.modified(cx.args[0], e)
+				return nil
+			}
+			continue
+		}
+
+		if incr {
+			f += old
+			if math.IsNaN(f) {
+				cx.db.modified(cx.args[0], e)
+				return fred.Error("ERR resulting score is not a number (NaN)")
+			}
+			z[member] = f
+			cx.db.modified(cx.args[0], e)
+			return score(cx.w, f)
+		}
+
+		if !exists {
+			added++
+		} else if old != f {
+			changed++
+		}
+		z[member] = f
+	}
+	cx.db.modified(cx.args[0], e)
+
+	if ch {
+		return added + changed
+	}
+	return added
+}
+
+func cmdZIncrBy(s *Store, cx *call) interface{} {
+	delta, ok := parseFloat(cx.args[1])
+	if !ok {
+		return errNotFloat
+	}
+
+	e, err := cx.db.lookup(cx.args[0], zset(nil))
+	if err != nil {
+		return err
+	} else if e == nil {
+		e = cx.db.set(cx.args[0], zset{})
+	}
+
+	z := e.value.(zset)
+	f := z[cx.args[2]] + delta
+	if math.IsNaN(f) {
+		cx.db.modified(cx.args[0], e)
+		return fred.Error("ERR resulting score is not a number (NaN)")
+	}
+	z[cx.args[2]] = f
+	cx.db.modified(cx.args[0], e)
+	return score(cx.w, f)
+}
+
+func cmdZRem(s *Store, cx *call) interface{} {
+	e, err := cx.db.lookup(cx.args[0], zset(nil))
+	if err != nil {
+		return err
+	} else if e == nil {
+		return 0
+	}
+
+	z, removed := e.value.(zset), 0
+	for _, member := range cx.args[1:] {
+		if _, ok := z[member]; ok {
+			delete(z, member)
+			removed++
+		}
+	}
+	if removed > 0 {
+		cx.db.modified(cx.args[0], e)
+	}
+	return removed
+}
+
+func cmdZScore(s *Store, cx *call) interface{} {
+	e, err := cx.db.lookup(cx.args[0], zset(nil))
+	if err != nil {
+		return err
+	} else if e == nil {
+		return nil
+	}
+
+	f, ok := e.value.(zset)[cx.args[1]]
+	if !ok {
+		return nil
+	}
+	return score(cx.w, f)
+}
+
+func cmdZCard(s *Store, cx *call) interface{} {
+	e, err := cx.db.lookup(cx.args[0], zset(nil))
+	if err != nil {
+		return err
+	} else if e == nil {
+		return 0
+	}
+	return len(e.value.(zset))
+}
+
+// cmdZRank implements ZRANK and ZREVRANK.
+func cmdZRank(s *Store, cx *call) interface{} {
+	e, err := cx.db.lookup(cx.args[0], zset(nil))
+	if err != nil {
+		return err
+	} else if e == nil {
+		return nil
+	}
+
+	ms := sortedZSet(e.value.(zset))
+	for i, m := range ms {
+		if m.member != cx.args[1] {
+			continue
+		}
+		if cx.name == "ZREVRANK" {
+			return len(ms) - 1 - i
+		}
+		return i
+	}
+	return nil
+}
+
+// cmdZRange implements ZRANGE and ZREVRANGE by index, with WITHSCORES.
+func cmdZRange(s *Store, cx *call) interface{} {
+	start, ok1 := parseInt(cx.args[1])
+	stop, ok2 := parseInt(cx.args[2])
+	if !ok1 || !ok2 {
+		return errNotInteger
+	}
+
+	withScores := false
+	if len(cx.args) == 4 && strings.EqualFold(cx.args[3], "WITHSCORES") {
+		withScores = true
+	} else if len(cx.args) > 3 {
+		return errSyntax
+	}
+
+	e, err := cx.db.lookup(cx.args[0], zset(nil))
+	if err != nil {
+		return err
+	} else if e == nil {
+		return []string{}
+	}
+
+	ms := sortedZSet(e.value.(zset))
+	if cx.name == "ZREVRANGE" {
+		reverseScored(ms)
+	}
+	i, j := listRange(start, stop, len(ms))
+	return scoredReply(cx.w, ms[i:j], withScores)
+}
+
+// scoreBound is a ZRANGEBYSCORE or ZCOUNT bound, such as 1, (1, or -inf.
+type scoreBound struct {
+	value     float64
+	exclusive bool
+}
+
+func parseScoreBound(s string) (scoreBound, bool) {
+	var b scoreBound
+	if strings.HasPrefix(s, "(") {
+		b.exclusive = true
+		s = s[1:]
+	}
+	f, ok := parseFloat(s)
+	b.value = f
+	return b, ok
+}
+
+// inRange reports whether f is within the bounds min and max.
+func inRange(f float64, min, max scoreBound) bool {
+	if f < min.value || (min.exclusive && f == min.value) {
+		return false
+	}
+	if f > max.value || (max.exclusive && f == max.value) {
+		return false
+	}
+	return true
+}
+
+// cmdZRangeByScore implements ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT offset count] and ZREVRANGEBYSCORE key max
+// min [...].
+func cmdZRangeByScore(s *Store, cx *call) interface{} {
+	minArg, maxArg := cx.args[1], cx.args[2]
+	rev := cx.name == "ZREVRANGEBYSCORE"
+	if rev {
+		minArg, maxArg = maxArg, minArg
+	}
+
+	min, ok1 := parseScoreBound(minArg)
+	max, ok2 := parseScoreBound(maxArg)
+	if !ok1 || !ok2 {
+		return errMinMaxFloat
+	}
+
+	var (
+		withScores    bool
+		offset, count int64 = 0, -1
+	)
+	for opts := cx.args[3:]; len(opts) > 0; opts = opts[1:] {
+		switch strings.ToUpper(opts[0]) {
+		case "WITHSCORES":
+			withScores = true
+		case "LIMIT":
+			if len(opts) < 3 {
+				return errSyntax
+			}
+			var ok bool
+			if offset, ok = parseInt(opts[1]); !ok {
+				return errNotInteger
+			}
+			if count, ok = parseInt(opts[2]); !ok {
+				return errNotInteger
+			}
+			opts = opts[2:]
+		default:
+			return errSyntax
+		}
+	}
+
+	e, err := cx.db.lookup(cx.args[0], zset(nil))
+	if err != nil {
+		return err
+	} else if e == nil {
+		return []string{}
+	}
+
+	ms := sortedZSet(e.value.(zset))
+	if rev {
+		reverseScored(ms)
+	}
+
+	var matched []scored
+	for _, m := range ms {
+		if !inRange(m.score, min, max) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		} else if offset < 0 || count == 0 {
+			break
+		}
+		matched = append(matched, m)
+		count--
+	}
+	return scoredReply(cx.w, matched, withScores)
+}
+
+func cmdZCount(s *Store, cx *call) interface{} {
+	min, ok1 := parseScoreBound(cx.args[1])
+	max, ok2 := parseScoreBound(cx.args[2])
+	if !ok1 || !ok2 {
+		return errMinMaxFloat
+	}
+
+	e, err := cx.db.lookup(cx.args[0], zset(nil))
+	if err != nil {
+		return err
+	} else if e == nil {
+		return 0
+	}
+
+	n := 0
+	for _, f := range e.value.(zset) {
+		if inRange(f, min, max) {
+			n++
+		}
+	}
+	return n
+}
+
+func reverseScored(ms []scored) {
+	for i, j := 0, len(ms)-1; i < j; i, j = i+1, j-1 {
+		ms[i], ms[j] = ms[j], ms[i]
+	}
+}
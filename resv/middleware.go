@@ -0,0 +1,114 @@
+package resv
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/nilium/fred"
+)
+
+// Middleware wraps a Handler to produce a new Handler. Middleware is used to compose logging, metrics, recovery, and
+// similar behavior around a Handler without the Handler knowing about it.
+type Middleware func(Handler) Handler
+
+// Chain composes the given middleware into a single Middleware. The first middleware is outermost, such that
+// Chain(a, b)(h) is equivalent to a(b(h)). Nil middleware are skipped.
+func Chain(mw ...Middleware) Middleware {
+	return func(h Handler) Handler {
+		for i := len(mw) - 1; i >= 0; i-- {
+			if mw[i] != nil {
+				h = mw[i](h)
+			}
+		}
+		return h
+	}
+}
+
+// LogRequests returns a Middleware that logs each command, its latency, and any error returned by the Handler to log.
+// If log is nil, BaseLogger is used.
+func LogRequests(log Logger) Middleware {
+	if log == nil {
+		log = BaseLogger
+	}
+
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r fred.Resp) error {
+			start := time.Now()
+			err := next.ServeRESP(w, r)
+			if err != nil {
+				log.Printf("%s (%v) error: %v", CommandName(r), time.Since(start), err)
+			} else {
+				log.Printf("%s (%v)", CommandName(r), time.Since(start))
+			}
+			return err
+		})
+	}
+}
+
+// LogSlow returns a Middleware that logs commands that take at least threshold to complete to log. If log is nil,
+// BaseLogger is used.
+func LogSlow(log Logger, threshold time.Duration) Middleware {
+	if log == nil {
+		log = BaseLogger
+	}
+
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r fred.Resp) error {
+			start := time.Now()
+			err := next.ServeRESP(w, r)
+			if dur := time.Since(start); dur >= threshold {
+				log.Printf("slow command %s (%v)", CommandName(r), dur)
+			}
+			return err
+		})
+	}
+}
+
+// Recover returns a Middleware that recovers from panics in the wrapped Handler. The panic and its stack trace are
+// logged to log and an error reply is written to the client if no reply has been written yet. If log is nil, BaseLogger
+// is used.
+func Recover(log Logger) Middleware {
+	if log == nil {
+		log = BaseLogger
+	}
+
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r fred.Resp) (err error) {
+			defer func() {
+				rc := recover()
+				if rc == nil {
+					return
+				}
+
+				var trace [20000]byte
+				sz := runtime.Stack(trace[:], false)
+				log.Printf("%s panicked: %v\ntrace:\n%s", CommandName(r), rc, trace[:sz])
+
+				// Ignore the error: if a reply was already written, there's nothing more to do.
+				_ = w.Write(fred.Error(fmt.Sprintf("ERR internal error while processing '%s'", CommandName(r))))
+				err = nil
+			}()
+			return next.ServeRESP(w, r)
+		})
+	}
+}
+
+// CommandName returns the upper-cased name of the command in r. If r is not a command, it returns an empty string.
+func CommandName(r fred.Resp) string {
+	if !r.IsType(fred.Array) {
+		return ""
+	}
+
+	args, err := r.Array()
+	if err != nil || len(args) == 0 {
+		return ""
+	}
+
+	name, err := args[0].Bytes()
+	if err != nil {
+		return ""
+	}
+	return string(bytes.ToUpper(name))
+}
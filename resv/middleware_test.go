@@ -0,0 +1,126 @@
+package resv
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nilium/fred"
+)
+
+type testLog struct {
+	lines []string
+}
+
+func (t *testLog) Printf(format string, args ...interface{}) {
+	t.lines = append(t.lines, fmt.Sprintf(format, args...))
+}
+
+func readCommand(t *testing.T, msg string) fred.Resp {
+	resp := fred.Read(bufio.NewReader(strings.NewReader(msg)))
+	if resp.Err != nil {
+		t.Fatal(resp.Err)
+	}
+	return resp
+}
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	mark := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(w ResponseWriter, r fred.Resp) error {
+				order = append(order, name)
+				return next.ServeRESP(w, r)
+			})
+		}
+	}
+
+	h := Chain(mark("a"), nil, mark("b"))(HandlerFunc(func(w ResponseWriter, r fred.Resp) error {
+		order = append(order, "h")
+		return w.Write("OK")
+	}))
+
+	var w bufferResponder
+	if err := h.ServeRESP(&w, readCommand(t, "*1\r\n$4\r\nPING\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(order, ","); got != "a,b,h" {
+		t.Errorf("order = %q; want %q", got, "a,b,h")
+	}
+}
+
+func TestRecover(t *testing.T) {
+	var log testLog
+	h := Recover(&log)(HandlerFunc(func(w ResponseWriter, r fred.Resp) error {
+		panic("boom")
+	}))
+
+	var w bufferResponder
+	if err := h.ServeRESP(&w, readCommand(t, "*1\r\n$3\r\nget\r\n")); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	if got, want := w.w.String(), "-ERR internal error while processing 'GET'\r\n"; got != want {
+		t.Errorf("reply = %q; want %q", got, want)
+	}
+
+	if len(log.lines) != 1 || !strings.HasPrefix(log.lines[0], "GET panicked: boom") {
+		t.Errorf("unexpected log output: %q", log.lines)
+	}
+}
+
+func TestLogRequests(t *testing.T) {
+	var log testLog
+	h := LogRequests(&log)(HandlerFunc(func(w ResponseWriter, r fred.Resp) error {
+		time.Sleep(2 * time.Millisecond)
+		if CommandName(r) == "FAIL" {
+			return errors.New("failed")
+		}
+		return w.Write("OK")
+	}))
+
+	for _, msg := range []string{"*1\r\n$3\r\nget\r\n", "*1\r\n$4\r\nfail\r\n"} {
+		var w bufferResponder
+		h.ServeRESP(&w, readCommand(t, msg))
+	}
+
+	if len(log.lines) != 2 {
+		t.Fatalf("unexpected log output: %q", log.lines)
+	}
+	for i, want := range []struct{ name, suffix string }{{"GET", ""}, {"FAIL", " error: failed"}} {
+		line := log.lines[i]
+		var dur time.Duration
+		lparen, rparen := strings.Index(line, " ("), strings.Index(line, ")")
+		if lparen >= 0 && rparen > lparen {
+			dur, _ = time.ParseDuration(line[lparen+2 : rparen])
+		}
+		if lparen < 0 || line[:lparen] != want.name || line[rparen+1:] != want.suffix || dur < 2*time.Millisecond {
+			t.Errorf("log line %d = %q; want %s with a duration of at least 2ms%s", i, line, want.name, want.suffix)
+		}
+	}
+}
+
+func TestLogSlow(t *testing.T) {
+	var log testLog
+	h := LogSlow(&log, time.Millisecond)(HandlerFunc(func(w ResponseWriter, r fred.Resp) error {
+		if CommandName(r) == "SLOW" {
+			time.Sleep(2 * time.Millisecond)
+		}
+		return w.Write("OK")
+	}))
+
+	for _, msg := range []string{"*1\r\n$4\r\nfast\r\n", "*1\r\n$4\r\nslow\r\n"} {
+		var w bufferResponder
+		if err := h.ServeRESP(&w, readCommand(t, msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(log.lines) != 1 || !strings.HasPrefix(log.lines[0], "slow command SLOW") {
+		t.Errorf("unexpected log output: %q", log.lines)
+	}
+}
@@ -0,0 +1,280 @@
+package resv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/nilium/fred"
+)
+
+// KeyVersioner reports the versions of keys for WATCH. A key's version must change whenever the key is modified,
+// including when it is deleted or expires.
+type KeyVersioner interface {
+	KeyVersion(key string) uint64
+}
+
+// TxConfig configures the Middleware returned by Transactions.
+type TxConfig struct {
+	// Lock, if not nil, is held while each command outside of a transaction is handled and while all commands of a
+	// transaction are handled by EXEC. This makes transactions atomic with respect to other clients' commands.
+	// Handlers wrapped by the middleware must not acquire Lock themselves.
+	//
+	// Since Lock is held around every command that isn't part of a transaction, a handler that blocks, such as one
+	// implementing BLPOP, stalls every other client until it returns. Blocking handlers must release Lock while they
+	// wait and reacquire it before returning, as memstore's blocking pops do.
+	Lock sync.Locker
+
+	// Versions, if not nil, is used to implement WATCH. If nil, WATCH replies with an error.
+	Versions KeyVersioner
+}
+
+var (
+	txQueued = SimpleString("QUEUED")
+	txOK     = SimpleString("OK")
+
+	errNestedMulti    = fred.Error("ERR MULTI calls can not be nested")
+	errExecNoMulti    = fred.Error("ERR EXEC without MULTI")
+	errDiscardNoMulti = fred.Error("ERR DISCARD without MULTI")
+	errWatchInMulti   = fred.Error("ERR WATCH inside MULTI is not allowed")
+	errExecAbort      = fred.Error("EXECABORT Transaction discarded because of previous errors.")
+	errNoTxClient     = fred.Error("ERR transactions require a client connection")
+)
+
+// txKey is the Client value key for a client's transaction state.
+type txKey struct{}
+
+// txState is the transaction state of a single client.
+type txState struct {
+	multi   bool
+	dirty   bool
+	queue   []fred.Resp
+	watched map[string]uint64
+}
+
+// Transactions returns a Middleware that implements MULTI, EXEC, DISCARD, WATCH, and UNWATCH. After MULTI, commands
+// are replied to with QUEUED and held until EXEC, which passes them to the wrapped Handler in order and replies with
+// an array of their replies. If a watched key's version changed before EXEC, the transaction is aborted and EXEC
+// replies with a null array.
+//
+// Transaction state is kept per Client, so the transaction commands require a Server's ResponseWriters. Without a
+// Client, they reply with an error, and other commands are passed to the wrapped Handler.
+func Transactions(cfg TxConfig) Middleware {
+	return func(next Handler) Handler {
+		return &txHandler{TxConfig: cfg, next: next}
+	}
+}
+
+type txHandler struct {
+	TxConfig
+	next Handler
+}
+
+func (t *txHandler) lock() {
+	if t.Lock != nil {
+		t.Lock.Lock()
+	}
+}
+
+func (t *txHandler) unlock() {
+	if t.Lock != nil {
+		t.Lock.Unlock()
+	}
+}
+
+func (t *txHandler) ServeRESP(w ResponseWriter, r fred.Resp) error {
+	name := CommandName(r)
+	c := ClientOf(w)
+	if c == nil {
+		switch name {
+		case "MULTI", "EXEC", "DISCARD", "WATCH", "UNWATCH":
+			return w.Write(errNoTxClient)
+		}
+		t.lock()
+		defer t.unlock()
+		return t.next.ServeRESP(w, r)
+	}
+
+	tx, _ := c.Value(txKey{}).(*txState)
+	if tx == nil {
+		tx = new(txState)
+		c.SetValue(txKey{}, tx)
+	}
+
+	switch name {
+	case "MULTI":
+		if tx.multi {
+			return w.Write(errNestedMulti)
+		}
+		tx.multi = true
+		return w.Write(txOK)
+
+	case "EXEC":
+		if !tx.multi {
+			return w.Write(errExecNoMulti)
+		}
+		return t.exec(w, tx)
+
+	case "DISCARD":
+		if !tx.multi {
+			return w.Write(errDiscardNoMulti)
+		}
+		tx.reset()
+		return w.Write(txOK)
+
+	case "WATCH":
+		if tx.multi {
+			return w.Write(errWatchInMulti)
+		}
+		return t.watch(w, tx, r)
+
+	case "UNWATCH":
+		if !tx.multi {
+			tx.watched = nil
+			return w.Write(txOK)
+		}
+	}
+
+	if tx.multi {
+		if name == "" {
+			tx.dirty = true
+			return w.Write(fred.Error("ERR invalid command"))
+		}
+		tx.queue = append(tx.queue, r)
+		return w.Write(txQueued)
+	}
+
+	t.lock()
+	defer t.unlock()
+	return t.next.ServeRESP(w, r)
+}
+
+func (tx *txState) reset() {
+	tx.multi = false
+	tx.dirty = false
+	tx.queue = nil
+	tx.watched = nil
+}
+
+func (t *txHandler) watch(w ResponseWriter, tx *txState, r fred.Resp) error {
+	if t.Versions == nil {
+		return w.Write(fred.Error("ERR WATCH is not supported"))
+	}
+
+	keys, err := r.StrList()
+	if err != nil || len(keys) < 2 {
+		return w.Write(fred.Error("ERR wrong number of arguments for 'watch' command"))
+	}
+
+	t.lock()
+	defer t.unlock()
+
+	if tx.watched == nil {
+		tx.watched = make(map[string]uint64, len(keys)-1)
+	}
+	for _, key := range keys[1:] {
+		if _, ok := tx.watched[key]; !ok {
+			tx.watched[key] = t.Versions.KeyVersion(key)
+		}
+	}
+	return w.Write(txOK)
+}
+
+func (t *txHandler) exec(w ResponseWriter, tx *txState) error {
+	defer tx.reset()
+
+	if tx.dirty {
+		return w.Write(errExecAbort)
+	}
+
+	t.lock()
+	defer t.unlock()
+
+	for key, version := range tx.watched {
+		if t.Versions.KeyVersion(key) != version {
+			return w.Write(NullArray(w))
+		}
+	}
+
+	var replies bytes.Buffer
+	fmt.Fprintf(&replies, "*%d\r\n", len(tx.queue))
+	for _, r := range tx.queue {
+		qw := queuedResponder{parent: w, proto: Protocol(w)}
+		if err := t.next.ServeRESP(&qw, r); err != nil {
+			// The commands before this one have been applied, so rather than hang up, reply to it with the error and
+			// go on, as Redis does for a command that fails at run time.
+			qw.w.Reset()
+			qw.written = false
+			if qw.Write(fmt.Errorf("SERVERERR %v", err)) != nil {
+				qw.Write(fred.Error("SERVERERR command failed"))
+			}
+		}
+		if !qw.written {
+			// Every command in the transaction needs a reply to keep the array consistent.
+			qw.Write(nil)
+		}
+		qw.w.WriteTo(&replies)
+	}
+
+	return w.Write(rawRESP(replies.Bytes()))
+}
+
+// NullArray returns a null array reply for the client w writes to. RESP2 distinguishes null arrays from null bulk
+// strings, which nil is encoded as, and some commands, such as EXEC and BLPOP, reply with null arrays.
+func NullArray(w ResponseWriter) interface{} {
+	if Protocol(w) >= 3 {
+		return nil
+	}
+	return rawRESP("*-1\r\n")
+}
+
+// queuedResponder captures the reply to a command run by EXEC.
+type queuedResponder struct {
+	parent  ResponseWriter
+	proto   int
+	w       bytes.Buffer
+	written bool
+}
+
+func (q *queuedResponder) Write(v interface{}) error {
+	if q.written || q.parent.Closed() {
+		return io.EOF
+	}
+
+	es := encoderState{w: &q.w, proto: q.proto}
+	if err := es.write(v); err != nil {
+		q.w.Reset()
+		return err
+	}
+	q.written = q.w.Len() > 0
+	return nil
+}
+
+func (q *queuedResponder) Close() {
+	q.parent.Close()
+}
+
+func (q *queuedResponder) Closed() bool {
+	return q.parent.Closed()
+}
+
+func (q *queuedResponder) Unwrap() ResponseWriter {
+	return q.parent
+}
+
+// InTransaction returns true if w is writing the reply to a command run by EXEC. Blocking commands should not block
+// inside a transaction.
+func InTransaction(w ResponseWriter) bool {
+	for w != nil {
+		switch cw := w.(type) {
+		case *queuedResponder:
+			return true
+		case interface{ Unwrap() ResponseWriter }:
+			w = cw.Unwrap()
+		default:
+			return false
+		}
+	}
+	return false
+}
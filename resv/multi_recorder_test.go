@@ -0,0 +1,46 @@
+package resv_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/resv"
+	"github.com/nilium/fred/resv/resvtest"
+)
+
+// heldLock is a sync.Locker that records whether it's held.
+type heldLock struct {
+	sync.Mutex
+	held bool
+}
+
+func (l *heldLock) Lock()   { l.Mutex.Lock(); l.held = true }
+func (l *heldLock) Unlock() { l.held = false; l.Mutex.Unlock() }
+
+func TestTransactionsWithoutClient(t *testing.T) {
+	lock := new(heldLock)
+	locked := false
+	h := resv.Transactions(resv.TxConfig{Lock: lock})(resv.HandlerFunc(func(w resv.ResponseWriter, r fred.Resp) error {
+		// The lock is held around commands outside of a transaction.
+		locked = lock.held
+		return w.Write(resv.SimpleString("PONG"))
+	}))
+
+	rec, err := resvtest.Serve(h, "PING")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resvtest.AssertReply(t, rec.Result(), "PONG")
+	if !locked || lock.held {
+		t.Errorf("lock held during handler = %v, after = %v; want true, false", locked, lock.held)
+	}
+
+	for _, cmd := range []string{"MULTI", "EXEC", "DISCARD", "UNWATCH"} {
+		rec, err := resvtest.Serve(h, cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resvtest.AssertReply(t, rec.Result(), fred.Error("ERR transactions require a client connection"))
+	}
+}
@@ -0,0 +1,97 @@
+package resv
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nilium/fred"
+)
+
+// versionedStore is a trivial key-value store for testing transactions.
+type versionedStore struct {
+	values   map[string]string
+	versions map[string]uint64
+}
+
+func (s *versionedStore) KeyVersion(key string) uint64 {
+	return s.versions[key]
+}
+
+func (s *versionedStore) ServeRESP(w ResponseWriter, r fred.Resp) error {
+	args, err := r.StrList()
+	if err != nil {
+		return err
+	}
+
+	switch CommandName(r) {
+	case "SET":
+		s.values[args[1]] = args[2]
+		s.versions[args[1]]++
+		return w.Write(SimpleString("OK"))
+	case "GET":
+		if v, ok := s.values[args[1]]; ok {
+			return w.Write(v)
+		}
+		return w.Write(nil)
+	case "FAIL":
+		return errors.New("handler failed")
+	}
+	return w.Write(fred.Error("ERR unknown command"))
+}
+
+func TestTransactions(t *testing.T) {
+	store := &versionedStore{values: map[string]string{}, versions: map[string]uint64{}}
+	srv := NewServer(Transactions(TxConfig{Lock: new(sync.Mutex), Versions: store})(store))
+	addr := startServer(t, srv)
+
+	conn, r := dialServer(t, addr)
+	other, or := dialServer(t, addr)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	other.SetReadDeadline(time.Now().Add(time.Second))
+
+	io.WriteString(conn, "*1\r\n$4\r\nEXEC\r\n")
+	expectRaw(t, r, "-ERR EXEC without MULTI\r\n")
+
+	io.WriteString(conn, "*1\r\n$5\r\nMULTI\r\n"+
+		"*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n"+
+		"*2\r\n$3\r\nGET\r\n$1\r\na\r\n"+
+		"*1\r\n$4\r\nNOPE\r\n")
+	expectRaw(t, r, "+OK\r\n+QUEUED\r\n+QUEUED\r\n+QUEUED\r\n")
+
+	// Not visible to other clients before EXEC.
+	io.WriteString(other, "*2\r\n$3\r\nGET\r\n$1\r\na\r\n")
+	expectRaw(t, or, "$-1\r\n")
+
+	io.WriteString(conn, "*1\r\n$4\r\nEXEC\r\n")
+	expectRaw(t, r, "*3\r\n+OK\r\n$1\r\n1\r\n-ERR unknown command\r\n")
+
+	// DISCARD drops queued commands.
+	io.WriteString(conn, "*1\r\n$5\r\nMULTI\r\n*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n2\r\n*1\r\n$7\r\nDISCARD\r\n")
+	expectRaw(t, r, "+OK\r\n+QUEUED\r\n+OK\r\n")
+	if store.values["a"] != "1" {
+		t.Errorf("a = %q; want %q", store.values["a"], "1")
+	}
+
+	// A watched key modified by another client aborts EXEC.
+	io.WriteString(conn, "*2\r\n$5\r\nWATCH\r\n$1\r\na\r\n*1\r\n$5\r\nMULTI\r\n*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n3\r\n")
+	expectRaw(t, r, "+OK\r\n+OK\r\n+QUEUED\r\n")
+
+	io.WriteString(other, "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n4\r\n")
+	expectRaw(t, or, "+OK\r\n")
+
+	io.WriteString(conn, "*1\r\n$4\r\nEXEC\r\n")
+	expectRaw(t, r, "*-1\r\n")
+	if store.values["a"] != "4" {
+		t.Errorf("a = %q; want %q", store.values["a"], "4")
+	}
+
+	// A handler's error is the reply to its command, and the rest of the transaction still runs.
+	io.WriteString(conn, "*1\r\n$5\r\nMULTI\r\n*1\r\n$4\r\nFAIL\r\n*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$1\r\n5\r\n*1\r\n$4\r\nEXEC\r\n")
+	expectRaw(t, r, "+OK\r\n+QUEUED\r\n+QUEUED\r\n*2\r\n-SERVERERR handler failed\r\n+OK\r\n")
+	if store.values["b"] != "5" {
+		t.Errorf("b = %q; want %q", store.values["b"], "5")
+	}
+}
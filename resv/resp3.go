@@ -0,0 +1,116 @@
+package resv
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/nilium/fred"
+)
+
+// MapEntry is a single key-value pair of a Map.
+type MapEntry struct {
+	Key, Value interface{}
+}
+
+// Map is an ordered map. It is encoded as a map for RESP3 clients and as a flat array of alternating keys and values
+// for RESP2 clients.
+type Map []MapEntry
+
+// Set is an unordered collection of values. It is encoded as a set for RESP3 clients and as an array for RESP2
+// clients.
+type Set []interface{}
+
+// Protocol returns the RESP protocol version negotiated by the client that w writes to: 2 or 3. If w has no client,
+// it returns 2.
+//
+// Replies do not normally need to check the protocol version: values written to a ResponseWriter are encoded for the
+// client's protocol, such that maps, sets, booleans, doubles, and nulls are downgraded for RESP2 clients.
+func Protocol(w ResponseWriter) int {
+	if c := ClientOf(w); c != nil {
+		return c.Protocol()
+	}
+	return 2
+}
+
+// HelloConfig configures the Handler returned by Server.HelloHandler.
+type HelloConfig struct {
+	// Server and Version are included in the HELLO reply. If Server is empty, "resv" is used.
+	Server  string
+	Version string
+
+	// Auth is called to authenticate a client when HELLO includes AUTH. It returns true if the username and password
+	// are valid. If Auth is nil, all attempts to authenticate fail.
+	Auth func(c *Client, username, password string) bool
+}
+
+// HelloHandler returns a Handler that replies to `HELLO [protover [AUTH username password] [SETNAME clientname]]`
+// commands. If protover is given, the client's protocol is switched to that version before the reply is written, so
+// the reply is encoded as a map for RESP3 clients.
+func (s *Server) HelloHandler(cfg HelloConfig) Handler {
+	if cfg.Server == "" {
+		cfg.Server = "resv"
+	}
+
+	return HandlerFunc(func(w ResponseWriter, r fred.Resp) error {
+		args, err := r.StrList()
+		if err != nil || len(args) == 0 {
+			return w.Write(fred.Error("ERR wrong number of arguments for 'hello' command"))
+		}
+		args = args[1:]
+
+		c := ClientOf(w)
+		if c == nil {
+			return w.Write(fred.Error("ERR HELLO requires a client connection"))
+		}
+
+		proto := c.Protocol()
+		if len(args) > 0 {
+			v, err := strconv.Atoi(args[0])
+			if err != nil {
+				return w.Write(fred.Error("ERR Protocol version is not an integer or out of range"))
+			} else if v != 2 && v != 3 {
+				return w.Write(fred.Error("NOPROTO unsupported protocol version"))
+			}
+			proto, args = v, args[1:]
+		}
+
+		var (
+			auth       bool
+			user, pass string
+			name       string
+			setName    bool
+		)
+		for len(args) > 0 {
+			switch opt := strings.ToUpper(args[0]); {
+			case opt == "AUTH" && len(args) >= 3:
+				auth, user, pass, args = true, args[1], args[2], args[3:]
+			case opt == "SETNAME" && len(args) >= 2:
+				setName, name, args = true, args[1], args[2:]
+			default:
+				return w.Write(fred.Error("ERR Syntax error in HELLO option '" + args[0] + "'"))
+			}
+		}
+
+		if auth && (cfg.Auth == nil || !cfg.Auth(c, user, pass)) {
+			return w.Write(fred.Error("WRONGPASS invalid username-password pair or user is disabled."))
+		}
+
+		if setName {
+			if !validClientName(name) {
+				return w.Write(fred.Error("ERR Client names cannot contain spaces, newlines or special characters."))
+			}
+			c.SetName(name)
+		}
+
+		c.setProtocol(proto)
+		return w.Write(Map{
+			{"server", cfg.Server},
+			{"version", cfg.Version},
+			{"proto", proto},
+			{"id", c.ID()},
+			{"mode", "standalone"},
+			{"role", "master"},
+			{"modules", []interface{}{}},
+		})
+	})
+}
@@ -0,0 +1,74 @@
+package resv
+
+import (
+	"bufio"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/nilium/fred"
+)
+
+func TestHello(t *testing.T) {
+	var srv *Server
+	srv = NewServer(HandlerFunc(func(w ResponseWriter, r fred.Resp) error {
+		switch CommandName(r) {
+		case "HELLO":
+			return srv.HelloHandler(HelloConfig{
+				Version: "1.0",
+				Auth: func(c *Client, user, pass string) bool {
+					return user == "default" && pass == "secret"
+				},
+			}).ServeRESP(w, r)
+		case "PROTO":
+			return w.Write(Protocol(w))
+		}
+		return w.Write(Map{{"null", nil}, {"ok", true}, {"pi", 3.25}})
+	}))
+	addr := startServer(t, srv)
+
+	conn, r := dialServer(t, addr)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	// RESP2 replies are downgraded.
+	io.WriteString(conn, "*1\r\n$3\r\nGET\r\n")
+	expectRaw(t, r, "*6\r\n$4\r\nnull\r\n$-1\r\n$2\r\nok\r\n:1\r\n$2\r\npi\r\n$4\r\n3.25\r\n")
+
+	io.WriteString(conn, "*4\r\n$5\r\nHELLO\r\n$1\r\n3\r\n$4\r\nAUTH\r\n$5\r\nwrong\r\n")
+	if resp := fred.Read(r); !resp.IsType(fred.Err) {
+		t.Fatalf("HELLO with bad AUTH = %#v; want error", resp)
+	}
+
+	io.WriteString(conn, "*2\r\n$5\r\nHELLO\r\n$1\r\n4\r\n")
+	if resp := fred.Read(r); !resp.IsType(fred.Err) || resp.Err.Error() != "NOPROTO unsupported protocol version" {
+		t.Fatalf("HELLO 4 = %#v; want NOPROTO", resp)
+	}
+
+	io.WriteString(conn, "*7\r\n$5\r\nHELLO\r\n$1\r\n3\r\n$4\r\nAUTH\r\n$7\r\ndefault\r\n$6\r\nsecret\r\n$7\r\nSETNAME\r\n$3\r\nfoo\r\n")
+	expectRaw(t, r, "%7\r\n"+
+		"$6\r\nserver\r\n$4\r\nresv\r\n"+
+		"$7\r\nversion\r\n$3\r\n1.0\r\n"+
+		"$5\r\nproto\r\n:3\r\n"+
+		"$2\r\nid\r\n:1\r\n"+
+		"$4\r\nmode\r\n$10\r\nstandalone\r\n"+
+		"$4\r\nrole\r\n$6\r\nmaster\r\n"+
+		"$7\r\nmodules\r\n*0\r\n")
+
+	io.WriteString(conn, "*1\r\n$5\r\nPROTO\r\n*1\r\n$3\r\nGET\r\n")
+	expectRaw(t, r, ":3\r\n%3\r\n$4\r\nnull\r\n_\r\n$2\r\nok\r\n#t\r\n$2\r\npi\r\n,3.25\r\n")
+
+	if c := srv.Client(1); c == nil || c.Name() != "foo" {
+		t.Errorf("expected client name to be set by HELLO")
+	}
+}
+
+func expectRaw(t *testing.T, r *bufio.Reader, want string) {
+	t.Helper()
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(r, got); err != nil {
+		t.Fatalf("error reading %q: %v (got %q)", want, err, got)
+	}
+	if string(got) != want {
+		t.Fatalf("read %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,262 @@
+// Package resvmetrics exports resv.Server metrics in the OpenMetrics text format, as scraped by Prometheus.
+//
+// A Collector reads connection, byte, and error counts from a resv.Server's Stats and records per-command latency
+// histograms through its Middleware, which must wrap the server's Handler:
+//
+//	srv := resv.NewServer(nil)
+//	metrics := resvmetrics.New(srv)
+//	srv.Handler = metrics.Middleware()(handler)
+//	http.Handle("/metrics", metrics)
+package resvmetrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/resv"
+)
+
+// ContentType is the content type of the OpenMetrics text format.
+const ContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// DefaultBuckets are the default upper bounds, in seconds, of command latency histogram buckets.
+var DefaultBuckets = []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+
+// maxCommands is the maximum number of distinct commands with their own histograms. Further commands are recorded
+// under otherCommand.
+const maxCommands = 1024
+
+const otherCommand = "OTHER"
+
+// Collector records command latencies and exposes them, along with a resv.Server's statistics, over HTTP.
+type Collector struct {
+	server  *resv.Server
+	buckets []float64
+
+	mu   sync.Mutex
+	cmds map[string]*histogram
+}
+
+var _ = http.Handler((*Collector)(nil))
+
+// New returns a Collector for srv. If no buckets are given, DefaultBuckets is used. Buckets must be sorted in
+// increasing order.
+func New(srv *resv.Server, buckets ...float64) *Collector {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	return &Collector{
+		server:  srv,
+		buckets: append([]float64(nil), buckets...),
+		cmds:    make(map[string]*histogram),
+	}
+}
+
+// Middleware returns a resv.Middleware that records the latency of each command in a histogram.
+func (c *Collector) Middleware() resv.Middleware {
+	return func(next resv.Handler) resv.Handler {
+		return resv.HandlerFunc(func(w resv.ResponseWriter, r fred.Resp) error {
+			start := time.Now()
+			err := next.ServeRESP(w, r)
+			c.observe(resv.CommandName(r), time.Since(start))
+			return err
+		})
+	}
+}
+
+func (c *Collector) observe(name string, dur time.Duration) {
+	if name == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h := c.cmds[name]
+	if h == nil {
+		if len(c.cmds) >= maxCommands {
+			name = otherCommand
+			h = c.cmds[name]
+		}
+		if h == nil {
+			h = &histogram{counts: make([]uint64, len(c.buckets))}
+			c.cmds[name] = h
+		}
+	}
+	h.observe(c.buckets, dur.Seconds())
+}
+
+// ServeHTTP writes the Collector's metrics in the OpenMetrics text format.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	// Errors here mean the client went away, so there's no one to report them to.
+	c.WriteTo(w)
+}
+
+// WriteTo writes the Collector's metrics to w in the OpenMetrics text format.
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	e := encoder{w: bw}
+
+	if c.server != nil {
+		st := c.server.Stats()
+
+		e.metric("resv_connected_clients", "gauge", "Number of open client connections.")
+		e.sample("resv_connected_clients", nil, float64(st.ConnectedClients))
+
+		e.counter("resv_connections", "Number of accepted connections.", float64(st.TotalConnections))
+		e.counter("resv_rejected_connections", "Number of connections rejected due to the connection limit.",
+			float64(st.RejectedConnections))
+		e.counter("resv_commands", "Number of commands processed.", float64(st.CommandsProcessed))
+		e.counter("resv_net_input_bytes", "Number of bytes read from clients.", float64(st.NetInputBytes))
+		e.counter("resv_net_output_bytes", "Number of bytes written to clients.", float64(st.NetOutputBytes))
+		e.counter("resv_panics", "Number of handler panics recovered.", float64(st.Panics))
+
+		e.metric("resv_error_replies", "counter", "Number of error replies by error code.")
+		for _, code := range sortedKeys(st.Errors) {
+			e.sample("resv_error_replies_total", []string{"code", code}, float64(st.Errors[code]))
+		}
+	}
+
+	c.mu.Lock()
+	names := make([]string, 0, len(c.cmds))
+	hists := make(map[string]histogram, len(c.cmds))
+	for name, h := range c.cmds {
+		names = append(names, name)
+		hists[name] = h.clone()
+	}
+	c.mu.Unlock()
+	sort.Strings(names)
+
+	e.metric("resv_command_duration_seconds", "histogram", "Command latency in seconds.")
+	for _, name := range names {
+		h := hists[name]
+		for i, le := range c.buckets {
+			e.sample("resv_command_duration_seconds_bucket", []string{"cmd", name, "le", formatFloat(le)},
+				float64(h.counts[i]))
+		}
+		e.sample("resv_command_duration_seconds_bucket", []string{"cmd", name, "le", "+Inf"}, float64(h.count))
+		e.sample("resv_command_duration_seconds_sum", []string{"cmd", name}, h.sum)
+		e.sample("resv_command_duration_seconds_count", []string{"cmd", name}, float64(h.count))
+	}
+
+	e.printf("# EOF\n")
+	if e.err == nil {
+		e.err = bw.Flush()
+	}
+	return cw.n, e.err
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// histogram is a cumulative latency histogram. Each count is the number of observations less than or equal to the
+// corresponding bucket bound.
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(buckets []float64, v float64) {
+	for i, le := range buckets {
+		if v <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) clone() histogram {
+	c := *h
+	c.counts = append([]uint64(nil), h.counts...)
+	return c
+}
+
+// OpenMetrics text encoding
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// encoder writes OpenMetrics text. The first error encountered is sticky and stops all further writes.
+type encoder struct {
+	w   io.Writer
+	err error
+}
+
+func (e *encoder) printf(format string, args ...interface{}) {
+	if e.err == nil {
+		_, e.err = fmt.Fprintf(e.w, format, args...)
+	}
+}
+
+func (e *encoder) metric(name, typ, help string) {
+	e.printf("# TYPE %s %s\n# HELP %s %s\n", name, typ, name, escapeHelp(help))
+}
+
+func (e *encoder) counter(name, help string, value float64) {
+	e.metric(name, "counter", help)
+	e.sample(name+"_total", nil, value)
+}
+
+// sample writes a sample. labels is a list of alternating label names and values.
+func (e *encoder) sample(name string, labels []string, value float64) {
+	if len(labels) == 0 {
+		e.printf("%s %s\n", name, formatFloat(value))
+		return
+	}
+
+	var b strings.Builder
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(labels[i])
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(labels[i+1]))
+		b.WriteByte('"')
+	}
+	e.printf("%s{%s} %s\n", name, b.String(), formatFloat(value))
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,48 @@
+package resvmetrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nilium/fred/resv"
+)
+
+func TestWriteTo(t *testing.T) {
+	c := New(resv.NewServer(nil), 0.001, 0.01)
+	c.observe("GET", 500*time.Microsecond)
+	c.observe("GET", 5*time.Millisecond)
+	c.observe("GET", time.Second)
+	c.observe(`SE"T`, time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Content-Type = %q; want %q", ct, ContentType)
+	}
+
+	body := rec.Body.String()
+	t.Logf("%s", body)
+
+	for _, want := range []string{
+		"# TYPE resv_connected_clients gauge\n",
+		"\nresv_connected_clients 0\n",
+		"\nresv_connections_total 0\n",
+		"\n# TYPE resv_command_duration_seconds histogram\n",
+		"\nresv_command_duration_seconds_bucket{cmd=\"GET\",le=\"0.001\"} 1\n",
+		"\nresv_command_duration_seconds_bucket{cmd=\"GET\",le=\"0.01\"} 2\n",
+		"\nresv_command_duration_seconds_bucket{cmd=\"GET\",le=\"+Inf\"} 3\n",
+		"\nresv_command_duration_seconds_count{cmd=\"GET\"} 3\n",
+		"\nresv_command_duration_seconds_count{cmd=\"SE\\\"T\"} 1\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("output does not contain %q", want)
+		}
+	}
+
+	if !strings.HasSuffix(body, "\n# EOF\n") {
+		t.Error("output does not end with # EOF")
+	}
+}
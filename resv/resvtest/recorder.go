@@ -0,0 +1,132 @@
+package resvtest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/resv"
+)
+
+// ResponseRecorder is a resv.ResponseWriter that records the reply written to it. The zero value is ready to use.
+//
+// A ResponseRecorder has no client, so replies are encoded as RESP2 and handlers that use resv.ClientOf see nil.
+type ResponseRecorder struct {
+	// Body holds the encoded reply.
+	Body bytes.Buffer
+	// Value is the value passed to Write, before encoding.
+	Value interface{}
+
+	written bool
+	closed  bool
+}
+
+var _ = resv.ResponseWriter((*ResponseRecorder)(nil))
+
+// NewRecorder returns an initialized ResponseRecorder.
+func NewRecorder() *ResponseRecorder {
+	return new(ResponseRecorder)
+}
+
+// Write encodes v as the reply. Like the resv.Server's ResponseWriter, it returns io.EOF if a reply has already been
+// written or the recorder is closed.
+func (r *ResponseRecorder) Write(v interface{}) error {
+	if r.closed || r.written {
+		return io.EOF
+	}
+
+	p, err := resv.MarshalRESP(v)
+	if err != nil {
+		return err
+	}
+	r.Body.Write(p)
+	r.Value = v
+	r.written = len(p) > 0
+	return nil
+}
+
+// Close marks the recorder closed, as if the handler had closed the connection.
+func (r *ResponseRecorder) Close() {
+	r.closed = true
+}
+
+// Closed returns true if Close was called.
+func (r *ResponseRecorder) Closed() bool {
+	return r.closed
+}
+
+// Written returns true if a reply was written.
+func (r *ResponseRecorder) Written() bool {
+	return r.written
+}
+
+// Result parses the recorded reply. If no reply was written, the returned Resp's Err is io.ErrUnexpectedEOF.
+func (r *ResponseRecorder) Result() fred.Resp {
+	return fred.Read(bufio.NewReader(bytes.NewReader(r.Body.Bytes())))
+}
+
+// Command returns a command as a server receives it: an array of bulk strings. It is intended for passing to a
+// Handler's ServeRESP along with a ResponseRecorder.
+func Command(args ...string) fred.Resp {
+	p, err := resv.MarshalRESP(args)
+	if err != nil {
+		panic("resvtest: cannot encode command: " + err.Error())
+	}
+	return fred.Read(bufio.NewReader(bytes.NewReader(p)))
+}
+
+// Serve calls handler with the command given by args and returns the recorder holding its reply.
+func Serve(handler resv.Handler, args ...string) (*ResponseRecorder, error) {
+	rec := NewRecorder()
+	err := handler.ServeRESP(rec, Command(args...))
+	return rec, err
+}
+
+// AssertReply fails t if got is not the reply want. want is encoded as a resv.Server would encode it, so it may be a
+// string, integer, nil, slice, fred.Error, or any other value the server can write. Simple and bulk strings compare
+// equal, as do null bulk strings and null arrays.
+func AssertReply(t testing.TB, got fred.Resp, want interface{}) {
+	t.Helper()
+	if err := compareReply(got, want); err != nil {
+		t.Error(err)
+	}
+}
+
+func compareReply(got fred.Resp, want interface{}) error {
+	p, err := resv.MarshalRESP(want)
+	if err != nil {
+		return fmt.Errorf("cannot encode wanted reply %#v: %v", want, err)
+	}
+	wantResp := fred.Read(bufio.NewReader(bytes.NewReader(p)))
+
+	gv, err := replyValue(got)
+	if err != nil {
+		return fmt.Errorf("error reading reply: %v", err)
+	}
+	wv, err := replyValue(wantResp)
+	if err != nil {
+		return fmt.Errorf("cannot decode wanted reply %#v: %v", want, err)
+	}
+
+	if !reflect.DeepEqual(gv, wv) {
+		return fmt.Errorf("reply = %#v; want %#v", gv, wv)
+	}
+	return nil
+}
+
+// replyValue returns the Go value of a reply. Error replies are returned as their fred.Error.
+func replyValue(r fred.Resp) (interface{}, error) {
+	if isErrorReply(r) {
+		return r.Err, nil
+	}
+	return r.Value()
+}
+
+func isErrorReply(r fred.Resp) bool {
+	_, ok := r.Err.(fred.Error)
+	return ok && r.IsType(fred.Err)
+}
@@ -0,0 +1,109 @@
+package resvtest
+
+import (
+	"testing"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/resv"
+)
+
+var echo = resv.HandlerFunc(func(w resv.ResponseWriter, r fred.Resp) error {
+	args, err := r.StrList()
+	if err != nil {
+		return err
+	}
+
+	switch resv.CommandName(r) {
+	case "ECHO":
+		return w.Write(args[1:])
+	case "ID":
+		if c := resv.ClientOf(w); c != nil {
+			return w.Write(c.ID())
+		}
+		return w.Write(nil)
+	case "NULLARRAY":
+		return w.Write(resv.NullArray(w))
+	}
+	return w.Write(fred.Error("ERR unknown command"))
+})
+
+func TestRecorder(t *testing.T) {
+	rec, err := Serve(echo, "ECHO", "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rec.Body.String(), "*2\r\n$1\r\na\r\n$1\r\nb\r\n"; got != want {
+		t.Errorf("Body = %q; want %q", got, want)
+	}
+	AssertReply(t, rec.Result(), []string{"a", "b"})
+
+	if err := rec.Write("again"); err == nil {
+		t.Error("second Write succeeded; want an error")
+	}
+
+	rec, _ = Serve(echo, "NOPE")
+	AssertReply(t, rec.Result(), fred.Error("ERR unknown command"))
+
+	if rec := NewRecorder(); rec.Written() || rec.Result().Err == nil {
+		t.Error("empty recorder has a reply")
+	}
+}
+
+func TestCompareReply(t *testing.T) {
+	cases := []struct {
+		reply string
+		want  interface{}
+		ok    bool
+	}{
+		{"+OK\r\n", "OK", true},
+		{"$2\r\nOK\r\n", resv.SimpleString("OK"), true},
+		{":1\r\n", 1, true},
+		{":1\r\n", "1", false},
+		{"$-1\r\n", nil, true},
+		{"*-1\r\n", nil, true},
+		{"*2\r\n:1\r\n$1\r\nx\r\n", []interface{}{1, "x"}, true},
+		{"*0\r\n", []string{}, true},
+		{"-ERR x\r\n", fred.Error("ERR x"), true},
+		{"-ERR x\r\n", fred.Error("ERR y"), false},
+	}
+
+	for _, c := range cases {
+		rec := NewRecorder()
+		rec.Body.WriteString(c.reply)
+		if err := compareReply(rec.Result(), c.want); (err == nil) != c.ok {
+			t.Errorf("compareReply(%q, %#v) = %v; want ok = %t", c.reply, c.want, err, c.ok)
+		}
+	}
+}
+
+func testServer(t *testing.T, srv *Server) {
+	defer srv.Close()
+
+	c := srv.Conn(t)
+	c.Expect(t, []string{"hello"}, "ECHO", "hello")
+	c.Expect(t, 1, "ID")
+	c.Expect(t, nil, "NULLARRAY")
+
+	if err := c.Send("ECHO", "x"); err != nil {
+		t.Fatal(err)
+	}
+	AssertReply(t, c.Receive(), []string{"x"})
+
+	srv.Conn(t).Expect(t, 2, "ID")
+}
+
+func TestServer(t *testing.T) {
+	testServer(t, NewServer(echo))
+}
+
+func TestPipeServer(t *testing.T) {
+	srv := NewPipeServer(echo)
+	if srv.Addr != "pipe" {
+		t.Errorf("Addr = %q; want %q", srv.Addr, "pipe")
+	}
+	testServer(t, srv)
+
+	if _, err := srv.Dial(); err == nil {
+		t.Error("Dial succeeded after Close")
+	}
+}
@@ -0,0 +1,222 @@
+// Package resvtest provides utilities for testing resv Handlers, in the spirit of net/http/httptest.
+//
+// A Server runs a Handler on a loopback or in-memory listener for tests that need a real connection, such as tests of
+// blocking commands or per-client state. A ResponseRecorder captures the reply to a single command for tests that call
+// a Handler directly.
+package resvtest
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/nilium/fred"
+	"github.com/nilium/fred/resv"
+)
+
+// Server is a resv.Server listening on a loopback address or an in-memory listener.
+type Server struct {
+	// Addr is the address of the listener, such as "127.0.0.1:51234" or "pipe".
+	Addr string
+
+	Listener net.Listener
+	// Server is the underlying resv.Server. It may be configured between NewUnstartedServer and Start.
+	Server *resv.Server
+
+	pipe *pipeListener
+	done chan struct{}
+}
+
+// NewServer starts and returns a Server running handler on a loopback TCP address. The caller should call Close when
+// finished.
+func NewServer(handler resv.Handler) *Server {
+	s := NewUnstartedServer(handler)
+	s.Start()
+	return s
+}
+
+// NewPipeServer starts and returns a Server running handler on an in-memory listener. Connections to it are made with
+// net.Pipe and must be made through Dial or Conn. The caller should call Close when finished.
+func NewPipeServer(handler resv.Handler) *Server {
+	s := NewUnstartedServer(handler)
+	s.StartPipe()
+	return s
+}
+
+// NewUnstartedServer returns a Server running handler that hasn't been started, so that its resv.Server may be
+// configured. The caller should call Start or StartPipe and then Close.
+func NewUnstartedServer(handler resv.Handler) *Server {
+	srv := resv.NewServer(handler)
+	srv.ErrorLog = resv.NullLogger
+	return &Server{Server: srv}
+}
+
+// Start starts the server on a loopback TCP address.
+func (s *Server) Start() {
+	if s.done != nil {
+		panic("resvtest: Server already started")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		if l, err = net.Listen("tcp6", "[::1]:0"); err != nil {
+			panic("resvtest: failed to listen on a port: " + err.Error())
+		}
+	}
+	s.serve(l)
+}
+
+// StartPipe starts the server on an in-memory listener.
+func (s *Server) StartPipe() {
+	if s.done != nil {
+		panic("resvtest: Server already started")
+	}
+
+	s.pipe = newPipeListener()
+	s.serve(s.pipe)
+}
+
+func (s *Server) serve(l net.Listener) {
+	s.Listener = l
+	s.Addr = l.Addr().String()
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		s.Server.Serve(l)
+	}()
+}
+
+// Close stops the server and waits for it to exit. Open connections are closed.
+func (s *Server) Close() {
+	if s.done == nil {
+		return
+	}
+	s.Server.Close()
+	<-s.done
+}
+
+// Dial opens a new connection to the server.
+func (s *Server) Dial() (net.Conn, error) {
+	if s.pipe != nil {
+		return s.pipe.dial()
+	}
+	return net.Dial(s.Listener.Addr().Network(), s.Addr)
+}
+
+// Conn opens a new connection to the server. If t is not nil, the connection is closed when the test completes and a
+// failure to connect ends the test. Otherwise, Conn panics if it fails to connect.
+func (s *Server) Conn(t testing.TB) *Conn {
+	if t != nil {
+		t.Helper()
+	}
+
+	nc, err := s.Dial()
+	if err != nil {
+		if t == nil {
+			panic("resvtest: failed to connect: " + err.Error())
+		}
+		t.Fatal("resvtest: failed to connect:", err)
+	}
+
+	if t != nil {
+		t.Cleanup(func() { nc.Close() })
+	}
+	return NewConn(nc)
+}
+
+// Conn is a client connection to a server.
+type Conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// NewConn returns a Conn that sends commands to and reads replies from nc.
+func NewConn(nc net.Conn) *Conn {
+	return &Conn{Conn: nc, r: bufio.NewReader(nc)}
+}
+
+// Send writes a command without reading its reply. Its arguments are encoded as an array of bulk strings.
+func (c *Conn) Send(args ...string) error {
+	p, err := resv.MarshalRESP(args)
+	if err != nil {
+		return err
+	}
+	_, err = c.Write(p)
+	return err
+}
+
+// Receive reads a single reply. If the reply is an error reply, the returned Resp's Err is a fred.Error.
+func (c *Conn) Receive() fred.Resp {
+	return fred.Read(c.r)
+}
+
+// Do sends a command and reads its reply.
+func (c *Conn) Do(args ...string) fred.Resp {
+	if err := c.Send(args...); err != nil {
+		return fred.Resp{Err: err}
+	}
+	return c.Receive()
+}
+
+// Expect sends a command and fails t if its reply isn't want, as compared by AssertReply.
+func (c *Conn) Expect(t testing.TB, want interface{}, args ...string) {
+	t.Helper()
+	AssertReply(t, c.Do(args...), want)
+}
+
+// In-memory listener
+
+// errListenerClosed is returned by a pipeListener after it's closed.
+var errListenerClosed = errors.New("resvtest: listener closed")
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// pipeListener is a net.Listener whose connections are created with net.Pipe.
+type pipeListener struct {
+	conns chan net.Conn
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+func (l *pipeListener) dial() (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+		client.Close()
+		server.Close()
+		return nil, errListenerClosed
+	}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, errListenerClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
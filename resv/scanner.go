@@ -15,12 +15,39 @@ type scanner struct {
 	last    byte
 	lastErr error
 	op      int
+	n       int64 // total bytes read from r
 
 	r io.Reader
+	// ahead holds bytes read from r by readAhead that haven't been read from the scanner yet.
+	ahead []byte
+}
+
+// maxReadAhead is the most that readAhead buffers.
+const maxReadAhead = 64 << 10
+
+// readAhead reads from r into the scanner's buffer without consuming anything, so that the bytes are returned by later
+// reads. It returns io.ErrShortBuffer if maxReadAhead bytes are already buffered.
+func (s *scanner) readAhead() error {
+	if len(s.ahead) >= maxReadAhead {
+		return io.ErrShortBuffer
+	}
+	var b [512]byte
+	n, err := s.r.Read(b[:])
+	s.ahead = append(s.ahead, b[:n]...)
+	return err
 }
 
 func (s *scanner) Read(o []byte) (n int, err error) {
-	n, err = s.r.Read(o)
+	if len(s.ahead) > 0 {
+		n = copy(o, s.ahead)
+		s.ahead = s.ahead[n:]
+		if len(s.ahead) == 0 {
+			s.ahead = nil
+		}
+	} else {
+		n, err = s.r.Read(o)
+	}
+	s.n += int64(n)
 	if n > 0 {
 		s.last = o[n-1]
 		s.lastErr = err
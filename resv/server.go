@@ -2,10 +2,13 @@ package resv
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nilium/fred"
@@ -24,15 +27,38 @@ func (f HandlerFunc) ServeRESP(w ResponseWriter, r fred.Resp) error {
 // Server
 
 type Server struct {
+	// stats is the first field to keep its counters 64-bit aligned for atomic access.
+	stats serverStats
+
 	Handler  Handler
 	ErrorLog Logger
 
-	ReadTimeout  time.Duration
+	// ReadTimeout is the maximum duration for reading a command once its first byte has been received.
+	ReadTimeout time.Duration
+	// IdleTimeout is the maximum duration to wait for the next command on a connection. If zero, idle connections are
+	// never closed.
+	IdleTimeout  time.Duration
 	WriteTimeout time.Duration
 
+	// MaxConns is the maximum number of connections the server will handle at once. Connections accepted over this
+	// limit are sent an error and closed. If zero, there is no limit.
+	MaxConns int
+
+	// Hook, if not nil, is called before and after the Handler for each command.
+	Hook Hook
+
+	// KeepAlive is the period between TCP keep-alive probes on accepted TCP connections. If zero, the connection's
+	// keep-alive settings are left unchanged. If negative, keep-alives are disabled.
+	KeepAlive time.Duration
+
 	stopped     chan struct{}
 	stoppedOnce sync.Once
 	openConns   sync.WaitGroup
+
+	// serveMu orders Serve's addition to openConns before Close waits on it.
+	serveMu sync.Mutex
+
+	clients clientRegistry
 }
 
 func NewServer(handler Handler) *Server {
@@ -40,6 +66,7 @@ func NewServer(handler Handler) *Server {
 		Handler: handler,
 
 		ReadTimeout:  time.Second * 15,
+		IdleTimeout:  0,
 		WriteTimeout: 0,
 
 		stopped: make(chan struct{}),
@@ -52,8 +79,15 @@ func (s *Server) log(format string, args ...interface{}) {
 	}
 }
 
+// Panics returns the number of panics recovered from the Server's Handler since the Server was created.
+func (s *Server) Panics() uint64 {
+	return atomic.LoadUint64(&s.stats.panics)
+}
+
 func (s *Server) Close() {
+	s.serveMu.Lock()
 	s.stoppedOnce.Do(func() { close(s.stopped) })
+	s.serveMu.Unlock()
 	s.openConns.Wait()
 }
 
@@ -62,6 +96,19 @@ func (s *Server) Serve(l net.Listener) error {
 	l = newInterruptListener(l)
 	defer s.log("Stopping server listening on %v", addr)
 
+	// Count Serve as an open connection so that Close waits for it to stop accepting connections.
+	s.serveMu.Lock()
+	select {
+	case <-s.stopped:
+		s.serveMu.Unlock()
+		l.Close()
+		return nil
+	default:
+	}
+	s.openConns.Add(1)
+	s.serveMu.Unlock()
+	defer s.openConns.Done()
+
 	go func(l net.Listener) {
 		<-s.stopped
 		if err := l.Close(); err != nil {
@@ -92,6 +139,18 @@ loop:
 			continue
 		}
 
+		if s.MaxConns > 0 && atomic.LoadInt64(&s.stats.active) >= int64(s.MaxConns) {
+			// Rejected in the background so that a client that doesn't read can't hold up accepting others. It's
+			// counted as an open connection so that Close waits for it.
+			s.openConns.Add(1)
+			go s.reject(conn, errMaxClients)
+			continue
+		}
+
+		s.setKeepAlive(conn)
+
+		atomic.AddUint64(&s.stats.accepted, 1)
+		atomic.AddInt64(&s.stats.active, 1)
 		s.openConns.Add(1)
 		go s.handleConn(conn)
 	}
@@ -99,27 +158,128 @@ loop:
 	return nil
 }
 
+var errMaxClients = fred.Error("ERR max number of clients reached")
+
+// rejectTimeout is the time allowed to write an error to a rejected connection.
+const rejectTimeout = time.Second
+
+// reject writes reason to conn and closes it. The caller must have added conn to openConns.
+func (s *Server) reject(conn net.Conn, reason error) {
+	s.log("%v: Rejecting connection: %v", conn.RemoteAddr(), reason)
+	atomic.AddUint64(&s.stats.rejected, 1)
+	defer func() {
+		if err := conn.Close(); err != nil {
+			s.log("error closing conn: %v", err)
+		}
+		s.openConns.Done()
+	}()
+
+	msg, err := MarshalRESP(reason)
+	if err != nil {
+		s.log("Error marshaling rejection: %v", err)
+		return
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(rejectTimeout))
+	if _, err := conn.Write(msg); err != nil {
+		s.log("%v: Write error: %v", conn.RemoteAddr(), err)
+	}
+}
+
+func (s *Server) setKeepAlive(conn net.Conn) {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok || s.KeepAlive == 0 {
+		return
+	}
+
+	if s.KeepAlive < 0 {
+		if err := tc.SetKeepAlive(false); err != nil {
+			s.log("%v: Error disabling keep-alive: %v", conn.RemoteAddr(), err)
+		}
+		return
+	}
+
+	if err := tc.SetKeepAlive(true); err != nil {
+		s.log("%v: Error enabling keep-alive: %v", conn.RemoteAddr(), err)
+	} else if err := tc.SetKeepAlivePeriod(s.KeepAlive); err != nil {
+		s.log("%v: Error setting keep-alive period: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// errClientKilled is returned by awaitCommand if the client was killed.
+var errClientKilled = errors.New("client killed")
+
+// awaitCommand blocks until the first byte of the next command is available from r or the idle timeout elapses. It
+// returns an error if the connection should be closed.
+func (s *Server) awaitCommand(conn net.Conn, c *Client, r *scanner) error {
+	c.setState(StateIdle)
+
+	var idead time.Time
+	if s.IdleTimeout > 0 {
+		idead = time.Now().Add(s.IdleTimeout)
+	}
+	conn.SetReadDeadline(idead)
+
+	// Check for a stop or kill after setting the deadline: if either happens after this, the read will be
+	// interrupted.
+	select {
+	case <-s.stopped:
+		return ListenerClosedErr{}
+	case <-c.killed:
+		return errClientKilled
+	default:
+	}
+
+	if _, err := r.ReadByte(); err != nil {
+		if c.isKilled() {
+			return errClientKilled
+		}
+		return err
+	}
+	c.setState(StateReading)
+	return r.UnreadByte()
+}
+
 func (s *Server) handleConn(conn net.Conn) {
 	addr := conn.RemoteAddr()
 	s.log("%v: Connection received", addr)
+	client := s.clients.add(conn)
+	done := make(chan struct{})
 	defer func() {
+		close(done)
+		s.clients.remove(client)
+		client.Kill()
 		if err := conn.Close(); err != nil {
 			s.log("error closing conn: %v", err)
 		}
+		atomic.AddInt64(&s.stats.active, -1)
 		s.openConns.Done()
 	}()
 
+	// Interrupt idle reads and blocked commands when the server stops.
+	go func() {
+		select {
+		case <-s.stopped:
+			client.Kill()
+		case <-done:
+		}
+	}()
+
 	r := &scanner{r: conn}
-	w := bufferResponder{}
+	client.r = r
+	w := bufferResponder{client: client}
 
 	for {
 		w.written = false
 		w.w.Reset()
 
-		select {
-		case <-s.stopped:
+		if err := s.awaitCommand(conn, client, r); err != nil {
+			if err == errClientKilled {
+				s.log("%v: Client killed", addr)
+			} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				s.log("%v: Closing idle connection", addr)
+			}
 			return
-		default:
 		}
 
 		var rdead, wdead time.Time
@@ -135,18 +295,20 @@ func (s *Server) handleConn(conn net.Conn) {
 		conn.SetWriteDeadline(wdead)
 
 		resp := fred.Read(r)
+		atomic.AddUint64(&s.stats.bytesIn, uint64(r.n))
+		r.n = 0
+
 		if resp.Err != nil {
 			if ne, ok := resp.Err.(net.Error); ok {
-				temp := ne.Temporary()
-				if !ne.Timeout() {
-					w.Write(fmt.Errorf("CONNERR %v", resp.Err))
-					if temp {
-						goto writeResp
-					}
+				// A timeout here means a command was only partially read, so the connection can't be recovered.
+				if ne.Timeout() {
+					s.log("%v: Timed out reading command", addr)
+					return
 				}
 
-				if temp {
-					continue
+				w.Write(fmt.Errorf("CONNERR %v", resp.Err))
+				if ne.Temporary() {
+					goto writeResp
 				}
 
 				return
@@ -157,7 +319,9 @@ func (s *Server) handleConn(conn net.Conn) {
 			return
 		}
 
-		if err := s.Handler.ServeRESP(&w, resp); err != nil {
+		client.setCommand(CommandName(resp))
+
+		if err := s.serveRESP(&w, resp); err != nil {
 			w.w.Reset()
 			w.written = false
 
@@ -170,7 +334,7 @@ func (s *Server) handleConn(conn net.Conn) {
 		}
 
 	writeResp:
-		if _, err := w.w.WriteTo(conn); err != nil {
+		if err := s.writeReply(conn, &w); err != nil {
 			if ne, ok := resp.Err.(net.Error); ok {
 				if !ne.Timeout() {
 					s.log("Write error: %v", resp.Err)
@@ -182,12 +346,57 @@ func (s *Server) handleConn(conn net.Conn) {
 			}
 		}
 
-		if w.Closed() {
+		if w.Closed() || client.isKilled() {
 			return
 		}
 	}
 }
 
+// errHandlerPanic is returned by serveRESP when the Handler panics.
+var errHandlerPanic = errors.New("handler panicked")
+
+// serveRESP passes resp to the Server's Handler. If the Handler panics, the panic is recovered, logged along with its
+// stack trace, and errHandlerPanic is returned so that the connection is hung up on.
+func (s *Server) serveRESP(w ResponseWriter, resp fred.Resp) (err error) {
+	start := time.Now()
+	var info *CommandInfo
+	if s.Hook != nil {
+		info = newCommandInfo(resp, start)
+		s.Hook.BeforeCommand(info)
+	}
+	defer func() {
+		s.stats.command(CommandName(resp), time.Since(start))
+		if info != nil {
+			var msg []byte
+			if bw, ok := w.(*bufferResponder); ok {
+				msg = bw.w.Bytes()
+			}
+			info.finish(msg, err)
+			s.Hook.AfterCommand(info)
+		}
+	}()
+
+	defer func() {
+		if rc := recover(); rc != nil {
+			atomic.AddUint64(&s.stats.panics, 1)
+
+			var trace [20000]byte
+			sz := runtime.Stack(trace[:], false)
+			s.log("%T.ServeRESP panicked: %v\ntrace:\n%s", s.Handler, rc, trace[:sz])
+			err = errHandlerPanic
+		}
+	}()
+	return s.Handler.ServeRESP(w, resp)
+}
+
+// writeReply writes the reply buffered in w to conn.
+func (s *Server) writeReply(conn net.Conn, w *bufferResponder) error {
+	s.stats.reply(w.w.Bytes())
+	n, err := w.w.WriteTo(conn)
+	atomic.AddUint64(&s.stats.bytesOut, uint64(n))
+	return err
+}
+
 // Response writer
 
 type ResponseWriter interface {
@@ -201,6 +410,8 @@ type bufferResponder struct {
 	w       bytes.Buffer
 	written bool
 	closed  bool
+
+	client *Client
 }
 
 func (n *bufferResponder) Write(v interface{}) (err error) {
@@ -209,6 +420,9 @@ func (n *bufferResponder) Write(v interface{}) (err error) {
 	}
 
 	es := encoderState{w: &n.w}
+	if n.client != nil {
+		es.proto = n.client.Protocol()
+	}
 	if err := es.write(v); err != nil {
 		n.w.Reset()
 		return err
@@ -0,0 +1,262 @@
+package resv
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nilium/fred"
+)
+
+// startServer starts srv on a loopback listener and returns its address. The server is closed when the test ends.
+func startServer(t *testing.T, srv *Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.Serve(l)
+	}()
+
+	t.Cleanup(func() {
+		srv.Close()
+		<-done
+	})
+
+	return l.Addr().String()
+}
+
+func dialServer(t *testing.T, addr string) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, bufio.NewReader(conn)
+}
+
+func TestServerRecoversPanic(t *testing.T) {
+	srv := NewServer(HandlerFunc(func(w ResponseWriter, r fred.Resp) error {
+		if CommandName(r) == "PANIC" {
+			panic("boom")
+		}
+		return w.Write("OK")
+	}))
+	addr := startServer(t, srv)
+
+	conn, r := dialServer(t, addr)
+	if _, err := io.WriteString(conn, "*1\r\n$4\r\nPING\r\n*1\r\n$5\r\nPANIC\r\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := fred.Read(r); resp.Err != nil {
+		t.Fatal("unexpected error:", resp.Err)
+	}
+
+	resp := fred.Read(r)
+	if !resp.IsType(fred.Err) || resp.Err.Error() != "SERVERERR handler panicked" {
+		t.Fatalf("reply = %#v; want SERVERERR", resp)
+	}
+
+	if resp := fred.Read(r); resp.Err != io.ErrUnexpectedEOF {
+		t.Errorf("expected connection to be closed; got %#v", resp)
+	}
+
+	if n := srv.Panics(); n != 1 {
+		t.Errorf("Panics() = %d; want 1", n)
+	}
+
+	// Other connections are unaffected.
+	conn, r = dialServer(t, addr)
+	io.WriteString(conn, "*1\r\n$4\r\nPING\r\n")
+	if resp := fred.Read(r); resp.Err != nil {
+		t.Fatal("unexpected error:", resp.Err)
+	}
+}
+
+func TestServerMaxConns(t *testing.T) {
+	srv := NewServer(HandlerFunc(func(w ResponseWriter, r fred.Resp) error {
+		return w.Write("OK")
+	}))
+	srv.MaxConns = 1
+	addr := startServer(t, srv)
+
+	conn, r := dialServer(t, addr)
+	io.WriteString(conn, "*1\r\n$4\r\nPING\r\n")
+	if resp := fred.Read(r); resp.Err != nil {
+		t.Fatal("unexpected error:", resp.Err)
+	}
+
+	_, r = dialServer(t, addr)
+	resp := fred.Read(r)
+	if !resp.IsType(fred.Err) || resp.Err.Error() != "ERR max number of clients reached" {
+		t.Fatalf("reply = %#v; want max clients error", resp)
+	}
+}
+
+func TestServerIdleTimeout(t *testing.T) {
+	srv := NewServer(HandlerFunc(func(w ResponseWriter, r fred.Resp) error {
+		return w.Write("OK")
+	}))
+	srv.IdleTimeout = 10 * time.Millisecond
+	addr := startServer(t, srv)
+
+	conn, r := dialServer(t, addr)
+	io.WriteString(conn, "*1\r\n$4\r\nPING\r\n")
+	if resp := fred.Read(r); resp.Err != nil {
+		t.Fatal("unexpected error:", resp.Err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if resp := fred.Read(r); resp.Err != io.ErrUnexpectedEOF {
+		t.Errorf("expected idle connection to be closed; got %#v", resp)
+	}
+}
+
+// closeTrackingListener records when it's closed and signals the first call to Accept.
+type closeTrackingListener struct {
+	net.Listener
+	accepting  chan struct{}
+	acceptOnce sync.Once
+	closed     int32
+}
+
+func (l *closeTrackingListener) Accept() (net.Conn, error) {
+	l.acceptOnce.Do(func() { close(l.accepting) })
+	return l.Listener.Accept()
+}
+
+func (l *closeTrackingListener) Close() error {
+	atomic.StoreInt32(&l.closed, 1)
+	return l.Listener.Close()
+}
+
+func TestServerCloseWaitsForServe(t *testing.T) {
+	listen := func() *closeTrackingListener {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &closeTrackingListener{Listener: l, accepting: make(chan struct{})}
+	}
+
+	srv := NewServer(HandlerFunc(func(w ResponseWriter, r fred.Resp) error {
+		return w.Write("OK")
+	}))
+	l := listen()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.Serve(l)
+	}()
+	<-l.accepting
+
+	srv.Close()
+	if atomic.LoadInt32(&l.closed) == 0 {
+		t.Error("Close returned before Serve closed its listener")
+	}
+	<-done
+
+	// Serve after Close closes the listener and returns at once.
+	l = listen()
+	if err := srv.Serve(l); err != nil || atomic.LoadInt32(&l.closed) == 0 {
+		t.Errorf("Serve() after Close = %v, closed = %v; want nil, true", err, l.closed == 1)
+	}
+}
+
+// pipeListener is a listener whose connections are created by dial.
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+// dial returns the client end of a connection accepted by the listener. The server's end is passed to wrap first.
+func (l *pipeListener) dial(wrap func(net.Conn) net.Conn) net.Conn {
+	server, client := net.Pipe()
+	l.conns <- wrap(server)
+	return client
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, ListenerClosedErr{}
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: "pipe", Net: "pipe"}
+}
+
+// writeTrackingConn signals its first write and records when it's closed.
+type writeTrackingConn struct {
+	net.Conn
+	writing   chan struct{}
+	writeOnce sync.Once
+	closed    int32
+}
+
+func (c *writeTrackingConn) Write(p []byte) (int, error) {
+	c.writeOnce.Do(func() { close(c.writing) })
+	return c.Conn.Write(p)
+}
+
+func (c *writeTrackingConn) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+	return c.Conn.Close()
+}
+
+func TestServerCloseWaitsForRejects(t *testing.T) {
+	srv := NewServer(HandlerFunc(func(w ResponseWriter, r fred.Resp) error {
+		return w.Write("OK")
+	}))
+	srv.MaxConns = 1
+	l := newPipeListener()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.Serve(l)
+	}()
+	defer func() { <-done }()
+
+	active := l.dial(func(c net.Conn) net.Conn { return c })
+	defer active.Close()
+
+	rejected := &writeTrackingConn{writing: make(chan struct{})}
+	client := l.dial(func(c net.Conn) net.Conn {
+		rejected.Conn = c
+		return rejected
+	})
+	defer client.Close()
+
+	// The rejection is blocked writing until the client reads it, which happens only after Close is called.
+	<-rejected.writing
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		io.Copy(io.Discard, client)
+	}()
+
+	srv.Close()
+	if atomic.LoadInt32(&rejected.closed) == 0 {
+		t.Error("Close returned before the rejected connection was closed")
+	}
+}
@@ -0,0 +1,287 @@
+package resv
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nilium/fred"
+)
+
+// maxTrackedCommands is the maximum number of distinct command names tracked by a Server. Commands seen after the
+// limit is reached are counted under otherCommand, so that clients sending garbage can't grow the table forever.
+const maxTrackedCommands = 1024
+
+// otherCommand is the name under which untracked commands are counted.
+const otherCommand = "OTHER"
+
+// Stats is a snapshot of a Server's statistics.
+type Stats struct {
+	// ConnectedClients is the number of currently open connections.
+	ConnectedClients int64
+	// TotalConnections is the number of connections accepted, not including rejected connections.
+	TotalConnections uint64
+	// RejectedConnections is the number of connections rejected due to MaxConns.
+	RejectedConnections uint64
+	// CommandsProcessed is the number of commands passed to the Handler.
+	CommandsProcessed uint64
+	// NetInputBytes and NetOutputBytes are the number of bytes read from and written to clients.
+	NetInputBytes  uint64
+	NetOutputBytes uint64
+	// ErrorReplies is the number of error replies sent to clients.
+	ErrorReplies uint64
+	// Panics is the number of panics recovered from the Handler.
+	Panics uint64
+
+	// Commands holds call counts and latencies by upper-cased command name.
+	Commands map[string]CommandStats
+	// Errors holds the number of error replies by error code (the first word of the error, e.g., "ERR" or "WRONGTYPE").
+	Errors map[string]uint64
+}
+
+// CommandStats holds statistics for a single command.
+type CommandStats struct {
+	Calls    uint64
+	Duration time.Duration
+}
+
+// PerCall returns the mean duration of a call.
+func (c CommandStats) PerCall() time.Duration {
+	if c.Calls == 0 {
+		return 0
+	}
+	return c.Duration / time.Duration(c.Calls)
+}
+
+// serverStats holds the counters backing Stats. The uint64 fields are accessed atomically and must remain at the start
+// of the struct for 64-bit alignment.
+type serverStats struct {
+	panics   uint64
+	accepted uint64
+	rejected uint64
+	commands uint64
+	bytesIn  uint64
+	bytesOut uint64
+	errors   uint64
+	active   int64
+
+	mu   sync.Mutex
+	cmds map[string]*CommandStats
+	errs map[string]uint64
+}
+
+func (s *serverStats) command(name string, dur time.Duration) {
+	atomic.AddUint64(&s.commands, 1)
+	if name == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cmds == nil {
+		s.cmds = make(map[string]*CommandStats)
+	}
+
+	cs := s.cmds[name]
+	if cs == nil {
+		if len(s.cmds) >= maxTrackedCommands {
+			name = otherCommand
+			cs = s.cmds[name]
+		}
+		if cs == nil {
+			cs = new(CommandStats)
+			s.cmds[name] = cs
+		}
+	}
+	cs.Calls++
+	cs.Duration += dur
+}
+
+// reply records a reply written to a client. If the reply is an error, it is counted under its error code.
+func (s *serverStats) reply(msg []byte) {
+	if len(msg) == 0 || msg[0] != '-' {
+		return
+	}
+	atomic.AddUint64(&s.errors, 1)
+
+	code := msg[1:]
+	if i := bytes.IndexAny(code, " \r"); i != -1 {
+		code = code[:i]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.errs == nil {
+		s.errs = make(map[string]uint64)
+	}
+
+	// Codes are also client-controlled, so bound them the same as commands.
+	key := string(code)
+	if _, ok := s.errs[key]; !ok && len(s.errs) >= maxTrackedCommands {
+		key = otherCommand
+	}
+	s.errs[key]++
+}
+
+func (s *serverStats) snapshot() Stats {
+	st := Stats{
+		ConnectedClients:    atomic.LoadInt64(&s.active),
+		TotalConnections:    atomic.LoadUint64(&s.accepted),
+		RejectedConnections: atomic.LoadUint64(&s.rejected),
+		CommandsProcessed:   atomic.LoadUint64(&s.commands),
+		NetInputBytes:       atomic.LoadUint64(&s.bytesIn),
+		NetOutputBytes:      atomic.LoadUint64(&s.bytesOut),
+		ErrorReplies:        atomic.LoadUint64(&s.errors),
+		Panics:              atomic.LoadUint64(&s.panics),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st.Commands = make(map[string]CommandStats, len(s.cmds))
+	for name, cs := range s.cmds {
+		st.Commands[name] = *cs
+	}
+
+	st.Errors = make(map[string]uint64, len(s.errs))
+	for code, n := range s.errs {
+		st.Errors[code] = n
+	}
+
+	return st
+}
+
+// Stats returns a snapshot of the Server's statistics.
+func (s *Server) Stats() Stats {
+	return s.stats.snapshot()
+}
+
+// Info
+
+// Info builds a reply to an INFO command. Each section begins with a call to Section, followed by calls to Add for
+// each of its fields. Info implements Marshaler and encodes as a bulk string.
+type Info struct {
+	buf bytes.Buffer
+}
+
+// Section begins a new section with the given name.
+func (i *Info) Section(name string) {
+	if i.buf.Len() > 0 {
+		i.buf.WriteString("\r\n")
+	}
+	fmt.Fprintf(&i.buf, "# %s\r\n", name)
+}
+
+// Add adds a field to the current section. The value is formatted using its default format.
+func (i *Info) Add(key string, value interface{}) {
+	fmt.Fprintf(&i.buf, "%s:%v\r\n", key, value)
+}
+
+func (i *Info) String() string {
+	return i.buf.String()
+}
+
+func (i *Info) MarshalRESP() (interface{}, error) {
+	return i.buf.Bytes(), nil
+}
+
+// InfoFunc adds the named section to info, if it provides it. The section name is always lower-case.
+type InfoFunc func(info *Info, section string)
+
+// Default INFO sections provided by a Server.
+var serverInfoSections = []string{"clients", "stats", "commandstats", "errorstats"}
+
+// AppendInfo adds the named section of st to info. Supported sections are clients, stats, commandstats, and
+// errorstats. Unsupported sections are ignored.
+func (st Stats) AppendInfo(info *Info, section string) {
+	switch section {
+	case "clients":
+		info.Section("Clients")
+		info.Add("connected_clients", st.ConnectedClients)
+
+	case "stats":
+		info.Section("Stats")
+		info.Add("total_connections_received", st.TotalConnections)
+		info.Add("total_commands_processed", st.CommandsProcessed)
+		info.Add("total_net_input_bytes", st.NetInputBytes)
+		info.Add("total_net_output_bytes", st.NetOutputBytes)
+		info.Add("rejected_connections", st.RejectedConnections)
+		info.Add("total_error_replies", st.ErrorReplies)
+		info.Add("total_panics", st.Panics)
+
+	case "commandstats":
+		info.Section("Commandstats")
+		for _, name := range sortedKeys(st.Commands) {
+			cs := st.Commands[name]
+			usec := cs.Duration.Nanoseconds() / 1e3
+			info.Add("cmdstat_"+strings.ToLower(name), fmt.Sprintf("calls=%d,usec=%d,usec_per_call=%.2f",
+				cs.Calls, usec, float64(usec)/float64(cs.Calls)))
+		}
+
+	case "errorstats":
+		info.Section("Errorstats")
+		codes := make([]string, 0, len(st.Errors))
+		for code := range st.Errors {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		for _, code := range codes {
+			info.Add("errorstat_"+code, fmt.Sprintf("count=%d", st.Errors[code]))
+		}
+	}
+}
+
+func sortedKeys(m map[string]CommandStats) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// InfoHandler returns a Handler that replies to `INFO [section ...]` commands with the Server's statistics and any
+// sections provided by extra. If no sections are requested, or "all", "default", or "everything" is requested, all
+// sections are included; extra funcs are then called with an empty section name.
+func (s *Server) InfoHandler(extra ...InfoFunc) Handler {
+	return HandlerFunc(func(w ResponseWriter, r fred.Resp) error {
+		args, err := r.StrList()
+		if err != nil {
+			return w.Write(fred.Error("ERR invalid INFO command"))
+		}
+
+		sections := make([]string, 0, len(args))
+		for _, arg := range args[1:] {
+			arg = strings.ToLower(arg)
+			if arg == "all" || arg == "default" || arg == "everything" {
+				sections = nil
+				break
+			}
+			sections = append(sections, arg)
+		}
+
+		var info Info
+		st := s.Stats()
+		if len(sections) == 0 {
+			for _, section := range serverInfoSections {
+				st.AppendInfo(&info, section)
+			}
+			for _, fn := range extra {
+				fn(&info, "")
+			}
+			return w.Write(&info)
+		}
+
+		for _, section := range sections {
+			st.AppendInfo(&info, section)
+			for _, fn := range extra {
+				fn(&info, section)
+			}
+		}
+		return w.Write(&info)
+	})
+}
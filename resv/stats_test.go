@@ -0,0 +1,67 @@
+package resv
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/nilium/fred"
+)
+
+func TestServerStats(t *testing.T) {
+	var srv *Server
+	srv = NewServer(HandlerFunc(func(w ResponseWriter, r fred.Resp) error {
+		switch CommandName(r) {
+		case "INFO":
+			return srv.InfoHandler(func(info *Info, section string) {
+				if section == "" || section == "custom" {
+					info.Section("Custom")
+					info.Add("answer", 42)
+				}
+			}).ServeRESP(w, r)
+		case "PING":
+			return w.Write("PONG")
+		}
+		return w.Write(fred.Error("ERR unknown command"))
+	}))
+	addr := startServer(t, srv)
+
+	conn, r := dialServer(t, addr)
+	io.WriteString(conn, "*1\r\n$4\r\nPING\r\n*1\r\n$4\r\nping\r\n*1\r\n$4\r\nNOPE\r\n")
+	for i := 0; i < 3; i++ {
+		fred.Read(r)
+	}
+
+	st := srv.Stats()
+	if st.ConnectedClients != 1 || st.TotalConnections != 1 {
+		t.Errorf("clients = %d, connections = %d; want 1, 1", st.ConnectedClients, st.TotalConnections)
+	}
+	if st.CommandsProcessed != 3 {
+		t.Errorf("CommandsProcessed = %d; want 3", st.CommandsProcessed)
+	}
+	if n := st.Commands["PING"].Calls; n != 2 {
+		t.Errorf("PING calls = %d; want 2", n)
+	}
+	if n := st.Errors["ERR"]; n != 1 || st.ErrorReplies != 1 {
+		t.Errorf("ERR count = %d, ErrorReplies = %d; want 1, 1", n, st.ErrorReplies)
+	}
+	if st.NetInputBytes != 42 {
+		t.Errorf("NetInputBytes = %d; want 42", st.NetInputBytes)
+	}
+	if want := uint64(len("$4\r\nPONG\r\n")*2 + len("-ERR unknown command\r\n")); st.NetOutputBytes != want {
+		t.Errorf("NetOutputBytes = %d; want %d", st.NetOutputBytes, want)
+	}
+
+	io.WriteString(conn, "*3\r\n$4\r\nINFO\r\n$12\r\ncommandstats\r\n$6\r\ncustom\r\n")
+	var info string
+	if err := fred.Scan(r, &info); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%s", info)
+
+	if !strings.HasPrefix(info, "# Commandstats\r\ncmdstat_nope:calls=1,") ||
+		!strings.Contains(info, "\r\ncmdstat_ping:calls=2,") ||
+		!strings.HasSuffix(info, "\r\n\r\n# Custom\r\nanswer:42\r\n") {
+		t.Errorf("unexpected INFO reply: %q", info)
+	}
+}
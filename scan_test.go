@@ -49,6 +49,21 @@ func TestArrayRead(t *testing.T) {
 	t.Logf("%#v", resp)
 }
 
+func TestNullArrayRead(t *testing.T) {
+	msg := bytes.NewBufferString("*-1\r\n*1\r\n*-1\r\n")
+	if resp := Read(msg); resp.Err != nil || !resp.IsType(Nil) {
+		t.Fatalf("Read() = %#v; want a Nil Resp", resp)
+	}
+
+	resp := Read(msg)
+	ary, err := resp.Array()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(ary) != 1 || !ary[0].IsType(Nil) {
+		t.Fatalf("Read() = %#v; want an array holding a Nil Resp", resp)
+	}
+}
+
 func TestBytesList(t *testing.T) {
 	{
 		msg := bytes.NewBufferString("*4\r\n+Key 1\r\n$4\r\n1234\r\n$5\r\nKey 2\r\n$8\r\n45678910\r\n")
@@ -161,3 +176,30 @@ func TestSimpleString(t *testing.T) {
 	}
 	t.Logf("%q", data)
 }
+
+func TestRespScan(t *testing.T) {
+	resp := Read(bytes.NewBufferString("*4\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n:2\r\n"))
+	var data map[string]int
+	if err := resp.Scan(&data); err != nil {
+		t.Fatal(err)
+	} else if len(data) != 2 || data["a"] != 1 || data["b"] != 2 {
+		t.Errorf("Scan() = %v; want map[a:1 b:2]", data)
+	}
+
+	resp = Read(bytes.NewBufferString("-ERR failed\r\n"))
+	if err := resp.Scan(&data); err != Error("ERR failed") {
+		t.Errorf("Scan() error = %v; want the error reply", err)
+	}
+}
+
+func TestEmptyArrayScan(t *testing.T) {
+	m := map[string]string{"stale": "value"}
+	s := []string{"stale"}
+	a := [2]int{1, 2}
+	if err := Scan(bytes.NewBufferString("*0\r\n*0\r\n*0\r\n"), &m, &s, &a); err != nil {
+		t.Fatal(err)
+	}
+	if m != nil || s != nil || a != [2]int{} {
+		t.Errorf("Scan() = %v, %v, %v; want zero values", m, s, a)
+	}
+}
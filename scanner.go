@@ -106,7 +106,7 @@ func scan(dst interface{}, resp Resp) (err error) {
 		if !ok {
 			return errWrongType
 		} else if len(ary) == 0 {
-			outer.Set(reflect.Zero(val.Type()))
+			val.Set(reflect.Zero(val.Type()))
 			return nil
 		}
 		val = reflect.MakeSlice(val.Type(), len(ary), len(ary))
@@ -122,7 +122,7 @@ func scan(dst interface{}, resp Resp) (err error) {
 		if !ok {
 			return errWrongType
 		} else if len(ary) == 0 {
-			outer.Set(reflect.Zero(val.Type()))
+			val.Set(reflect.Zero(val.Type()))
 			return nil
 		} else if len(ary) > val.Len() {
 			return ErrArrayLength
@@ -144,7 +144,7 @@ func scan(dst interface{}, resp Resp) (err error) {
 		if !ok {
 			return errWrongType
 		} else if len(ary) == 0 {
-			outer.Set(reflect.Zero(val.Type()))
+			val.Set(reflect.Zero(val.Type()))
 			return nil
 		} else if len(ary)&1 == 1 {
 			return ErrMapLength
@@ -178,6 +178,15 @@ func scan(dst interface{}, resp Resp) (err error) {
 	return nil
 }
 
+// Scan stores the value of r in dst, which must be a pointer, as Scan does for each reply it reads. If r.Err is set, it
+// is returned instead.
+func (r Resp) Scan(dst interface{}) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	return scan(dst, r)
+}
+
 func Scan(r ByteScanner, dst ...interface{}) error {
 	for _, target := range dst {
 		val := Read(r)